@@ -98,11 +98,12 @@ func saveToken(file string, token *oauth2.Token) {
 }
 
 // Authenticate authenticates the application with Google Drive
-// server and returns a *drive.Service for further operation.
+// server and returns a *drive.Service for further operation, along
+// with the authenticated *http.Client it is built on.
 //
 // Note: Authenticate expects a populated C.Config. Remember to
 // call C.ReadConfig before calling this function.
-func Authenticate() *drive.Service {
+func Authenticate() (*drive.Service, *http.Client) {
 	conf := C.Config.Get()
 	ctx := context.Background()
 
@@ -121,5 +122,5 @@ func Authenticate() *drive.Service {
 	if err != nil {
 		log.Fatalf("Unable to retrieve drive Client: %v", err)
 	}
-	return srv
+	return srv, client
 }
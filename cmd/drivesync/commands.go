@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+// list lists the folder at the given path in the archive, e.g. a category.
+func list(args []string) {
+	readConfig()
+	conf := C.Config.Get()
+	fs := newFlagSet("ls", "[options] [<category>[/<path>]]")
+	archiveFlags(fs, &conf.ArchiveRootName, &conf.Backend, &conf.LocalRoot)
+	ids := fs.Bool("ids", false, "show the IDs of objects")
+	fs.Parse(args)
+	C.Config.Set(conf)
+	applyFlags(fs)
+
+	b := openBackend()
+	entries, err := R.List(b, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to list '%s': %v", fs.Arg(0), err)
+	}
+	for _, v := range entries {
+		size, name := "-", v.Name
+		if v.IsDir {
+			name += "/"
+		} else {
+			size = strconv.FormatInt(v.Size, 10)
+		}
+		if *ids {
+			fmt.Printf("%-44s ", v.ID)
+		}
+		fmt.Printf("%12s  %s\n", size, name)
+	}
+}
+
+// status shows the sync state of the given paths, or of the objects in them for directories
+// that are not synced themselves, like the target of drivesyncd.
+func status(args []string) {
+	readConfig()
+	fs := newFlagSet("status", "[options] [<path>...]")
+	recursive := fs.Bool("r", false, "show the paths under synced directories as well")
+	fs.Parse(args)
+
+	openState()
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, v := range paths {
+		path, err := filepath.Abs(v)
+		if err != nil {
+			log.Fatalf("Failed to resolve '%s': %v", v, err)
+		}
+		rec, ok, err := S.Get(path)
+		if err != nil {
+			log.Fatalf("Failed to check sync state: %v", err)
+		} else if ok {
+			printStatus(os.Stdout, rec, true)
+			if *recursive && rec.IsDir {
+				err := S.Walk(path, func(rec S.Record) error {
+					if rec.Path != path {
+						printStatus(os.Stdout, rec, true)
+					}
+					return nil
+				})
+				if err != nil {
+					log.Fatalf("Failed to read sync state: %v", err)
+				}
+			}
+			continue
+		}
+		if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+			printStatus(os.Stdout, S.Record{Path: path}, false)
+			continue
+		}
+		children, err := ioutil.ReadDir(path)
+		if err != nil {
+			log.Fatalf("Failed to read directory '%s': %v", path, err)
+		}
+		for _, fi := range children {
+			child := filepath.Join(path, fi.Name())
+			if C.Config.Get().Ignored(child) || strings.HasPrefix(fi.Name(), S.MarkPrefix) {
+				continue
+			}
+			rec, ok, err := S.Get(child)
+			if err != nil {
+				log.Fatalf("Failed to check sync state: %v", err)
+			} else if !ok {
+				rec = S.Record{Path: child}
+			}
+			printStatus(os.Stdout, rec, ok)
+		}
+	}
+}
+
+// printStatus prints a line to w on the sync state of rec.Path, which is recorded as rec if synced
+// is true: "synced", "changed" for files changed since, "missing" for paths gone since, or
+// "unsynced".
+func printStatus(w io.Writer, rec S.Record, synced bool) {
+	state, category, syncTime := "unsynced", "-", "-"
+	if synced {
+		state, category = "synced", rec.Category
+		if category == "" {
+			category = "?"
+		}
+		if !rec.SyncTime.IsZero() {
+			syncTime = rec.SyncTime.Local().Format("2006-01-02 15:04")
+		}
+		if fi, err := os.Stat(rec.Path); err != nil {
+			state = "missing"
+		} else if !rec.IsDir && (fi.Size() != rec.Size || !fi.ModTime().Equal(rec.ModTime)) {
+			state = "changed"
+		}
+	}
+	fmt.Fprintf(w, "%-9s %-16s %-16s  %s\n", state, category, syncTime, rec.Path)
+}
+
+// verify audits the copies of the given paths in the archive against the local trees, and exits
+// with status 1 if there's any discrepancy.
+func verify(args []string) {
+	readConfig()
+	conf := C.Config.Get()
+	fs := newFlagSet("verify", "[options] <path>...")
+	archiveFlags(fs, &conf.ArchiveRootName, &conf.Backend, &conf.LocalRoot)
+	fs.StringVar(&conf.DefaultCategory, "category", conf.DefaultCategory, "category of paths not in the sync state")
+	asJSON := fs.Bool("json", false, "print the reports as JSON")
+	fs.Parse(args)
+	C.Config.Set(conf)
+	applyFlags(fs)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	openState()
+	if status := verifyPaths(openBackend(), fs.Args(), *asJSON, os.Stdout); status != 0 {
+		os.Exit(status)
+	}
+}
+
+// verifyPaths writes the reports of verifying paths on b to w, returning the exit status of
+// verify: 1 if there's any discrepancy, 0 otherwise.
+func verifyPaths(b R.Backend, paths []string, asJSON bool, w io.Writer) int {
+	var reports []*R.VerifyReport
+	problems := 0
+	for _, v := range paths {
+		path, err := filepath.Abs(v)
+		if err != nil {
+			log.Fatalf("Failed to resolve '%s': %v", v, err)
+		}
+		// the default category of the target containing path, unless given
+		report, err := R.Verify(b, path, C.Config.Get().ForPath(path).DefaultCategory)
+		if err != nil {
+			log.Fatalf("Failed to verify '%s': %v", path, err)
+		}
+		problems += len(report.Entries)
+		if asJSON {
+			reports = append(reports, report)
+		} else {
+			report.WriteText(w)
+		}
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatalf("Failed to write reports: %v", err)
+		}
+	}
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+// restore downloads the object at the given path in the archive into a local directory.
+func restore(args []string) {
+	readConfig()
+	conf := C.Config.Get()
+	fs := newFlagSet("restore", "[options] <category>/<name> [<directory>]")
+	archiveFlags(fs, &conf.ArchiveRootName, &conf.Backend, &conf.LocalRoot)
+	fs.BoolVar(&conf.Verbose, "verbose", conf.Verbose, "verbose output")
+	fs.Parse(args)
+	C.Config.Set(conf)
+	applyFlags(fs)
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dest := "."
+	if fs.NArg() == 2 {
+		dest = fs.Arg(1)
+	}
+
+	b := openBackend()
+	if err := R.Restore(b, fs.Arg(0), dest); err != nil {
+		log.Fatalf("Failed to restore '%s': %v", fs.Arg(0), err)
+	}
+	fmt.Printf("Restored '%s' into '%s'.\n", fs.Arg(0), dest)
+}
+
+// forget clears the sync state of the given paths.
+func forget(args []string) {
+	readConfig()
+	fs := newFlagSet("forget", "<path>...")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	openState()
+	for _, v := range fs.Args() {
+		path, err := filepath.Abs(v)
+		if err != nil {
+			log.Fatalf("Failed to resolve '%s': %v", v, err)
+		}
+		if err := S.Forget(path); err != nil {
+			log.Fatalf("Failed to forget '%s': %v", path, err)
+		}
+		fmt.Printf("Forgot '%s'.\n", path)
+	}
+}
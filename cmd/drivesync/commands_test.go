@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "drivesync-state")
+	if err != nil {
+		panic(err)
+	}
+	if err := S.Open(filepath.Join(dir, "state.db")); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	S.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// tempDir returns a new temporary directory, removed at the end of the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "drivesync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestPrintStatus(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "a.flac")
+	if err := ioutil.WriteFile(path, []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncTime := time.Date(2020, 1, 2, 3, 4, 0, 0, time.Local)
+	synced := S.Record{Path: path, Category: "Music", Size: 3, ModTime: fi.ModTime(), SyncTime: syncTime}
+	changed := synced
+	changed.Size = 4
+	touched := synced
+	touched.ModTime = fi.ModTime().Add(-time.Hour)
+	uncategorized := synced
+	uncategorized.Category, uncategorized.SyncTime = "", time.Time{}
+	directory := S.Record{Path: dir, IsDir: true, Category: "Music", SyncTime: syncTime}
+	for _, c := range []struct {
+		rec    S.Record
+		synced bool
+		want   string
+	}{
+		{S.Record{Path: path}, false, "unsynced - - " + path},
+		{synced, true, "synced Music 2020-01-02 03:04 " + path},
+		{changed, true, "changed Music 2020-01-02 03:04 " + path},
+		{touched, true, "changed Music 2020-01-02 03:04 " + path},
+		{uncategorized, true, "synced ? - " + path},
+		// the size of directories doesn't matter
+		{directory, true, "synced Music 2020-01-02 03:04 " + dir},
+		{S.Record{Path: filepath.Join(dir, "gone"), Category: "Music"}, true,
+			"missing Music - " + filepath.Join(dir, "gone")},
+	} {
+		var b bytes.Buffer
+		printStatus(&b, c.rec, c.synced)
+		if got := strings.Join(strings.Fields(b.String()), " "); got != c.want {
+			t.Errorf("status %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestVerifyExitStatus(t *testing.T) {
+	s := drivetest.NewServer()
+	defer s.Close()
+	srv, err := s.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := R.NewDriveBackend(srv, s.Client())
+	conf := C.NewConfig()
+	conf.ArchiveRootName = "archive"
+	conf.CreateMissing = true
+	C.Config.Set(conf)
+	C.ArchiveRootIDs = U.NewSafeMap()
+	C.CategoryIDs = U.NewSafeMap()
+	C.PathIDs = U.NewSafeMap()
+	src := tempDir(t)
+	path := filepath.Join(src, "Album")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.flac": "aaa", "b.flac": "bbb"} {
+		if err := ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := R.SyncDirectory(nil, b, path, "Music"); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	var out bytes.Buffer
+	if status := verifyPaths(b, []string{path}, false, &out); status != 0 {
+		t.Errorf("exit status %d of intact copy, want 0:\n%s", status, out.String())
+	}
+	if !strings.Contains(out.String(), "2 file(s) checked, 0 problem(s)") {
+		t.Errorf("report:\n%s", out.String())
+	}
+	// same size, other content
+	if err := ioutil.WriteFile(filepath.Join(path, "b.flac"), []byte("BBB"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if status := verifyPaths(b, []string{path}, true, &out); status != 1 {
+		t.Errorf("exit status %d of mismatching copy, want 1", status)
+	}
+	var reports []R.VerifyReport
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatalf("malformed reports %s: %v", out.String(), err)
+	}
+	if len(reports) != 1 || len(reports[0].Entries) != 1 ||
+		reports[0].Entries[0].Problem != R.VerifyChecksumMismatch {
+		t.Errorf("reports %+v, want a checksum mismatch", reports)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	A "github.com/KireinaHoro/DriveSync/auth"
+	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+// controlTimeout bounds the time an operation through the control socket of `drivesyncd` may
+// take.
+const controlTimeout = 10 * time.Second
+
+// A command is a subcommand of drivesync.
+type command struct {
+	name string
+	help string
+	run  func(args []string)
+}
+
+// commands are the subcommands of drivesync, in the order they're listed in the usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"push", "upload a file or directory to the archive", push},
+		{"ls", "list the archive", list},
+		{"status", "show the sync state of local paths", status},
+		{"verify", "check synced files against their copies in the archive", verify},
+		{"restore", "download an object from the archive", restore},
+		{"forget", "clear the sync state of local paths, so that they get synced again", forget},
+	}
+}
+
+// usage prints the usage of drivesync.
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "\nUsage: %s <command> [options] [arguments]\n\nCommands:\n\n", name)
+	for _, v := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s%s\n", v.name, v.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a command, %s works as '%s push'. "+
+		"Use '%s <command> -h' for the options of a command.\n", name, name, name)
+}
+
+// newFlagSet returns the flag set of the named command, with a usage mentioning args.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s %s %s\n\n", filepath.Base(os.Args[0]), name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// archiveFlags adds the flags choosing the archive to fs.
+func archiveFlags(fs *flag.FlagSet, root, backend, localRoot *string) {
+	fs.StringVar(root, "root", *root, "name of the archive root")
+	fs.StringVar(backend, "backend", *backend, `archive backend, "drive" or "local"`)
+	fs.StringVar(localRoot, "local-root", *localRoot, "directory to archive into for local backend")
+}
+
+// readConfig reads the configuration file, for the flags of commands to override; see
+// applyFlags.
+func readConfig() {
+	if err := C.ReadConfig(false); err != nil {
+		log.Fatalf("Failed to read config: %v", err)
+	}
+}
+
+// applyFlags has the flags given in fs, once parsed into the configuration, take precedence over
+// the options of the targets of `drivesyncd` as well, which apply to the objects in them
+// otherwise; see C.Config.ForPath.
+func applyFlags(fs *flag.FlagSet) {
+	conf := C.Config.Get()
+	targets := make([]C.TargetConfig, len(conf.Targets))
+	copy(targets, conf.Targets)
+	fs.Visit(func(f *flag.Flag) {
+		for i := range targets {
+			switch t := &targets[i]; f.Name {
+			case "root":
+				t.ArchiveRoot = conf.ArchiveRootName
+			case "category":
+				t.DefaultCategory = conf.DefaultCategory
+			case "recheck":
+				t.ForceRecheck = &conf.ForceRecheck
+			case "create-missing":
+				t.CreateMissing = &conf.CreateMissing
+			}
+		}
+	})
+	conf.Targets = targets
+	C.Config.Set(conf)
+}
+
+// openState opens the sync state database, or has `drivesyncd` carry out the operations on it
+// through its control socket if the daemon holds it open.
+func openState() {
+	conf := C.Config.Get()
+	err := S.Open(conf.StateFile)
+	if err == S.ErrInUse {
+		if _, serr := os.Stat(conf.ControlSocket()); serr == nil {
+			S.OpenVia(callDaemon)
+			return
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to open sync state: %v", err)
+	}
+}
+
+// callDaemon sends the operation on the sync state to `drivesyncd` through its control socket.
+func callDaemon(req S.Request) (S.Response, error) {
+	conn, err := net.DialTimeout("unix", C.Config.Get().ControlSocket(), controlTimeout)
+	if err != nil {
+		return S.Response{}, errors.New(fmt.Sprintf("failed to connect to drivesyncd: %v", err))
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	err = json.NewEncoder(conn).Encode(struct {
+		Command string    `json:"command"`
+		State   S.Request `json:"state"`
+	}{"state", req})
+	if err != nil {
+		return S.Response{}, errors.New(fmt.Sprintf("failed to send to drivesyncd: %v", err))
+	}
+	var resp struct {
+		Error string      `json:"error"`
+		State *S.Response `json:"state"`
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return S.Response{}, errors.New(fmt.Sprintf("failed to read answer of drivesyncd: %v", err))
+	} else if resp.State == nil {
+		return S.Response{}, errors.New(fmt.Sprintf("drivesyncd: %s", resp.Error))
+	}
+	return *resp.State, nil
+}
+
+// openBackend sets up the configured backend.
+func openBackend() R.Backend {
+	conf := C.Config.Get()
+	if conf.Backend == "local" {
+		b, err := R.NewLocalBackend(conf.LocalRoot)
+		if err != nil {
+			log.Fatalf("Failed to set up local backend: %v", err)
+		}
+		return b
+	}
+	// authenticate to Google Drive server to get *drive.Service
+	return R.NewDriveBackend(A.Authenticate())
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage()
+			return
+		}
+		for _, v := range commands {
+			if v.name == args[0] {
+				v.run(args[1:])
+				return
+			}
+		}
+	}
+	// no command; upload the target, as older versions did
+	push(args)
+}
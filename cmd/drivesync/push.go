@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+var (
+	importMarks  string
+	removeMarks  bool
+	trainGuesser bool
+	dryRun       bool
+	planJSON     bool
+)
+
+// initPushFlags initializes the command-line arguments of push.
+func initPushFlags(fs *flag.FlagSet, args []string) {
+	conf := C.Config.Get()
+
+	archiveFlags(fs, &conf.ArchiveRootName, &conf.Backend, &conf.LocalRoot)
+	fs.StringVar(&conf.DefaultCategory, "category", conf.DefaultCategory, "destination category")
+	fs.BoolVar(&conf.ForceRecheck, "recheck", conf.ForceRecheck, "force file checksum recheck")
+	fs.BoolVar(&conf.Incremental, "incremental", conf.Incremental, "upload new or changed files of synced directories")
+	fs.IntVar(&conf.MaxUploads, "max-concurrent-uploads", conf.MaxUploads, "number of files to upload at a time")
+	fs.Int64Var(&conf.UploadRateLimit, "upload-rate-limit", conf.UploadRateLimit, "upload bandwidth limit in bytes per second, 0 for none")
+	fs.BoolVar(&C.Interactive, "interactive", false, "work interactively")
+	fs.BoolVar(&conf.Verbose, "verbose", conf.Verbose, "verbose output")
+	fs.BoolVar(&conf.CreateMissing, "create-missing", conf.CreateMissing, "create category if not exist")
+	fs.StringVar(&importMarks, "import-marks", "", "import .sync_finished marks under given directory and exit")
+	fs.BoolVar(&removeMarks, "remove-marks", false, "remove the marks imported with -import-marks")
+	fs.BoolVar(&trainGuesser, "train-guesser", false, "train the learning guesser from the archive and exit")
+	fs.BoolVar(&dryRun, "dry-run", false, "print what syncing target would do without changing anything")
+	fs.BoolVar(&planJSON, "json", false, "print the -dry-run plan as JSON")
+
+	fs.Parse(args)
+
+	C.Target = fs.Arg(0)
+	C.Config.Set(conf)
+	applyFlags(fs)
+}
+
+// push uploads the target to the archive; it's what drivesync does without a command.
+func push(args []string) {
+	// process default configurations
+	readConfig()
+
+	// process commandline flags
+	fs := newFlagSet("push", "[options] ( <target> || -interactive )")
+	initPushFlags(fs, args)
+
+	// config used should be get after commandline arguments parse
+	conf := C.Config.Get()
+
+	openState()
+
+	if importMarks != "" {
+		n, err := S.ImportMarks(importMarks, removeMarks)
+		if err != nil {
+			log.Fatalf("Failed to import sync marks: %v", err)
+		}
+		fmt.Printf("Imported %d sync mark(s).\n", n)
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	// we need to do this manually for old runtime
+	if conf.Verbose && !planJSON {
+		fmt.Println("Procs usable:", runtime.NumCPU())
+	}
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	b := openBackend()
+
+	if trainGuesser {
+		model, err := R.TrainGuesser(b)
+		if err != nil {
+			log.Fatalf("Failed to train guesser: %v", err)
+		}
+		fmt.Printf("Trained guesser on %d categories, saved to '%s'.\n", len(model.Categories), conf.GuesserModel)
+		return
+	}
+
+	var info os.FileInfo
+	var err error
+
+	if C.Interactive {
+		fmt.Print("Enter target to sync, in absolute path: ")
+		C.Target, err = reader.ReadString('\n')
+		C.Target = strings.TrimRight(C.Target, "\n")
+		if err != nil {
+			log.Fatalf("Failed to scan: %v", err)
+		}
+		info, err = os.Stat(C.Target)
+		if err != nil {
+			log.Fatalf("Failed to stat target '%s': %v", C.Target, err)
+		}
+		fmt.Print("Enter desired category: ")
+		conf.DefaultCategory, err = reader.ReadString('\n')
+		conf.DefaultCategory = strings.TrimRight(conf.DefaultCategory, "\n")
+		if err != nil {
+			log.Fatalf("Failed to scan: %v", err)
+		}
+	} else {
+		if C.Target == "" {
+			fmt.Fprintln(os.Stderr, "Please specify target properly.")
+			fs.Usage()
+			os.Exit(1)
+		}
+		if C.Target[0] != '/' {
+			pwd, err := os.Getwd()
+			if err != nil {
+				log.Fatalf("Failed to get current working directory: %v", err)
+			}
+			C.Target = filepath.Clean(pwd + "/" + C.Target)
+		}
+		info, err = os.Stat(C.Target)
+		if err != nil {
+			log.Fatalf("Failed to stat target '%s': %v", C.Target, err)
+		}
+		// the default category of the target of drivesyncd containing it, unless given
+		conf.DefaultCategory = conf.ForPath(C.Target).DefaultCategory
+	}
+	if err := C.CheckCategory(conf.DefaultCategory); err != nil {
+		log.Fatalf("Invalid category: %v", err)
+	}
+	if dryRun {
+		plan, err := R.PlanSync(b, C.Target, conf.DefaultCategory)
+		if err != nil {
+			log.Fatalf("Failed to plan sync of '%s': %v", C.Target, err)
+		}
+		if planJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			if err := enc.Encode(plan); err != nil {
+				log.Fatalf("Failed to write plan: %v", err)
+			}
+		} else {
+			plan.WriteText(os.Stdout)
+		}
+		return
+	}
+	if info.IsDir() {
+		fmt.Printf("Syncing directory '%s'...\n", C.Target)
+		err = R.SyncDirectory(reader, b, C.Target, conf.DefaultCategory)
+	} else {
+		fmt.Printf("Syncing file '%s'...\n", C.Target)
+		err = R.SyncFile(reader, b, C.Target, conf.DefaultCategory)
+	}
+	if err != nil {
+		if _, ok := err.(E.ErrorSetMarkFailed); ok {
+			log.Printf("Sync succeeded, yet failed to set sync mark: %v", err)
+		} else {
+			log.Fatalf("Failed to sync '%s': %v", C.Target, err)
+		}
+	}
+	fmt.Println("Sync succeeded.")
+}
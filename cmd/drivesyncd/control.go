@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+// controlTimeout bounds the time a connection to the control socket may take.
+const controlTimeout = 10 * time.Second
+
+// controlRequest is what a client sends on the control socket, as a single JSON object per
+// connection.
+type controlRequest struct {
+	Command string `json:"command"`
+	// Job is the ID of the job to cancel
+	Job string `json:"job,omitempty"`
+	// State is the operation on the sync state of the "state" command, which `drivesync` sends
+	// as it can't open the database held by the daemon
+	State *S.Request `json:"state,omitempty"`
+}
+
+// controlResponse is the answer of the daemon to a controlRequest, with the state after
+// carrying it out.
+type controlResponse struct {
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Paused bool        `json:"paused"`
+	Jobs   []jobStatus `json:"jobs"`
+	// State is the result of the operation of the "state" command
+	State *S.Response `json:"state,omitempty"`
+}
+
+// jobStatus describes a job in a controlResponse.
+type jobStatus struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// State is "queued", "waiting", "running" or "cancelling"
+	State string `json:"state"`
+	// Sent is the number of bytes uploaded by the job so far
+	Sent    int64        `json:"sent"`
+	Uploads []R.Transfer `json:"uploads,omitempty"`
+}
+
+type controlInfo struct {
+	description string
+	handler     func(req controlRequest) error
+}
+
+// controls is the list of commands available on the control socket, which are sent with
+// `drivesyncd -s` as well
+var controls = map[string]controlInfo{
+	"status": {
+		"show the jobs queued and running",
+		func(_ controlRequest) error { return nil },
+	},
+	"pause": {
+		"pause uploads",
+		func(_ controlRequest) error {
+			R.PauseUploads()
+			log.Print("I: Uploads paused.")
+			return nil
+		},
+	},
+	"resume": {
+		"resume uploads",
+		func(_ controlRequest) error {
+			R.ResumeUploads()
+			log.Print("I: Uploads resumed.")
+			return nil
+		},
+	},
+	"cancel": {
+		"cancel the job with given ID",
+		func(req controlRequest) error { return cancelJob(req.Job) },
+	},
+	"rescan": {
+		"queue the objects in targets for syncing",
+		func(_ controlRequest) error {
+			for _, v := range watchedTargets() {
+				if err := scan(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// socketPath returns the path of the control socket, next to the pid file.
+func socketPath() string {
+	return C.Config.Get().ControlSocket()
+}
+
+// listenControl opens the control socket, serving it in the background.
+func listenControl() (net.Listener, error) {
+	path := socketPath()
+	// left behind by a daemon that didn't exit cleanly; the lock file keeps us from removing the
+	// socket of a running one
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to listen on '%s': %v", path, err))
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, errors.New(fmt.Sprintf("failed to restrict access to '%s': %v", path, err))
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				// closed on shutdown
+				return
+			}
+			go serveControl(conn)
+		}
+	}()
+	return l, nil
+}
+
+// serveControl answers the request on conn.
+func serveControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	var req controlRequest
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("malformed request: %v", err)
+	} else if req.Command == "state" {
+		if req.State == nil {
+			resp.Error = "no operation on the sync state"
+		} else {
+			r := S.Serve(*req.State)
+			resp.State, resp.OK, resp.Error = &r, r.Error == "", r.Error
+		}
+	} else if c, ok := controls[req.Command]; !ok {
+		resp.Error = fmt.Sprintf("unknown command: %s", req.Command)
+	} else if err := c.handler(req); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.OK = true
+	}
+	resp.Paused = R.UploadsPaused()
+	resp.Jobs = jobStatuses()
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("W: Failed to answer on control socket: %v", err)
+	}
+}
+
+// jobStatuses returns the status of the jobs queued and running, sorted by ID.
+func jobStatuses() []jobStatus {
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+	ret := make([]jobStatus, 0, len(inFlight))
+	for _, j := range inFlight {
+		s := jobStatus{ID: j.id, Path: j.path, State: "queued"}
+		if j.waiting {
+			s.State = "waiting"
+		}
+		if j.progress != nil {
+			s.State = "running"
+			s.Sent, s.Uploads = j.progress.Sent()
+		}
+		if j.cancelled {
+			s.State = "cancelling"
+		}
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// sendControl sends the command to the daemon running, printing its answer. It returns false if
+// the daemon failed to carry out the command.
+func sendControl(req controlRequest) (bool, error) {
+	conn, err := net.DialTimeout("unix", socketPath(), controlTimeout)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("failed to connect to the daemon: %v", err))
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return false, errors.New(fmt.Sprintf("failed to send command: %v", err))
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return false, errors.New(fmt.Sprintf("failed to read answer: %v", err))
+	}
+	if !resp.OK {
+		fmt.Printf("Error: %s\n", resp.Error)
+	}
+	if resp.Paused {
+		fmt.Println("Uploads are paused.")
+	}
+	if len(resp.Jobs) == 0 {
+		fmt.Println("No jobs.")
+	}
+	for _, v := range resp.Jobs {
+		fmt.Printf("#%s  %-10s  %12d  %s\n", v.ID, v.State, v.Sent, v.Path)
+		for _, t := range v.Uploads {
+			fmt.Printf("        %12d/%d  %s\n", t.Sent, t.Size, t.Path)
+		}
+	}
+	return resp.OK, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+// sendRaw sends req as is to serveControl over a pipe, returning the answer.
+func sendRaw(t *testing.T, req string) controlResponse {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveControl(server)
+	go client.Write([]byte(req))
+	var resp controlResponse
+	if err := json.NewDecoder(client).Decode(&resp); err != nil {
+		t.Fatalf("failed to read answer to %s: %v", req, err)
+	}
+	return resp
+}
+
+func TestServeControl(t *testing.T) {
+	p := R.Track("/src/running")
+	defer p.Stop()
+	inFlightLock.Lock()
+	inFlight = map[string]*job{
+		"/src/queued":     {id: "00002", path: "/src/queued"},
+		"/src/waiting":    {id: "00001", path: "/src/waiting", waiting: true},
+		"/src/running":    {id: "00003", path: "/src/running", progress: p},
+		"/src/cancelling": {id: "00004", path: "/src/cancelling", waiting: true, cancelled: true},
+	}
+	inFlightLock.Unlock()
+	defer func() {
+		inFlightLock.Lock()
+		inFlight = make(map[string]*job)
+		inFlightLock.Unlock()
+		R.ResumeUploads()
+	}()
+
+	for _, c := range []struct {
+		name   string
+		req    string
+		ok     bool
+		err    string
+		paused bool
+		// states of the jobs left, in order
+		states []string
+	}{
+		{"status", `{"command":"status"}`, true, "", false, []string{"waiting", "queued", "running", "cancelling"}},
+		{"malformed", "not json\n", false, "malformed request", false, []string{"waiting", "queued", "running", "cancelling"}},
+		{"unknown command", `{"command":"frob"}`, false, "unknown command: frob", false, []string{"waiting", "queued", "running", "cancelling"}},
+		{"cancel unknown job", `{"command":"cancel","job":"00099"}`, false, "no job #00099", false, []string{"waiting", "queued", "running", "cancelling"}},
+		{"cancel waiting job", `{"command":"cancel","job":"00001"}`, true, "", false, []string{"queued", "running", "cancelling"}},
+		{"cancel running job", `{"command":"cancel","job":"00003"}`, true, "", false, []string{"queued", "cancelling", "cancelling"}},
+		{"pause", `{"command":"pause"}`, true, "", true, []string{"queued", "cancelling", "cancelling"}},
+		{"resume", `{"command":"resume"}`, true, "", false, []string{"queued", "cancelling", "cancelling"}},
+		{"state without operation", `{"command":"state"}`, false, "no operation on the sync state", false, []string{"queued", "cancelling", "cancelling"}},
+	} {
+		resp := sendRaw(t, c.req)
+		if resp.OK != c.ok || !strings.HasPrefix(resp.Error, c.err) || (c.err == "" && resp.Error != "") {
+			t.Errorf("%s: answered %v, %q; want %v, %q", c.name, resp.OK, resp.Error, c.ok, c.err)
+		}
+		if resp.Paused != c.paused {
+			t.Errorf("%s: paused %v, want %v", c.name, resp.Paused, c.paused)
+		}
+		var states []string
+		for i, v := range resp.Jobs {
+			if i > 0 && resp.Jobs[i-1].ID >= v.ID {
+				t.Errorf("%s: jobs not sorted by ID: %v", c.name, resp.Jobs)
+			}
+			states = append(states, v.State)
+		}
+		if !reflect.DeepEqual(states, c.states) {
+			t.Errorf("%s: jobs in states %v, want %v", c.name, states, c.states)
+		}
+	}
+}
+
+func TestServeControlState(t *testing.T) {
+	req, err := json.Marshal(controlRequest{
+		Command: "state",
+		State:   &S.Request{Op: "get", Bucket: "nonexistent", Key: "/src/a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := sendRaw(t, string(req))
+	if resp.OK || resp.State == nil || resp.State.Error == "" || resp.Error != resp.State.Error {
+		t.Errorf("operation on an unknown bucket answered %+v", resp)
+	}
+}
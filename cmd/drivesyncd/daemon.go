@@ -2,22 +2,21 @@ package main
 
 import (
 	"log"
+	"net"
 	"os"
 	"runtime"
 
-	"github.com/radovskyb/watcher"
 	"github.com/sevlyar/go-daemon"
-	"google.golang.org/api/drive/v3"
 
 	A "github.com/KireinaHoro/DriveSync/auth"
 	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
 )
 
 var (
-	w    *watcher.Watcher
-	lock *daemon.LockFile
-	srv  *drive.Service
-	done chan struct{}
+	control net.Listener
+	b       R.Backend
 )
 
 func main() {
@@ -31,8 +30,6 @@ func main() {
 
 	conf := C.Config.Get()
 
-	srv = A.Authenticate()
-
 	registerSignals()
 
 	// initialize context for forking into background
@@ -48,13 +45,34 @@ func main() {
 
 	processCommand(ctx)
 
-	// we're launched as a daemon
-	lock, err = daemon.OpenLockFile(conf.Target+"/.drivesync-lock", 0644)
-	defer lock.Remove()
+	err = S.Open(conf.StateFile)
 	if err != nil {
-		log.Fatalf("E: Failed to open lock file: %v", err)
-	} else if err = lock.Lock(); err != nil {
-		log.Fatalf("E: Failed to lock (maybe another daemon is running?): %v", err)
+		log.Fatalf("E: Failed to open sync state: %v", err)
+	}
+	if !daemon.WasReborn() {
+		// checked; the daemon forked holds it open from here on
+		S.Close()
+	} else {
+		defer S.Close()
+	}
+
+	if conf.Backend == "local" {
+		b, err = R.NewLocalBackend(conf.LocalRoot)
+		if err != nil {
+			log.Fatalf("E: Failed to set up local backend: %v", err)
+		}
+	} else {
+		b = R.NewDriveBackend(A.Authenticate())
+	}
+
+	// we're launched as a daemon
+	defer unlockTargets()
+	for _, v := range conf.Targets {
+		l, err := lockTarget(v.Path)
+		if err != nil {
+			log.Fatalf("E: %v", err)
+		}
+		watches[v.Path] = &watch{target: v.Path, lock: l}
 	}
 
 	d, err := ctx.Reborn()
@@ -73,9 +91,20 @@ func main() {
 	}
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	control, err = listenControl()
+	if err != nil {
+		log.Fatalf("E: Failed to open control socket: %v", err)
+	}
+	defer os.Remove(socketPath())
+
+	if conf.MetricsAddress != "" {
+		if err := serveMetrics(conf.MetricsAddress); err != nil {
+			log.Fatalf("E: Failed to serve metrics: %v", err)
+		}
+	}
+
 	// run indefinitely before receiving signal to quit
-	done = make(chan struct{})
-	go worker()
+	go worker(startWatches())
 
 	err = daemon.ServeSignals()
 	if err != nil {
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "drivesyncd-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := S.Open(filepath.Join(dir, "state.db")); err != nil {
+		panic(err)
+	}
+	archive := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archive, 0755); err != nil {
+		panic(err)
+	}
+	// objects queued by the tests get synced into it
+	if b, err = R.NewLocalBackend(archive); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	S.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// tempDir returns a new temporary directory, removed at the end of the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "drivesyncd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// setTargets sets the configuration to watch targets, every scan interval.
+func setTargets(targets []string, interval string, incremental bool) {
+	conf := C.NewConfig()
+	conf.ArchiveRootName = "archive"
+	conf.CreateMissing = true
+	conf.ScanInterval = interval
+	conf.Incremental = incremental
+	conf.MaxUploads = 1
+	for _, v := range targets {
+		conf.Targets = append(conf.Targets, C.TargetConfig{Path: v})
+	}
+	C.Config.Set(conf)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	M "github.com/KireinaHoro/DriveSync/metrics"
+)
+
+func init() {
+	M.NewGaugeFunc("drivesync_queued_objects", "Objects in targets waiting to be synced.", func() float64 {
+		n, _, _ := countJobs()
+		return float64(n)
+	})
+	M.NewGaugeFunc("drivesync_settling_objects", "Objects in targets waiting to settle before being synced.",
+		func() float64 {
+			_, n, _ := countJobs()
+			return float64(n)
+		})
+	M.NewGaugeFunc("drivesync_active_objects", "Objects in targets being synced.", func() float64 {
+		_, _, n := countJobs()
+		return float64(n)
+	})
+}
+
+// countJobs returns the number of jobs queued, waiting to settle and running.
+func countJobs() (queued, waiting, running int) {
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+	for _, j := range inFlight {
+		if j.progress != nil {
+			running++
+		} else if j.waiting {
+			waiting++
+		} else {
+			queued++
+		}
+	}
+	return
+}
+
+// serveMetrics serves the metrics at /metrics on addr in the background.
+func serveMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to listen on %s: %v", addr, err))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", M.Handler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("W: Stopped serving metrics: %v", err)
+		}
+	}()
+	log.Printf("I: Serving metrics at http://%s/metrics.", l.Addr())
+	return nil
+}
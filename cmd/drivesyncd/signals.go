@@ -48,6 +48,10 @@ func parseFlags() {
 	for k, v := range signals {
 		usage += "\n\t\t" + k + "\t- " + v.description
 	}
+	usage += "\nor command through the control socket"
+	for k, v := range controls {
+		usage += "\n\t\t" + k + "\t- " + v.description
+	}
 	signal = flag.String("s", "", usage)
 	flag.Parse()
 }
@@ -59,11 +63,20 @@ func registerSignals() {
 	}
 }
 
-// processCommand looks up if the signal given on commandline matches a known one.
+// processCommand looks up if the signal given on commandline matches a known one, or a command
+// of the control socket, which takes the job ID as argument for cancel.
 // If commandline arguments provided, the daemon will be started;
 // otherwise the commandline option will be processed.
 func processCommand(ctx *daemon.Context) {
-	if len(daemon.ActiveFlags()) > 0 {
+	if _, ok := controls[*signal]; ok {
+		ok, err := sendControl(controlRequest{Command: *signal, Job: flag.Arg(0)})
+		if err != nil {
+			log.Fatalf("E: Unable to command the daemon: %v", err)
+		} else if !ok {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	} else if len(daemon.ActiveFlags()) > 0 {
 		d, err := ctx.Search()
 		if err != nil {
 			log.Fatalf("E: Unable find the daemon: %v", err)
@@ -90,24 +103,48 @@ func termHandler(sig os.Signal) error {
 		logMessage += " Exiting now..."
 	}
 	log.Print(logMessage)
-	err := lock.Remove()
-	if err != nil {
-		return errors.New(fmt.Sprintf("failed to remove lock file: %v", err))
+	if err := unlockTargets(); err != nil {
+		return err
+	}
+	control.Close()
+	watchLock.Lock()
+	var closed []chan struct{}
+	for _, v := range watches {
+		v.w.Close()
+		closed = append(closed, v.done)
 	}
-	w.Close()
+	watchLock.Unlock()
 	// wait for things to be completed
 	if sig == syscall.SIGQUIT {
-		<-done
+		for _, v := range closed {
+			<-v
+		}
 	}
 	return daemon.ErrStop
 }
 
-// reloadHandler handles configuration file reload event.
+// unlockTargets removes the lock files of the targets watched.
+func unlockTargets() error {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	for _, v := range watches {
+		if err := v.lock.Remove(); err != nil {
+			return errors.New(fmt.Sprintf("failed to remove lock file of %q: %v", v.target, err))
+		}
+	}
+	return nil
+}
+
+// reloadHandler handles configuration file reload event, switching the watches over to the
+// targets configured; the options of targets apply to the objects synced from then on.
 func reloadHandler(_ os.Signal) error {
+	old := C.Config.Get()
 	err := C.ReloadConfig()
 	if err != nil {
 		log.Printf("W: Failed to reload configuration: %v; check your configuration file.", err)
+		return nil
 	}
-	// C.Config.Get().Target won't get changed; restart is required if change for this is needed.
+	conf := C.Config.Get()
+	rewatch(conf.ScanInterval != old.ScanInterval || conf.Incremental != old.Incremental)
 	return nil
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+)
+
+// minRecheck bounds how often objects waiting for incomplete files or open writers are checked.
+const minRecheck = 5 * time.Second
+
+// errIncomplete stops the walk of takeSnapshot.
+var errIncomplete = errors.New("incomplete file found")
+
+// fileState is the size and modification time of a file or directory.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// treeSnapshot is the state of everything in an object.
+// key: path
+type treeSnapshot map[string]fileState
+
+func (r treeSnapshot) equal(other treeSnapshot) bool {
+	if len(r) != len(other) {
+		return false
+	}
+	for k, v := range r {
+		if o, ok := other[k]; !ok || o.size != v.size || !o.modTime.Equal(v.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// takeSnapshot walks the object at path, returning the state of the files and directories in
+// it, the latest modification time among them, and the first path named like one of patterns,
+// if any.
+func takeSnapshot(path string, patterns []string) (treeSnapshot, time.Time, string, error) {
+	conf := C.Config.Get()
+	snap := make(treeSnapshot)
+	var newest time.Time
+	var incomplete string
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, v := range patterns {
+			if ok, _ := filepath.Match(v, info.Name()); ok {
+				incomplete = p
+				return errIncomplete
+			}
+		}
+		if conf.Ignored(p) {
+			// e.g. .DS_Store, which may change at any time
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		snap[p] = fileState{info.Size(), info.ModTime()}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if err == errIncomplete {
+		err = nil
+	}
+	return snap, newest, incomplete, err
+}
+
+// checkStable returns why the object of j isn't ready to be synced, and how long to wait before
+// checking again, or an empty string if it is ready: nothing in it is named like an incomplete
+// download (see C.Config.IncompletePatterns) or open for writing, if C.Config.CheckOpenFiles is
+// set, and nothing in it has been modified within C.Config.StableTime, with the sizes and
+// modification times as they were when j was last checked, if ever.
+func checkStable(j *job) (string, time.Duration) {
+	conf := C.Config.Get()
+	stable, _ := time.ParseDuration(conf.StableTime)
+	if stable <= 0 && len(conf.IncompletePatterns) == 0 && !conf.CheckOpenFiles {
+		return "", 0
+	}
+	if conf.Ignored(j.path) {
+		// nothing to wait for
+		return "", 0
+	}
+	snap, newest, incomplete, err := takeSnapshot(j.path, conf.IncompletePatterns)
+	if err != nil {
+		// gone or unreadable; the sync will report it
+		return "", 0
+	}
+	recheck := stable
+	if recheck < minRecheck {
+		recheck = minRecheck
+	}
+	last := j.snapshot
+	j.snapshot = snap
+	if incomplete != "" {
+		return fmt.Sprintf("%q is incomplete", incomplete), recheck
+	}
+	if conf.CheckOpenFiles {
+		if w := openWriter(j.path, os.Getpid()); w != "" {
+			return fmt.Sprintf("%q is open for writing", w), recheck
+		}
+	}
+	if age := time.Since(newest); age < stable {
+		return "modified recently", stable - age
+	}
+	if last != nil && !snap.equal(last) {
+		// e.g. files extracted with their original modification times
+		return "changed since last checked", stable
+	}
+	return "", 0
+}
+
+// openWriter returns the path of a file at or under root that a process other than the one of
+// PID skip, i.e. ours, holds open for writing, as found in /proc, or an empty string if there's
+// none. The processes whose file descriptors can't be read, e.g. those of other users unless
+// running as root, are left out.
+func openWriter(root string, skip int) string {
+	// the links in /proc are absolute, with symlinks resolved
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	procs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return ""
+	}
+	self := strconv.Itoa(skip)
+	for _, proc := range procs {
+		if filepath.Base(proc) == self {
+			continue
+		}
+		f, err := os.Open(proc + "/fd")
+		if err != nil {
+			continue
+		}
+		fds, _ := f.Readdirnames(-1)
+		f.Close()
+		for _, fd := range fds {
+			path, err := os.Readlink(proc + "/fd/" + fd)
+			if err != nil || (path != root && !strings.HasPrefix(path, root+"/")) {
+				continue
+			}
+			if fdWritable(proc + "/fdinfo/" + fd) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// fdWritable returns whether the file descriptor described by the fdinfo file at path is open
+// for writing.
+func fdWritable(path string) bool {
+	info, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(info), "\n") {
+		if !strings.HasPrefix(line, "flags:") {
+			continue
+		}
+		flags, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "flags:")), 8, 64)
+		return err == nil && flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	}
+	return false
+}
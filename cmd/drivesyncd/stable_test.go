@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+)
+
+// writeFiles writes files, keyed by their paths relative to dir, last modified at modTime.
+func writeFiles(t *testing.T, dir string, files map[string]string, modTime time.Time) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckStable(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	for _, c := range []struct {
+		name     string
+		stable   string
+		patterns []string
+		files    map[string]string
+		recent   bool
+		// changed are written, as old as before, between a first and a second check
+		changed map[string]string
+		want    string
+		maxWait time.Duration
+	}{
+		{"settled", "1h", C.DefaultIncompletePatterns, map[string]string{"a.flac": "a"}, false, nil, "", 0},
+		{"nothing to wait for", "0s", nil, map[string]string{"a.part": "a"}, true, nil, "", 0},
+		{"incomplete", "1h", C.DefaultIncompletePatterns, map[string]string{"CD1/a.flac": "a", "CD2/b.flac.part": "b"}, false, nil, "b.flac.part\" is incomplete", time.Hour},
+		{"incomplete marker", "0s", C.DefaultIncompletePatterns, map[string]string{".incomplete": ""}, false, nil, "is incomplete", minRecheck},
+		{"other patterns", "1h", []string{"*.tmp"}, map[string]string{"a.part": "a"}, false, nil, "", 0},
+		{"modified recently", "1h", nil, map[string]string{"a.flac": "a"}, true, nil, "modified recently", time.Hour},
+		{"unchanged", "1h", nil, map[string]string{"a.flac": "a"}, false, map[string]string{"a.flac": "a"}, "", 0},
+		{"resized", "1h", nil, map[string]string{"a.flac": "a"}, false, map[string]string{"a.flac": "aa"}, "changed since last checked", time.Hour},
+		{"added", "1h", nil, map[string]string{"a.flac": "a"}, false, map[string]string{"b.flac": "b"}, "changed since last checked", time.Hour},
+		{"ignored changed", "1h", nil, map[string]string{"a.flac": "a"}, false, map[string]string{".DS_Store": "x"}, "", 0},
+	} {
+		conf := C.NewConfig()
+		conf.StableTime = c.stable
+		conf.IncompletePatterns = c.patterns
+		C.Config.Set(conf)
+		dir := tempDir(t)
+		modTime := old
+		if c.recent {
+			modTime = time.Now()
+		}
+		writeFiles(t, dir, c.files, modTime)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		j := &job{path: dir}
+		why, wait := checkStable(j)
+		if c.changed != nil {
+			if why != "" {
+				t.Errorf("%s: first check: %q", c.name, why)
+			}
+			writeFiles(t, dir, c.changed, old)
+			os.Chtimes(dir, old, old)
+			why, wait = checkStable(j)
+		}
+		if (c.want == "") != (why == "") || !strings.Contains(why, c.want) {
+			t.Errorf("%s: checkStable = %q, want %q", c.name, why, c.want)
+		}
+		if wait < 0 || wait > c.maxWait || (why != "" && wait == 0) {
+			t.Errorf("%s: wait %v, want up to %v", c.name, wait, c.maxWait)
+		}
+	}
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	C.Config.Set(C.NewConfig())
+	dir := tempDir(t)
+	old, older := time.Now().Add(-time.Hour), time.Now().Add(-2*time.Hour)
+	writeFiles(t, dir, map[string]string{"CD1/a.flac": "aaa"}, older)
+	writeFiles(t, dir, map[string]string{"CD1/b.flac": "b"}, old)
+	writeFiles(t, dir, map[string]string{".DS_Store": "x"}, time.Now())
+	for _, v := range []string{dir, filepath.Join(dir, "CD1")} {
+		os.Chtimes(v, older, older)
+	}
+	snap, newest, incomplete, err := takeSnapshot(dir, []string{"*.part"})
+	if err != nil {
+		t.Fatalf("takeSnapshot: %v", err)
+	}
+	if !newest.Equal(old) || incomplete != "" {
+		t.Errorf("newest %v, incomplete %q; want %v and none", newest, incomplete, old)
+	}
+	if len(snap) != 4 || snap[filepath.Join(dir, "CD1", "a.flac")].size != 3 {
+		t.Errorf("snapshot %v, want the directories and the files not ignored", snap)
+	}
+	if _, ok := snap[filepath.Join(dir, ".DS_Store")]; ok {
+		t.Error("ignored file in snapshot")
+	}
+	if !snap.equal(snap) || snap.equal(treeSnapshot{}) {
+		t.Error("snapshots compared wrong")
+	}
+	writeFiles(t, dir, map[string]string{"CD1/c.flac.part": "c"}, old)
+	if _, _, incomplete, err := takeSnapshot(dir, []string{"*.part"}); err != nil || incomplete != filepath.Join(dir, "CD1", "c.flac.part") {
+		t.Errorf("takeSnapshot found incomplete %q, %v", incomplete, err)
+	}
+}
+
+func TestFdWritable(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		info string
+		want bool
+	}{
+		{"read only", "pos:\t0\nflags:\t0100000\nmnt_id:\t25\n", false},
+		{"write only", "pos:\t0\nflags:\t02100001\nmnt_id:\t25\n", true},
+		{"read write", "pos:\t0\nflags:\t0100002\nmnt_id:\t25\n", true},
+		{"append", "pos:\t0\nflags:\t0102001\nmnt_id:\t25\n", true},
+		{"no flags", "pos:\t0\nmnt_id:\t25\n", false},
+		{"malformed flags", "pos:\t0\nflags:\t0x2\n", false},
+		{"flags in other field", "pos:\t0\nmnt_flags:\t02\nflags:\t00\n", false},
+	} {
+		path := filepath.Join(tempDir(t), "fdinfo")
+		if err := ioutil.WriteFile(path, []byte(c.info), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := fdWritable(path); got != c.want {
+			t.Errorf("%s: fdWritable = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if fdWritable(filepath.Join(tempDir(t), "missing")) {
+		t.Error("missing fdinfo taken as writable")
+	}
+}
+
+func TestOpenWriter(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fdinfo"); err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+	for _, c := range []struct {
+		name string
+		flag int
+		skip int
+		want bool
+	}{
+		{"writing", os.O_WRONLY, -1, true},
+		{"reading and writing", os.O_RDWR, -1, true},
+		{"reading", os.O_RDONLY, -1, false},
+		{"writing ourselves", os.O_WRONLY, os.Getpid(), false},
+	} {
+		dir := tempDir(t)
+		writeFiles(t, dir, map[string]string{"real/Rel/a.flac": "a"}, time.Now())
+		real, err := filepath.EvalSymlinks(filepath.Join(dir, "real"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(dir, "link")
+		if err := os.Symlink(real, link); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(filepath.Join(link, "Rel", "a.flac"), c.flag, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if c.want {
+			want = filepath.Join(real, "Rel", "a.flac")
+		}
+		if got := openWriter(filepath.Join(link, "Rel"), c.skip); got != want {
+			t.Errorf("%s: openWriter = %q, want %q", c.name, got, want)
+		}
+		// the root itself isn't a prefix of its siblings
+		if got := openWriter(filepath.Join(link, "Re"), c.skip); got != "" {
+			t.Errorf("%s: openWriter of a sibling = %q", c.name, got)
+		}
+		f.Close()
+	}
+}
@@ -1,92 +1,426 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/radovskyb/watcher"
+	"github.com/sevlyar/go-daemon"
+	"golang.org/x/net/context"
 
 	C "github.com/KireinaHoro/DriveSync/config"
 	E "github.com/KireinaHoro/DriveSync/errors"
 	R "github.com/KireinaHoro/DriveSync/remote"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
 )
 
-func worker() {
+// A watch is a target being watched, and locked so that no other daemon watches it.
+type watch struct {
+	target string
+	lock   *daemon.LockFile
+	w      *watcher.Watcher
+	// done is closed once w is closed
+	done chan struct{}
+}
+
+// watchLock guards watches, which change when a reload changes the targets or how they are
+// watched; see rewatch.
+var (
+	watchLock sync.Mutex
+	// watches: key: target; these are the targets in C.Config.Targets, unless watching some of
+	// them failed
+	watches = make(map[string]*watch)
+)
+
+// startWatches starts watching the targets locked on start, before signals are served, so that
+// the watches are complete by the time a reload or termination handles them. It returns the
+// targets started.
+func startWatches() []string {
 	conf := C.Config.Get()
-	// initialize watcher
-	w = watcher.New()
-	w.IgnoreHiddenFiles(true)
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	var ret []string
+	for _, v := range conf.Targets {
+		wt, ok := watches[v.Path]
+		if !ok {
+			continue
+		}
+		if err := checkTarget(v.Path); err != nil {
+			log.Fatalf("E: %v", err)
+		}
+		var err error
+		if wt.w, wt.done, err = startWatcher(v.Path, conf.ScanInterval, conf.Incremental); err != nil {
+			log.Fatalf("E: %v", err)
+		}
+		ret = append(ret, v.Path)
+	}
+	return ret
+}
+
+// worker syncs the targets started by startWatches, unless a reload let go of them meanwhile.
+func worker(targets []string) {
+	log.Print("I: Daemon started.")
+	for _, v := range targets {
+		watchLock.Lock()
+		_, ok := watches[v]
+		watchLock.Unlock()
+		if ok {
+			syncTarget(v)
+		}
+	}
+}
+
+// checkTarget checks that target is a directory.
+func checkTarget(target string) error {
+	if fi, err := os.Stat(target); err != nil {
+		return errors.New(fmt.Sprintf("failed to stat target: %v", err))
+	} else if !fi.IsDir() {
+		return errors.New(fmt.Sprintf("target %q is not a directory", target))
+	}
+	return nil
+}
+
+// lockTarget locks target, by way of the lock file in it.
+func lockTarget(target string) (*daemon.LockFile, error) {
+	l, err := daemon.OpenLockFile(filepath.Join(target, ".drivesync-lock"), 0644)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to open lock file of %q: %v", target, err))
+	} else if err := l.Lock(); err != nil {
+		l.Close()
+		return nil, errors.New(fmt.Sprintf("failed to lock %q (maybe another daemon is running?): %v",
+			target, err))
+	}
+	return l, nil
+}
+
+// syncTarget queues the objects in target for syncing, after taking over the marks left in it.
+func syncTarget(target string) {
+	// take over the marks left by older versions
+	if n, err := S.ImportMarks(target, false); err != nil {
+		log.Printf("W: Failed to import sync marks: %v", err)
+	} else if n > 0 {
+		log.Printf("I: Imported %d sync mark(s) into the sync state.", n)
+	}
+
+	// sync the target first
+	log.Printf("I: Syncing files/folders in %q...", target)
+	if err := scan(target); err != nil {
+		log.Printf("E: %v", err)
+		return
+	}
+	log.Printf("I: Initial scan of %q completed.", target)
+}
+
+// startWatcher starts watching target, every interval, returning the watcher once it's running
+// and the channel closed once it's closed.
+func startWatcher(target, interval string, incremental bool) (*watcher.Watcher, chan struct{}, error) {
+	nw := watcher.New()
+	nw.IgnoreHiddenFiles(true)
+	var err error
+	if incremental {
+		// files may get added to or changed in objects synced already
+		err = nw.AddRecursive(target)
+		nw.FilterOps(watcher.Create, watcher.Write)
+	} else {
+		err = nw.Add(target)
+		// we only care about new file events
+		nw.FilterOps(watcher.Create)
+	}
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("failed to watch target %q: %v", target, err))
+	}
+	closed := make(chan struct{})
 
 	go func() {
-		defer close(done)
+		defer close(closed)
 
 		for {
 			select {
-			case event := <-w.Event:
-				go func() {
-					err := R.SyncWithGuess(nil, srv, event.Path, C.NoGuessing)
-					if err != nil {
-						if _, ok := err.(E.ErrorAlreadySynced); ok {
-							log.Printf("I: Already synced: %q", event.Path)
-						} else {
-							log.Printf("W: Failed to sync %q: %v", event.Path, err)
-						}
+			case event := <-nw.Event:
+				path := event.Path
+				if incremental {
+					// changes deep in the tree concern the object in target containing them
+					if path = topLevel(target, path); path == "" {
+						continue
 					}
-				}()
-			case err := <-w.Error:
+				}
+				queueObject(path)
+			case err := <-nw.Error:
 				if err == watcher.ErrWatchedFileDeleted {
 					fmt.Println(err)
 					continue
 				}
 				log.Fatalf("E: Error occurred while watching: %v", err)
-			case <-w.Closed:
+			case <-nw.Closed:
 				return
 			}
 		}
 	}()
 
-	w.Add(conf.Target)
-	// we only care about new file events
-	w.FilterOps(watcher.Create)
+	// start watching
+	log.Printf("I: Starting watch of target %q...", target)
+	d, _ := time.ParseDuration(interval)
+	go func() {
+		if err := nw.Start(d); err != nil {
+			log.Fatalf("E: Failed to start watcher: %s", err)
+		}
+	}()
+	// Close does nothing until the watcher is running
+	nw.Wait()
+	return nw, closed, nil
+}
 
-	log.Print("I: Daemon started.")
+// rewatch brings the watches in line with C.Config.Targets, ScanInterval and Incremental, after
+// a reload changed them. New targets get locked and watched, and are synced as on start; the
+// watchers of all targets are replaced if restart is set, as how they watch has changed, and the
+// targets are scanned again for the objects created meanwhile. Targets no longer configured are
+// let go of afterwards, leaving their objects that are queued or being synced to finish. Failures
+// are logged, leaving the watch concerned as it was.
+func rewatch(restart bool) {
+	conf := C.Config.Get()
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	wanted := make(map[string]bool)
+	for _, v := range conf.Targets {
+		wanted[v.Path] = true
+		if wt, ok := watches[v.Path]; ok {
+			if !restart {
+				continue
+			}
+			nw, closed, err := startWatcher(v.Path, conf.ScanInterval, conf.Incremental)
+			if err != nil {
+				log.Printf("W: Failed to restart watch of %q: %v; keeping the old one.", v.Path, err)
+				continue
+			}
+			wt.w.Close()
+			<-wt.done
+			wt.w, wt.done = nw, closed
+			// pick up the objects created between the last poll of the old watcher and the
+			// first one of the new
+			if err := scan(v.Path); err != nil {
+				log.Printf("W: Failed to scan %q after restarting its watch: %v", v.Path, err)
+			}
+			continue
+		}
+		wt, err := watchTarget(v.Path, conf.ScanInterval, conf.Incremental)
+		if err != nil {
+			log.Printf("W: Failed to watch %q: %v.", v.Path, err)
+			continue
+		}
+		watches[v.Path] = wt
+		go syncTarget(v.Path)
+	}
+	for target, wt := range watches {
+		if wanted[target] {
+			continue
+		}
+		wt.w.Close()
+		<-wt.done
+		if err := wt.lock.Remove(); err != nil {
+			log.Printf("W: Failed to remove lock file of %q: %v", target, err)
+		}
+		delete(watches, target)
+		log.Printf("I: Stopped watching target %q.", target)
+	}
+}
 
-	f, err := os.Open(conf.Target)
+// watchTarget locks and starts watching target.
+func watchTarget(target, interval string, incremental bool) (*watch, error) {
+	if err := checkTarget(target); err != nil {
+		return nil, err
+	}
+	l, err := lockTarget(target)
 	if err != nil {
-		log.Fatalf("E: Failed to open target: %v", err)
+		return nil, err
 	}
-	if fi, err := f.Stat(); err != nil {
-		log.Fatalf("E: Failed to stat target: %v", err)
-	} else if !fi.IsDir() {
-		log.Fatalf("E: Target %q is not a directory", fi.Name())
+	nw, closed, err := startWatcher(target, interval, incremental)
+	if err != nil {
+		l.Remove()
+		return nil, err
 	}
+	return &watch{target: target, lock: l, w: nw, done: closed}, nil
+}
 
-	// sync the target first
-	log.Println("I: Syncing files/folders...")
+// watchedTargets returns the targets being watched, in order.
+func watchedTargets() []string {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	ret := make([]string, 0, len(watches))
+	for k := range watches {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// scan queues the objects in target for syncing.
+func scan(target string) error {
+	f, err := os.Open(target)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to open target: %v", err))
+	}
+	defer f.Close()
 	children, err := f.Readdirnames(-1)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to read target: %v", err))
+	}
 	for _, v := range children {
-		itemPath := conf.Target + "/" + v
-		go func() {
-			log.Printf("I: Syncing %q...", itemPath)
-			err := R.SyncWithGuess(nil, srv, itemPath, C.NoGuessing)
-			if err != nil {
-				if _, ok := err.(E.ErrorAlreadySynced); ok {
-					log.Printf("I: Already synced: %q", itemPath)
-				} else {
-					log.Printf("W: Failed to sync %q: %v", itemPath, err)
-				}
+		queueObject(target + "/" + v)
+	}
+	return nil
+}
+
+// objectPool runs the syncs of objects in targets, C.Config.MaxUploads at a time; the uploads
+// in them are bounded by the upload pool of R in turn.
+var objectPool = U.NewPool(C.MaxUploads)
+
+// A job is an object queued or being synced.
+type job struct {
+	id   string
+	path string
+	// again is whether another sync has been requested meanwhile
+	again     bool
+	cancelled bool
+	// waiting is whether the object is waiting to settle before being synced; see checkStable
+	waiting bool
+	// snapshot is the state of the object when last checked by checkStable
+	snapshot treeSnapshot
+	// progress is set while the object is being synced
+	progress *R.Progress
+}
+
+// inFlight keeps track of the objects queued or being synced.
+// key: path
+var (
+	inFlight     = make(map[string]*job)
+	inFlightLock sync.Mutex
+)
+
+// queueObject queues the object at path for syncing. Requests to sync an object that is queued
+// or being synced already get coalesced into one more run after the current one finishes.
+func queueObject(path string) {
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+	if j, ok := inFlight[path]; ok {
+		if j.progress == nil {
+			// not started yet
+			j.cancelled = false
+		} else {
+			j.again = true
+		}
+		return
+	}
+	j := &job{path: path}
+	inFlight[path] = j
+	objectPool.Resize(C.Config.Get().MaxUploads)
+	j.id = objectPool.Submit(func(ctx context.Context) {
+		syncObject(ctx, j)
+	})
+}
+
+// cancelJob cancels the job with given ID, stopping the sync of the object if it's running; it
+// stays queued for syncing again if requested meanwhile.
+func cancelJob(id string) error {
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+	for _, j := range inFlight {
+		if j.id == id {
+			j.cancelled, j.again = true, false
+			if j.progress != nil {
+				j.progress.Cancel()
+			} else if j.waiting {
+				// not to be requeued; see requeue
+				delete(inFlight, j.path)
 			}
-		}()
+			log.Printf("I: Cancelling job #%s (%q)...", id, j.path)
+			return nil
+		}
 	}
-	log.Println("I: Initial scan completed.")
+	return errors.New(fmt.Sprintf("no job #%s", id))
+}
 
-	// start watching
-	log.Printf("I: Starting watch of target %q...", conf.Target)
+// syncObject syncs the object of j, once more for every time it's been requested meanwhile. An
+// object that hasn't settled yet is requeued instead; see checkStable.
+func syncObject(ctx context.Context, j *job) {
+	l := U.GetLogger(ctx)
+	for {
+		why, wait := checkStable(j)
+		inFlightLock.Lock()
+		if j.cancelled {
+			if inFlight[j.path] == j {
+				// not removed by cancelJob already
+				delete(inFlight, j.path)
+			}
+			inFlightLock.Unlock()
+			l.Printf("I: Cancelled: %q", j.path)
+			return
+		}
+		if why != "" {
+			if !j.waiting {
+				l.Printf("I: Waiting for %q to settle: %s.", j.path, why)
+			}
+			// the requests meanwhile are served by the next check
+			j.waiting, j.again = true, false
+			inFlightLock.Unlock()
+			requeue(j, wait)
+			return
+		}
+		j.waiting = false
+		j.progress = R.Track(j.path)
+		inFlightLock.Unlock()
+		l.Printf("I: Syncing %q...", j.path)
+		err := R.SyncWithGuess(nil, b, j.path, C.GuesserFor(j.path))
+		if err != nil {
+			if _, ok := err.(E.ErrorAlreadySynced); ok {
+				l.Printf("I: Already synced: %q", j.path)
+			} else if _, ok := err.(E.ErrorCancelled); ok {
+				l.Printf("I: Cancelled: %q", j.path)
+			} else {
+				l.Printf("W: Failed to sync %q: %v", j.path, err)
+			}
+		}
+		inFlightLock.Lock()
+		j.progress.Stop()
+		j.progress = nil
+		if !j.again {
+			delete(inFlight, j.path)
+			inFlightLock.Unlock()
+			return
+		}
+		j.again, j.cancelled = false, false
+		inFlightLock.Unlock()
+	}
+}
+
+// requeue queues j again after wait, unless it's been cancelled meanwhile.
+func requeue(j *job, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		inFlightLock.Lock()
+		defer inFlightLock.Unlock()
+		if inFlight[j.path] != j {
+			// cancelled
+			return
+		}
+		j.id = objectPool.Submit(func(ctx context.Context) {
+			syncObject(ctx, j)
+		})
+	})
+}
 
-	interval, _ := time.ParseDuration(conf.ScanInterval)
-	if err := w.Start(interval); err != nil {
-		log.Fatalf("E: Failed to start watcher: %s", err)
+// topLevel returns the path of the object directly in target that contains path, or an empty
+// string if path is not inside target.
+func topLevel(target, path string) string {
+	rel, err := filepath.Rel(target, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
 	}
+	return filepath.Join(target, strings.SplitN(rel, string(filepath.Separator), 2)[0])
 }
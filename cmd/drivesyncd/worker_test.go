@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// checkWatches fails t unless exactly targets are watched, with their lock files in place.
+func checkWatches(t *testing.T, targets ...string) {
+	if got := watchedTargets(); len(got)+len(targets) > 0 && !reflect.DeepEqual(got, targets) {
+		t.Fatalf("watching %v, want %v", got, targets)
+	}
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	for _, v := range targets {
+		if wt := watches[v]; wt.w == nil || wt.done == nil || wt.lock == nil {
+			t.Errorf("watch of %s incomplete: %+v", v, wt)
+		}
+		if _, err := os.Stat(filepath.Join(v, ".drivesync-lock")); err != nil {
+			t.Errorf("%s not locked: %v", v, err)
+		}
+	}
+}
+
+func TestStartWatches(t *testing.T) {
+	a, missing := tempDir(t), filepath.Join(tempDir(t), "missing")
+	setTargets([]string{a, missing}, "50ms", false)
+	// as locked on start; missing failed to lock
+	l, err := lockTarget(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchLock.Lock()
+	watches[a] = &watch{target: a, lock: l}
+	watchLock.Unlock()
+	if got := startWatches(); !reflect.DeepEqual(got, []string{a}) {
+		t.Errorf("started %v, want %v", got, []string{a})
+	}
+	checkWatches(t, a)
+	setTargets(nil, "50ms", false)
+	rewatch(false)
+	checkWatches(t)
+}
+
+func TestRewatch(t *testing.T) {
+	a, b, c := tempDir(t), tempDir(t), tempDir(t)
+	missing := filepath.Join(c, "missing")
+	defer func() {
+		setTargets(nil, "50ms", false)
+		rewatch(false)
+	}()
+	setTargets([]string{a, b, missing}, "50ms", false)
+	rewatch(false)
+	checkWatches(t, sorted(a, b)...)
+
+	// a let go of, c added
+	watchLock.Lock()
+	kept := *watches[b]
+	watchLock.Unlock()
+	setTargets([]string{b, c}, "50ms", false)
+	rewatch(false)
+	checkWatches(t, sorted(b, c)...)
+	if _, err := os.Stat(filepath.Join(a, ".drivesync-lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file of %s left: %v", a, err)
+	}
+	watchLock.Lock()
+	if watches[b].w != kept.w {
+		t.Error("watcher replaced without restart")
+	}
+	watchLock.Unlock()
+
+	// how they are watched changed
+	setTargets([]string{b, c}, "60ms", true)
+	rewatch(true)
+	checkWatches(t, sorted(b, c)...)
+	watchLock.Lock()
+	if watches[b].w == kept.w || watches[b].lock != kept.lock {
+		t.Error("watcher not replaced on restart, or lock replaced")
+	}
+	watchLock.Unlock()
+	select {
+	case <-kept.done:
+	default:
+		t.Error("old watcher left running")
+	}
+}
+
+// sorted returns targets in order, as watchedTargets does.
+func sorted(targets ...string) []string {
+	ret := append([]string(nil), targets...)
+	sort.Strings(ret)
+	return ret
+}
@@ -1,10 +1,27 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
 // A Guesser accepts a basename and gives the most possibly suitable category name.
 type Guesser interface {
 	Guess(string) string
 }
 
+// A ChainableGuesser is a Guesser that can abstain from guessing, given the full path of an
+// object, so that the next Guesser in a chain gets to guess; see NewGuessChain.
+type ChainableGuesser interface {
+	Guesser
+	// TryGuess returns the category of the object at path, and false if it abstains.
+	TryGuess(path string) (string, bool)
+}
+
 // NoGuessing does not perform any form of guessing, simply returning the default
 type noGuessing struct{}
 
@@ -13,4 +30,189 @@ func (r noGuessing) Guess(_ string) string {
 	return conf.DefaultCategory
 }
 
+// TryGuess gives the default category of the target containing path, if any; see
+// Config.ForPath.
+func (r noGuessing) TryGuess(path string) (string, bool) {
+	return Config.Get().ForPath(path).DefaultCategory, true
+}
+
 var NoGuessing noGuessing
+
+// CategoryRule maps the basenames it matches to Category. A rule matches on exactly one of:
+//
+//   - Extensions, a list of extensions without the leading dot, e.g. ["flac", "ape"];
+//   - Glob, a shell pattern, with alternatives separated by "|", e.g. "*.iso|*.dmg";
+//   - Regex, a regular expression.
+//
+// Extensions and globs are matched case-insensitively.
+type CategoryRule struct {
+	Extensions []string `json:"extensions,omitempty"`
+	Glob       string   `json:"glob,omitempty"`
+	Regex      string   `json:"regex,omitempty"`
+	Category   string   `json:"category"`
+}
+
+// categoryRule is a CategoryRule ready for matching.
+type categoryRule struct {
+	match    func(basename string) bool
+	category string
+}
+
+// compileRules validates rules, turning them into categoryRules.
+func compileRules(rules []CategoryRule) ([]categoryRule, error) {
+	var ret []categoryRule
+	for i, v := range rules {
+		var set int
+		for _, ok := range []bool{len(v.Extensions) > 0, v.Glob != "", v.Regex != ""} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, errors.New(fmt.Sprintf(
+				"category rule %d: exactly one of extensions, glob and regex should be set", i))
+		}
+		if err := CheckCategory(v.Category); err != nil {
+			return nil, errors.New(fmt.Sprintf("category rule %d: %v", i, err))
+		}
+		rule := categoryRule{category: v.Category}
+		switch {
+		case len(v.Extensions) > 0:
+			exts := make(map[string]struct{})
+			for _, ext := range v.Extensions {
+				exts["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = struct{}{}
+			}
+			rule.match = func(basename string) bool {
+				_, ok := exts[strings.ToLower(filepath.Ext(basename))]
+				return ok
+			}
+		case v.Glob != "":
+			patterns := strings.Split(strings.ToLower(v.Glob), "|")
+			for _, p := range patterns {
+				if _, err := filepath.Match(p, ""); err != nil {
+					return nil, errors.New(fmt.Sprintf("category rule %d: bad glob %q: %v", i, p, err))
+				}
+			}
+			rule.match = func(basename string) bool {
+				basename = strings.ToLower(basename)
+				for _, p := range patterns {
+					if ok, _ := filepath.Match(p, basename); ok {
+						return true
+					}
+				}
+				return false
+			}
+		default:
+			re, err := regexp.Compile(v.Regex)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("category rule %d: bad regex: %v", i, err))
+			}
+			rule.match = re.MatchString
+		}
+		ret = append(ret, rule)
+	}
+	return ret, nil
+}
+
+// RulesGuesser guesses with Config.CategoryRules, giving the category of the first rule that
+// matches, or the default category if none does.
+type rulesGuesser struct{}
+
+func (r rulesGuesser) Guess(basename string) string {
+	if category, ok := r.TryGuess(basename); ok {
+		return category
+	}
+	return Config.Get().DefaultCategory
+}
+
+// TryGuess abstains if no rule matches the basename of path.
+func (r rulesGuesser) TryGuess(path string) (string, bool) {
+	basename := filepath.Base(path)
+	for _, v := range Config.Get().categoryRules {
+		if v.match(basename) {
+			return v.category, true
+		}
+	}
+	return "", false
+}
+
+var RulesGuesser rulesGuesser
+
+// guessChain asks its Guessers in order, until one doesn't abstain.
+type guessChain []ChainableGuesser
+
+// NewGuessChain returns a PathGuesser that asks guessers in order, with the first one not
+// abstaining giving the category. NoGuessing ends the chain.
+func NewGuessChain(guessers ...ChainableGuesser) guessChain {
+	return append(guessChain(guessers), NoGuessing)
+}
+
+func (r guessChain) Guess(basename string) string {
+	return r.GuessPath(basename)
+}
+
+// GuessPath takes categories that fail CheckCategory as abstentions, logging them.
+func (r guessChain) GuessPath(path string) string {
+	for _, v := range r {
+		category, ok := v.TryGuess(path)
+		if !ok {
+			continue
+		}
+		if err := CheckCategory(category); err != nil {
+			log.Printf("W: Guess for %q ignored: %v", path, err)
+			continue
+		}
+		return category
+	}
+	return Config.Get().ForPath(path).DefaultCategory
+}
+
+// CheckCategory returns an error if category can't name a category folder: it must be a single
+// path element, so that objects can't be synced outside of the archive root.
+func CheckCategory(category string) error {
+	if category == "" || category == "." || category == ".." || strings.Contains(category, "/") {
+		return errors.New(fmt.Sprintf("bad category %q", category))
+	}
+	return nil
+}
+
+// guessers are the ChainableGuessers that can be named in Config.Guesser.
+var guessers = map[string]ChainableGuesser{
+	"rules":    RulesGuesser,
+	"content":  ContentGuesser,
+	"learning": LearningGuesser,
+	"command":  CommandGuesser,
+	"torrent":  TorrentGuesser,
+}
+
+// checkGuesser validates Config.Guesser, a comma-separated list of names in guessers.
+func checkGuesser(names string) error {
+	for _, v := range strings.Split(names, ",") {
+		if _, ok := guessers[strings.TrimSpace(v)]; !ok {
+			return errors.New(fmt.Sprintf("unknown guesser %q", strings.TrimSpace(v)))
+		}
+	}
+	return nil
+}
+
+// SelectedGuesser returns the chain of Guessers named by Config.Guesser.
+func SelectedGuesser() Guesser {
+	return namedGuessChain(Config.Get().Guesser)
+}
+
+// GuesserFor returns the chain of Guessers for the object at path, as chosen by the target
+// containing it; see Config.ForPath.
+func GuesserFor(path string) Guesser {
+	return namedGuessChain(Config.Get().ForPath(path).Guesser)
+}
+
+// namedGuessChain returns the chain of the Guessers named in names, a comma-separated list.
+func namedGuessChain(names string) guessChain {
+	var chain []ChainableGuesser
+	for _, v := range strings.Split(names, ",") {
+		if g, ok := guessers[strings.TrimSpace(v)]; ok {
+			chain = append(chain, g)
+		}
+	}
+	return NewGuessChain(chain...)
+}
@@ -0,0 +1,57 @@
+package config
+
+import "testing"
+
+func TestCheckCategory(t *testing.T) {
+	for _, v := range []string{"Music", "TV Shows", ".hidden", "a..b"} {
+		if err := CheckCategory(v); err != nil {
+			t.Errorf("%q rejected: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", ".", "..", "../etc", "Music/Rock", "/"} {
+		if err := CheckCategory(v); err == nil {
+			t.Errorf("%q accepted", v)
+		}
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	for _, c := range []struct {
+		rule CategoryRule
+		ok   bool
+	}{
+		{CategoryRule{Extensions: []string{"flac"}, Category: "Music"}, true},
+		{CategoryRule{Glob: "*.iso|*.img", Category: "Images"}, true},
+		{CategoryRule{Regex: `^S\d+E\d+`, Category: "TV Shows"}, true},
+		{CategoryRule{Category: "Music"}, false},
+		{CategoryRule{Extensions: []string{"flac"}, Glob: "*.flac", Category: "Music"}, false},
+		{CategoryRule{Glob: "[", Category: "Music"}, false},
+		{CategoryRule{Regex: "(", Category: "Music"}, false},
+		{CategoryRule{Extensions: []string{"flac"}}, false},
+		{CategoryRule{Extensions: []string{"iso"}, Category: "../../etc"}, false},
+		{CategoryRule{Extensions: []string{"iso"}, Category: "Images/ISO"}, false},
+	} {
+		if _, err := compileRules([]CategoryRule{c.rule}); (err == nil) != c.ok {
+			t.Errorf("rule %+v: error %v", c.rule, err)
+		}
+	}
+}
+
+func TestGuessPathRejectsBadCategories(t *testing.T) {
+	// as given by a guesser other than the rules, which are checked when compiled
+	rules := []categoryRule{
+		{match: func(basename string) bool { return basename == "tool.iso" }, category: "../../etc"},
+		{match: func(basename string) bool { return basename == "a.flac" }, category: "Music"},
+	}
+	conf := NewConfig()
+	conf.DefaultCategory = "Uncategorized"
+	conf.categoryRules = rules
+	Config.Set(conf)
+	chain := NewGuessChain(RulesGuesser)
+	if got := chain.GuessPath("/data/tool.iso"); got != "Uncategorized" {
+		t.Errorf("tool.iso guessed into %q, want Uncategorized", got)
+	}
+	if got := chain.GuessPath("/data/a.flac"); got != "Music" {
+		t.Errorf("a.flac guessed into %q, want Music", got)
+	}
+}
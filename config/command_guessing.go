@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// abstain is what a guess command prints to leave the guess to the next Guesser.
+const abstain = "abstain"
+
+// guessWaitDelay bounds the wait for the output of a guess command to close once it has exited
+// or been killed.
+const guessWaitDelay = time.Second
+
+// GuessRequest is what CommandGuesser writes to the standard input of Config.GuessCommand,
+// as a JSON object.
+type GuessRequest struct {
+	Path            string    `json:"path"`
+	Basename        string    `json:"basename"`
+	IsDir           bool      `json:"is-dir"`
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"mtime"`
+	DefaultCategory string    `json:"default-category"`
+}
+
+// newGuessRequest describes the object at path. Size is the total size of the files in
+// directories.
+func newGuessRequest(path string) (GuessRequest, error) {
+	req := GuessRequest{
+		Path:            path,
+		Basename:        filepath.Base(path),
+		DefaultCategory: Config.Get().ForPath(path).DefaultCategory,
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return req, err
+	}
+	req.IsDir, req.ModTime = info.IsDir(), info.ModTime()
+	if !info.IsDir() {
+		req.Size = info.Size()
+		return req, nil
+	}
+	err = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			req.Size += info.Size()
+		}
+		return nil
+	})
+	return req, err
+}
+
+// checkGuessCommand validates Config.GuessCommand and Config.GuessCommandTimeout, the former
+// being required if "command" is in guesser.
+func checkGuessCommand(guesser string, command []string, timeout string) error {
+	for _, v := range strings.Split(guesser, ",") {
+		if strings.TrimSpace(v) == "command" && len(command) == 0 {
+			return errors.New(`guesser "command" used yet "guess-command" not set`)
+		}
+	}
+	if _, err := time.ParseDuration(timeout); err != nil {
+		return errors.New(fmt.Sprintf("failed to parse guess-command-timeout: %v", err))
+	}
+	return nil
+}
+
+// CommandGuesser runs Config.GuessCommand, passing a GuessRequest of the object on its standard
+// input. The command prints the category on the first line of its standard output, or "abstain"
+// (or nothing) to leave the guess to the next Guesser. The command is killed if it doesn't exit
+// within Config.GuessCommandTimeout, along with the processes it started where there are process
+// groups; it abstains then, as it does if the command fails.
+type commandGuesser struct{}
+
+func (r commandGuesser) Guess(basename string) string {
+	if category, ok := r.TryGuess(basename); ok {
+		return category
+	}
+	return Config.Get().DefaultCategory
+}
+
+func (r commandGuesser) TryGuess(path string) (string, bool) {
+	conf := Config.Get()
+	if len(conf.GuessCommand) == 0 {
+		return "", false
+	}
+	req, err := newGuessRequest(path)
+	if err != nil {
+		// the command might still make something out of the name
+		log.Printf("W: Failed to stat %q for guess command: %v", path, err)
+	}
+	in, err := json.Marshal(&req)
+	if err != nil {
+		log.Printf("W: Failed to marshal guess request: %v", err)
+		return "", false
+	}
+	timeout, err := time.ParseDuration(conf.GuessCommandTimeout)
+	if err != nil {
+		timeout, _ = time.ParseDuration(GuessTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, conf.GuessCommand[0], conf.GuessCommand[1:]...)
+	setProcessGroup(cmd)
+	// don't wait long for the output to close; processes left by the command may hold it open
+	cmd.WaitDelay = guessWaitDelay
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("W: Guess command timed out after %v for %q.", timeout, path)
+		return "", false
+	} else if err != nil {
+		log.Printf("W: Guess command failed for %q: %v", path, err)
+		return "", false
+	}
+	category, _ := bufio.NewReader(&out).ReadString('\n')
+	category = strings.TrimSpace(category)
+	if category == "" || category == abstain {
+		return "", false
+	}
+	if conf.Verbose {
+		log.Printf("I: Guess command gave %q for %q.", category, path)
+	}
+	return category, true
+}
+
+var CommandGuesser commandGuesser
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package config
+
+import "os/exec"
+
+// setProcessGroup does nothing where there are no process groups; only the command itself is
+// killed when its context is done.
+func setProcessGroup(cmd *exec.Cmd) {}
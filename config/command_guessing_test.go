@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setGuessCommand sets the guess command to the shell script, with given timeout.
+func setGuessCommand(t *testing.T, script, timeout string) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run guess commands")
+	}
+	conf := NewConfig()
+	conf.DefaultCategory = "Uncategorized"
+	conf.GuessCommand = []string{"sh", "-c", script}
+	conf.GuessCommandTimeout = timeout
+	Config.Set(conf)
+}
+
+func TestCommandGuesserRequest(t *testing.T) {
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{"Album/a.flac": "aaa", "Album/b.flac": "bb"})
+	sent := filepath.Join(dir, "request.json")
+	setGuessCommand(t, "cat > "+sent+"; echo Music", "10s")
+	path := filepath.Join(dir, "Album")
+	if got, ok := CommandGuesser.TryGuess(path); got != "Music" || !ok {
+		t.Errorf("guessed %q, %v; want Music", got, ok)
+	}
+	data, err := ioutil.ReadFile(sent)
+	if err != nil {
+		t.Fatalf("no request sent: %v", err)
+	}
+	var req GuessRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("malformed request %s: %v", data, err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Path != path || req.Basename != "Album" || !req.IsDir || req.Size != 5 ||
+		!req.ModTime.Equal(fi.ModTime()) || req.DefaultCategory != "Uncategorized" {
+		t.Errorf("sent %+v", req)
+	}
+}
+
+func TestCommandGuesserAbstains(t *testing.T) {
+	for _, c := range []struct {
+		script string
+		want   string
+	}{
+		{"echo Music", "Music"},
+		{"printf '  Video  \\nMusic\\n'", "Video"},
+		{"echo abstain", ""},
+		{"true", ""},
+		{"echo Music; exit 1", ""},
+	} {
+		setGuessCommand(t, c.script, "10s")
+		got, ok := CommandGuesser.TryGuess("/data/a.flac")
+		if got != c.want || ok != (c.want != "") {
+			t.Errorf("%s: guessed %q, %v; want %q", c.script, got, ok, c.want)
+		}
+		if c.want == "" {
+			if got := CommandGuesser.Guess("/data/a.flac"); got != "Uncategorized" {
+				t.Errorf("%s: abstaining guess %q, want the default category", c.script, got)
+			}
+		}
+	}
+}
+
+func TestCommandGuesserTimeout(t *testing.T) {
+	pidFile := filepath.Join(tempDir(t), "pid")
+	// the background process holds the output open
+	setGuessCommand(t, "sleep 30 & echo $! > "+pidFile+"; wait", "200ms")
+	start := time.Now()
+	if got, ok := CommandGuesser.TryGuess("/data/a.flac"); ok {
+		t.Errorf("guessed %q after timing out", got)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("timed out after %v", d)
+	}
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := filepath.Join("/proc", strconv.Itoa(pid), "stat")
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc to check the background process in")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		data, err := ioutil.ReadFile(stat)
+		// gone, or dead and waiting to be reaped
+		if err != nil || strings.Contains(string(data), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background process of the command left running")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -16,8 +16,14 @@ var (
 		".drivesync-lock": {},
 		".ehviewer":       {},
 	}
-	ArchiveRootID string
-	CategoryIDs   = U.NewSafeMap()
+	// ArchiveRootIDs caches the archive roots, keyed by name
+	ArchiveRootIDs = U.NewSafeMap()
+	// CategoryIDs caches the category folders, keyed by archive root and category, e.g.
+	// "archive/Music"
+	CategoryIDs = U.NewSafeMap()
+	// PathIDs caches the folders created by path templates, keyed by archive root, category and
+	// path
+	PathIDs = U.NewSafeMap()
 )
 
 // Constants that denote the default values for config values.
@@ -26,14 +32,29 @@ const (
 	RetryRatio        = 2
 	RetryStartingRate = 1
 	ArchiveRootName   = "archive"
+	Backend           = "drive"
 	Category          = "Uncategorized"
 	ForceRecheck      = true
 	Verbose           = true
 	CreateMissing     = false
+	Incremental       = false
+	GuesserName       = "rules"
+	GuesserModelName  = "guesser-model.json"
+	GuessConfidence   = 0.6
+	GuessTimeout      = "10s"
+	MaxUploads        = 4
+	MaxRetries        = 8
 	UseProxy          = false
 	ScanInterval      = "100ms"
+	StableTime        = "10s"
+	StateFileName     = "state.db"
+	UploadChunkSize   = 8 << 20
 )
 
+// DefaultIncompletePatterns are used if Config.IncompletePatterns is not set: the files being
+// downloaded by browsers, wget and qBittorrent, and the folders of other BitTorrent clients.
+var DefaultIncompletePatterns = []string{"*.part", "*.!qB", ".incomplete"}
+
 // Variables that only get used by `drivesync`
 var (
 	// Interactive only affects `drivesync`; `drivesyncd` is always non-interactive
@@ -79,18 +100,68 @@ func NewConfig() config {
 // type config denotes the configuration read by the daemon.
 type config struct {
 	ArchiveRootName   string `json:"archive-root"`
+	Backend           string `json:"backend"`
 	ClientSecretPath  string `json:"client-secret-path"`
 	CreateMissing     bool   `json:"create-missing"`
 	DefaultCategory   string `json:"default-category"`
 	ForceRecheck      bool   `json:"force-recheck"`
+	Incremental       bool   `json:"incremental"`
+	LocalRoot         string `json:"local-root"`
 	LogFile           string `json:"log-file"`
+	MaxUploads        int    `json:"max-concurrent-uploads"`
+	MaxRetries        int    `json:"max-retries"`
 	PidFile           string `json:"pid-file"`
 	ProxyURL          string `json:"proxy-url"`
 	RetryRatio        int    `json:"retry-ratio"`
 	RetryStartingRate int    `json:"retry-starting-rate"`
 	ScanInterval      string `json:"scan-interval"`
-	// Config.Target denotes the directory to be watched when calling `drivesyncd`
-	Target   string `json:"target"`
-	UseProxy bool   `json:"use-proxy"`
-	Verbose  bool   `json:"verbose"`
+	StateFile         string `json:"state-file"`
+	UploadChunkSize   int64  `json:"upload-chunk-size"`
+	// Config.UploadRateLimit is in bytes per second, shared by all uploads; 0 means no limit
+	UploadRateLimit int64        `json:"upload-rate-limit"`
+	UploadSchedule  []RateWindow `json:"upload-rate-schedule"`
+	// Config.Guesser is a comma-separated chain of the Guessers used by `drivesyncd`, out of
+	// "rules", "content", "learning", "command" and "torrent"
+	Guesser             string         `json:"guesser"`
+	CategoryRules       []CategoryRule `json:"category-rules"`
+	ContentRules        []ContentRule  `json:"content-rules"`
+	GuesserModel        string         `json:"guesser-model"`
+	GuessConfidence     float64        `json:"guess-confidence"`
+	GuessCommand        []string       `json:"guess-command"`
+	GuessCommandTimeout string         `json:"guess-command-timeout"`
+	// Config.TorrentDir is where .torrent files of synced objects are looked for
+	TorrentDir     string        `json:"torrent-dir"`
+	TorrentRules   []TorrentRule `json:"torrent-rules"`
+	RecordInfohash bool          `json:"record-infohash"`
+	// Config.CategoryLayouts maps categories to how objects synced into them are laid out;
+	// see Layout
+	CategoryLayouts map[string]string `json:"category-layouts"`
+	// Config.CategoryPaths maps categories to templates of the folders objects go into; see
+	// ExpandPath
+	CategoryPaths map[string]string `json:"category-paths"`
+	// Config.StableTime is how long the files of an object must have stayed unchanged before
+	// `drivesyncd` syncs it; "0s" for no wait
+	StableTime string `json:"stable-time"`
+	// Config.IncompletePatterns are shell patterns of the names of files being downloaded, which
+	// keep `drivesyncd` from syncing the objects holding them; DefaultIncompletePatterns if unset
+	IncompletePatterns []string `json:"incomplete-patterns"`
+	// Config.CheckOpenFiles is whether `drivesyncd` waits for the files of an object to be
+	// closed by the processes writing them, as found in /proc
+	CheckOpenFiles bool `json:"check-open-files"`
+	// Config.MetricsAddress is where `drivesyncd` serves Prometheus metrics over HTTP, e.g.
+	// "127.0.0.1:9733"; empty means not at all
+	MetricsAddress string `json:"metrics-address"`
+	// Config.Target denotes the directory to be watched when calling `drivesyncd`, with the
+	// global options; ReadConfig adds it to Targets
+	Target string `json:"target"`
+	// Config.Targets are directories to be watched when calling `drivesyncd`, each with options
+	// of its own
+	Targets  []TargetConfig `json:"targets"`
+	UseProxy bool           `json:"use-proxy"`
+	Verbose  bool           `json:"verbose"`
+
+	// categoryRules and torrentRules are compiled from CategoryRules and TorrentRules by
+	// ReadConfig
+	categoryRules []categoryRule
+	torrentRules  []torrentRule
 }
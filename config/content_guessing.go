@@ -0,0 +1,183 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A PathGuesser is a Guesser that makes use of the full path of objects, being able to look
+// into them.
+type PathGuesser interface {
+	Guesser
+	GuessPath(string) string
+}
+
+// ContentRule gives Weight points to Category for every byte in files having one of Extensions
+// (without the leading dot), or a MIME type, as sniffed with http.DetectContentType, starting
+// with one of MimeTypes. A Weight of 0 is treated as 1.
+type ContentRule struct {
+	Category   string   `json:"category"`
+	Extensions []string `json:"extensions,omitempty"`
+	MimeTypes  []string `json:"mime-types,omitempty"`
+	Weight     float64  `json:"weight,omitempty"`
+}
+
+// DefaultContentRules are used by ContentGuesser if Config.ContentRules is empty.
+var DefaultContentRules = []ContentRule{
+	{Category: "Music", Extensions: []string{"flac", "ape", "wav", "mp3", "m4a", "aac", "ogg", "opus", "wv", "tta", "dsf", "dff"},
+		MimeTypes: []string{"audio/"}},
+	{Category: "Video", Extensions: []string{"mkv", "mp4", "avi", "mov", "wmv", "m2ts", "ts", "webm", "flv", "rmvb"},
+		MimeTypes: []string{"video/"}},
+	{Category: "Software", Extensions: []string{"iso", "dmg", "exe", "msi", "pkg", "deb", "rpm", "apk", "appimage"},
+		MimeTypes: []string{"application/x-msdownload"}},
+	{Category: "Books", Extensions: []string{"epub", "mobi", "azw3", "pdf", "djvu", "cbz", "cbr"},
+		MimeTypes: []string{"application/pdf"}},
+	{Category: "Pictures", Extensions: []string{"jpg", "jpeg", "png", "gif", "webp", "tif", "tiff", "heic", "raw", "cr2", "nef"},
+		MimeTypes: []string{"image/"}},
+}
+
+// contentSampleSize is the maximum number of files looked into by ContentGuesser.
+const contentSampleSize = 256
+
+// errSampled stops the walk of ContentGuesser once contentSampleSize files are sampled.
+var errSampled = errors.New("enough files sampled")
+
+// checkContentRules validates Config.ContentRules.
+func checkContentRules(rules []ContentRule) error {
+	for i, v := range rules {
+		if v.Category == "" {
+			return errors.New(fmt.Sprintf("content rule %d: category not set", i))
+		} else if len(v.Extensions) == 0 && len(v.MimeTypes) == 0 {
+			return errors.New(fmt.Sprintf("content rule %d: neither extensions nor mime-types set", i))
+		} else if v.Weight < 0 {
+			return errors.New(fmt.Sprintf("content rule %d: weight must not be negative", i))
+		}
+	}
+	return nil
+}
+
+// ContentGuesser looks into objects, sampling up to contentSampleSize files in them. The category
+// with the most points given by Config.ContentRules (or DefaultContentRules) over the sampled
+// files is chosen, falling back to the default category if no file scores.
+type contentGuesser struct{}
+
+func (r contentGuesser) Guess(basename string) string {
+	return Config.Get().DefaultCategory
+}
+
+func (r contentGuesser) GuessPath(path string) string {
+	if category, ok := r.TryGuess(path); ok {
+		return category
+	}
+	return Config.Get().DefaultCategory
+}
+
+// TryGuess abstains if no file in the object scores.
+func (r contentGuesser) TryGuess(path string) (string, bool) {
+	conf := Config.Get()
+	rules := conf.ContentRules
+	if len(rules) == 0 {
+		rules = DefaultContentRules
+	}
+	scores := make(map[string]float64)
+	var sampled int
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable parts of the object don't matter much here
+			return nil
+		}
+		if conf.Ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if sampled++; sampled > contentSampleSize {
+			return errSampled
+		}
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+		if category, score := scoreByRules(rules, ext, sniff(path), info.Size()); category != "" {
+			scores[category] += score
+		}
+		return nil
+	})
+	if err != nil && err != errSampled {
+		return "", false
+	}
+	category := bestCategory(scores)
+	return category, category != ""
+}
+
+// scoreByRules returns the category of the first of rules that matches a file with extension ext,
+// MIME type mimeType and given size, with the score the file adds to it: its size, by the weight
+// of the rule. The category is empty if no rule matches.
+func scoreByRules(rules []ContentRule, ext, mimeType string, size int64) (string, float64) {
+	for _, v := range rules {
+		if matchContentRule(v, ext, mimeType) {
+			weight := v.Weight
+			if weight == 0 {
+				weight = 1
+			}
+			// count empty files a little, so that they still count for something
+			return v.Category, weight * float64(size+1)
+		}
+	}
+	return "", 0
+}
+
+// bestCategory returns the category with the highest score, the first by name among equals, or an
+// empty string if there's none.
+func bestCategory(scores map[string]float64) string {
+	category, best := "", 0.0
+	for k, v := range scores {
+		if v > best || v == best && k < category {
+			category, best = k, v
+		}
+	}
+	return category
+}
+
+// matchContentRule returns whether a file with extension ext and MIME type mimeType matches rule.
+func matchContentRule(rule ContentRule, ext, mimeType string) bool {
+	for _, v := range rule.Extensions {
+		if strings.ToLower(strings.TrimPrefix(v, ".")) == ext {
+			return true
+		}
+	}
+	for _, v := range rule.MimeTypes {
+		if mimeType != "" && strings.HasPrefix(mimeType, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniff returns the MIME type of the file at path detected from its first 512 bytes, or an
+// empty string if it can't be read or is not recognized.
+func sniff(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	if n == 0 {
+		return ""
+	}
+	mimeType := http.DetectContentType(buf[:n])
+	if mimeType == "application/octet-stream" {
+		return ""
+	}
+	return mimeType
+}
+
+var ContentGuesser contentGuesser
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates the files named in files under dir, with the content given as the value.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tempDir returns a new temporary directory, removed at the end of the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "drivesync-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// pngHeader is enough of a PNG file for http.DetectContentType.
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+func TestScoreByRules(t *testing.T) {
+	rules := []ContentRule{
+		{Category: "Music", Extensions: []string{".FLAC"}},
+		{Category: "Pictures", MimeTypes: []string{"image/"}, Weight: 2},
+		{Category: "Other", Extensions: []string{"flac", "png"}},
+	}
+	for _, c := range []struct {
+		ext, mimeType string
+		size          int64
+		category      string
+		score         float64
+	}{
+		{"flac", "", 99, "Music", 100},
+		{"flac", "image/png", 0, "Music", 1},
+		{"png", "image/png", 9, "Pictures", 20},
+		{"png", "", 9, "Other", 10},
+		{"txt", "text/plain; charset=utf-8", 9, "", 0},
+	} {
+		category, score := scoreByRules(rules, c.ext, c.mimeType, c.size)
+		if category != c.category || score != c.score {
+			t.Errorf("%s file of type %q and size %d scores %s %v, want %s %v",
+				c.ext, c.mimeType, c.size, category, score, c.category, c.score)
+		}
+	}
+}
+
+func TestBestCategory(t *testing.T) {
+	for _, c := range []struct {
+		scores map[string]float64
+		want   string
+	}{
+		{nil, ""},
+		{map[string]float64{"Music": 10, "Video": 20}, "Video"},
+		{map[string]float64{"Video": 20, "Music": 20, "Books": 1}, "Music"},
+	} {
+		if got := bestCategory(c.scores); got != c.want {
+			t.Errorf("best of %v is %q, want %q", c.scores, got, c.want)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{
+		"cover": pngHeader + "rest",
+		"blob":  "\x00\x01\x02\x03",
+		"empty": "",
+		"notes": "plain text",
+	})
+	for name, want := range map[string]string{
+		"cover":   "image/png",
+		"blob":    "",
+		"empty":   "",
+		"notes":   "text/plain; charset=utf-8",
+		"missing": "",
+	} {
+		if got := sniff(filepath.Join(dir, name)); got != want {
+			t.Errorf("%s sniffed as %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestContentGuesser(t *testing.T) {
+	big := string(bytes.Repeat([]byte("x"), 1000))
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		// more files of one category, outweighed by the size of another
+		{"size", map[string]string{"1.flac": "a", "2.flac": "b", "3.flac": "c", "film.mkv": big}, "Video"},
+		// no extension to go by
+		{"sniffed", map[string]string{"scan": pngHeader + big, "notes.txt": big}, "Pictures"},
+		{"ignored", map[string]string{"film.mkv": big, ".git/pack.flac": big + big}, "Video"},
+		{"nothing", map[string]string{"notes.txt": big}, ""},
+	} {
+		conf := NewConfig()
+		conf.DefaultCategory = "Uncategorized"
+		Config.Set(conf)
+		dir := tempDir(t)
+		writeFiles(t, dir, c.files)
+		got, ok := ContentGuesser.TryGuess(dir)
+		if got != c.want || ok != (c.want != "") {
+			t.Errorf("%s: guessed %q, %v; want %q", c.name, got, ok, c.want)
+		}
+		if c.want == "" && ContentGuesser.GuessPath(dir) != "Uncategorized" {
+			t.Errorf("%s: abstaining guess not the default category", c.name)
+		}
+	}
+}
+
+func TestContentGuesserCustomRules(t *testing.T) {
+	conf := NewConfig()
+	conf.ContentRules = []ContentRule{
+		{Category: "Scores", Extensions: []string{"mscz"}, Weight: 100},
+		{Category: "Music", MimeTypes: []string{"audio/"}},
+	}
+	Config.Set(conf)
+	dir := tempDir(t)
+	writeFiles(t, dir, map[string]string{"a.mscz": "small", "a.wav": "RIFF\x00\x00\x00\x00WAVEfmt " + "long recording"})
+	if got, _ := ContentGuesser.TryGuess(dir); got != "Scores" {
+		t.Errorf("guessed %q, want Scores by weight", got)
+	}
+}
+
+func TestContentGuesserSamples(t *testing.T) {
+	conf := NewConfig()
+	Config.Set(conf)
+	dir := tempDir(t)
+	files := map[string]string{"z.mkv": string(bytes.Repeat([]byte("x"), 1<<20))}
+	for i := 0; i < contentSampleSize; i++ {
+		files[fmt.Sprintf("%03d.flac", i)] = "a"
+	}
+	writeFiles(t, dir, files)
+	// the walk stops before reaching the video, which comes last
+	if got, ok := ContentGuesser.TryGuess(dir); got != "Music" || !ok {
+		t.Errorf("guessed %q, %v; want Music from the files sampled", got, ok)
+	}
+}
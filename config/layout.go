@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Layouts of objects synced into a category, for Config.CategoryLayouts.
+const (
+	// LayoutTree mirrors the local tree of objects; it is the default.
+	LayoutTree = "tree"
+	// LayoutAudioTags files audio files as Artist/Album (Year)/NN - Title.ext by their tags,
+	// falling back to LayoutTree for objects without any tagged audio file.
+	LayoutAudioTags = "audio-tags"
+)
+
+// checkLayouts validates the layouts in Config.CategoryLayouts.
+func checkLayouts(layouts map[string]string) error {
+	for k, v := range layouts {
+		switch v {
+		case LayoutTree, LayoutAudioTags:
+		default:
+			return errors.New(fmt.Sprintf("unknown layout %q of category %q", v, k))
+		}
+	}
+	return nil
+}
+
+// Layout returns the layout of objects synced into category.
+func (r config) Layout(category string) string {
+	if v, ok := r.CategoryLayouts[category]; ok {
+		return v
+	}
+	return LayoutTree
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Model is a multinomial naive Bayes model of the tokens in the names of objects in each
+// category, trained from the archive by R.TrainGuesser.
+type Model struct {
+	// Categories maps category names to the statistics of their objects.
+	Categories map[string]*CategoryStats `json:"categories"`
+	// Vocabulary is the number of distinct tokens seen in training.
+	Vocabulary int       `json:"vocabulary"`
+	TrainTime  time.Time `json:"train-time"`
+}
+
+// CategoryStats holds the statistics of the objects of a category.
+type CategoryStats struct {
+	Objects int `json:"objects"`
+	// Tokens maps tokens to the number of their occurrences in the names of the objects.
+	Tokens map[string]int `json:"tokens"`
+	// TokenCount is the total number of tokens in the names of the objects.
+	TokenCount int `json:"token-count"`
+}
+
+// tokenize splits a basename into lowercase words and numbers. The extension of a file, if any,
+// is kept as a token of its own in the form of ".ext".
+func tokenize(basename string) []string {
+	var ret []string
+	if ext := filepath.Ext(basename); ext != "" && len(ext) <= 6 && !strings.ContainsAny(ext, " ") {
+		ret = append(ret, strings.ToLower(ext))
+		basename = strings.TrimSuffix(basename, ext)
+	}
+	return append(ret, strings.FieldsFunc(strings.ToLower(basename), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})...)
+}
+
+// TrainModel builds a Model from samples, which maps category names to names of objects in them.
+func TrainModel(samples map[string][]string) *Model {
+	m := &Model{Categories: make(map[string]*CategoryStats), TrainTime: time.Now()}
+	vocabulary := make(map[string]struct{})
+	for category, names := range samples {
+		stats := &CategoryStats{Tokens: make(map[string]int)}
+		for _, name := range names {
+			stats.Objects++
+			for _, t := range tokenize(name) {
+				stats.Tokens[t]++
+				stats.TokenCount++
+				vocabulary[t] = struct{}{}
+			}
+		}
+		m.Categories[category] = stats
+	}
+	m.Vocabulary = len(vocabulary)
+	return m
+}
+
+// Classify returns the most probable category of basename, along with the confidence in it,
+// that is, its posterior probability among all categories. It returns an empty category if the
+// model has no objects, or no token of basename has been seen in training, in which case
+// there's nothing but the sizes of the categories to tell from.
+func (r *Model) Classify(basename string) (string, float64) {
+	var total int
+	for _, v := range r.Categories {
+		total += v.Objects
+	}
+	if total == 0 {
+		return "", 0
+	}
+	tokens := tokenize(basename)
+	var known bool
+	for _, t := range tokens {
+		for _, v := range r.Categories {
+			if v.Tokens[t] > 0 {
+				known = true
+				break
+			}
+		}
+	}
+	if !known {
+		return "", 0
+	}
+	scores := make(map[string]float64)
+	best, bestScore := "", math.Inf(-1)
+	for category, v := range r.Categories {
+		if v.Objects == 0 {
+			continue
+		}
+		// log-probabilities, with Laplace smoothing
+		score := math.Log(float64(v.Objects) / float64(total))
+		for _, t := range tokens {
+			score += math.Log(float64(v.Tokens[t]+1) / float64(v.TokenCount+r.Vocabulary))
+		}
+		scores[category] = score
+		if score > bestScore || score == bestScore && category < best {
+			best, bestScore = category, score
+		}
+	}
+	var sum float64
+	for _, v := range scores {
+		sum += math.Exp(v - bestScore)
+	}
+	return best, 1 / sum
+}
+
+// Save writes the model to path in JSON.
+func (r *Model) Save(path string) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadModel reads the model saved at path.
+func LoadModel(path string) (*Model, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to decode model: %v", err))
+	}
+	return &m, nil
+}
+
+// LearningGuesser classifies basenames with the Model at Config.GuesserModel, falling back to
+// the default category if the confidence is below Config.GuessConfidence, or the model can't be
+// loaded. The model is reloaded when the file changes.
+type learningGuesser struct {
+	m       sync.Mutex
+	model   *Model
+	path    string
+	modTime time.Time
+}
+
+// load returns the model at path, loading it again if the file has changed.
+func (r *learningGuesser) load(path string) (*Model, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if r.model == nil || r.path != path || !fi.ModTime().Equal(r.modTime) {
+		model, err := LoadModel(path)
+		if err != nil {
+			return nil, err
+		}
+		r.model, r.path, r.modTime = model, path, fi.ModTime()
+	}
+	return r.model, nil
+}
+
+func (r *learningGuesser) Guess(basename string) string {
+	if category, ok := r.TryGuess(basename); ok {
+		return category
+	}
+	return Config.Get().DefaultCategory
+}
+
+// TryGuess abstains if the confidence is below Config.GuessConfidence, or the model can't be
+// loaded.
+func (r *learningGuesser) TryGuess(path string) (string, bool) {
+	conf := Config.Get()
+	basename := filepath.Base(path)
+	model, err := r.load(conf.GuesserModel)
+	if err != nil {
+		log.Printf("W: Failed to load guesser model: %v", err)
+		return "", false
+	}
+	category, confidence := model.Classify(basename)
+	if category == "" || confidence < conf.GuessConfidence {
+		if conf.Verbose {
+			log.Printf("I: Guessed %q for %q with confidence %.2f; abstaining.", category, basename, confidence)
+		}
+		return "", false
+	}
+	if conf.Verbose {
+		log.Printf("I: Guessed %q for %q with confidence %.2f.", category, basename, confidence)
+	}
+	return category, true
+}
+
+var LearningGuesser = &learningGuesser{}
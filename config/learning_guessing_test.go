@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, c := range []struct {
+		basename string
+		want     []string
+	}{
+		{"Pink Floyd - The Wall (1979) [FLAC].flac", []string{".flac", "pink", "floyd", "the", "wall", "1979", "flac"}},
+		{"Some.Show.S01E02.1080p.mkv", []string{".mkv", "some", "show", "s01e02", "1080p"}},
+		{"ubuntu-20.04-desktop-amd64.iso", []string{".iso", "ubuntu", "20", "04", "desktop", "amd64"}},
+		// not extensions
+		{"Vol. 2", []string{"vol", "2"}},
+		{"Album.Deluxe_Edition", []string{"album", "deluxe", "edition"}},
+		{"東京事変 - 教育", []string{"東京事変", "教育"}},
+		{"", nil},
+	} {
+		if got := tokenize(c.basename); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", c.basename, got, c.want)
+		}
+	}
+}
+
+// testModel returns a Model trained on a few names in Music and Software.
+func testModel() *Model {
+	return TrainModel(map[string][]string{
+		"Music": {
+			"Pink Floyd - The Wall (1979) [FLAC]",
+			"Miles Davis - Kind of Blue (1959) [FLAC]",
+			"Radiohead - OK Computer (1997) [FLAC]",
+			"01 - Airbag.flac",
+		},
+		"Software": {
+			"ubuntu-20.04-desktop-amd64.iso",
+			"debian-10.4.0-amd64-netinst.iso",
+		},
+		"Empty": nil,
+	})
+}
+
+func TestModelClassify(t *testing.T) {
+	m := testModel()
+	if m.Vocabulary == 0 || m.Categories["Music"].Objects != 4 || m.Categories["Software"].TokenCount != 13 {
+		t.Fatalf("model trained into %+v", m)
+	}
+	for _, c := range []struct {
+		basename string
+		category string
+		// the confidence is above min
+		min float64
+	}{
+		{"Led Zeppelin - IV (1971) [FLAC]", "Music", 0.7},
+		{"fedora-32-amd64.iso", "Software", 0.85},
+		// nothing known
+		{"zzz", "", 0},
+	} {
+		category, confidence := m.Classify(c.basename)
+		if category != c.category || confidence < c.min || confidence > 1 {
+			t.Errorf("%q classified as %q with confidence %.2f, want %q above %.2f",
+				c.basename, category, confidence, c.category, c.min)
+		}
+	}
+	if category, _ := (&Model{}).Classify("a.flac"); category != "" {
+		t.Errorf("empty model classified into %q", category)
+	}
+}
+
+func TestModelSave(t *testing.T) {
+	path := filepath.Join(tempDir(t), "model.json")
+	m := testModel()
+	if err := m.Save(path); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+	got, err := LoadModel(path)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if !got.TrainTime.Equal(m.TrainTime) {
+		t.Errorf("train time %v, want %v", got.TrainTime, m.TrainTime)
+	}
+	got.TrainTime = m.TrainTime
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("loaded %+v, want %+v", got, m)
+	}
+	writeFiles(t, filepath.Dir(path), map[string]string{"bad.json": "{"})
+	if _, err := LoadModel(filepath.Join(filepath.Dir(path), "bad.json")); err == nil {
+		t.Error("malformed model loaded")
+	}
+}
+
+func TestLearningGuesser(t *testing.T) {
+	path := filepath.Join(tempDir(t), "model.json")
+	if err := testModel().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	conf := NewConfig()
+	conf.DefaultCategory = "Uncategorized"
+	conf.GuesserModel = path
+	conf.GuessConfidence = 0.7
+	Config.Set(conf)
+	g := &learningGuesser{}
+	for name, want := range map[string]string{
+		"/data/Led Zeppelin - IV (1971) [FLAC]": "Music",
+		"/data/fedora-32-amd64.iso":             "Software",
+		"/data/zzz":                             "Uncategorized",
+		// seen once, in the smaller category; not confident enough
+		"/data/10": "Uncategorized",
+	} {
+		if got := g.Guess(name); got != want {
+			t.Errorf("%s guessed into %q, want %q", name, got, want)
+		}
+	}
+	// reloaded once changed
+	retrained := TrainModel(map[string][]string{"Books": {"Kind of Blue - A History.epub"}})
+	if err := retrained.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Guess("/data/Kind of Blue"); got != "Books" {
+		t.Errorf("guessed into %q after retraining, want Books", got)
+	}
+	// no model to load
+	conf.GuesserModel = filepath.Join(filepath.Dir(path), "missing.json")
+	Config.Set(conf)
+	if _, ok := g.TryGuess("/data/Kind of Blue"); ok {
+		t.Error("guessed without a model")
+	}
+}
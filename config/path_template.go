@@ -0,0 +1,91 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathVariable matches the variables in the path templates of Config.CategoryPaths, like
+// {year}, or {sha256[:2]} for the first two characters of the value.
+var pathVariable = regexp.MustCompile(`\{([a-z0-9]+)(?:\[:([0-9]+)\])?\}`)
+
+// pathVariables are the variables known in path templates. Dates are of the modification time of
+// the object; sha256 is the hex SHA-256 of the basename.
+var pathVariables = map[string]func(path string, info os.FileInfo) string{
+	"year":  func(_ string, info os.FileInfo) string { return info.ModTime().Format("2006") },
+	"month": func(_ string, info os.FileInfo) string { return info.ModTime().Format("01") },
+	"day":   func(_ string, info os.FileInfo) string { return info.ModTime().Format("02") },
+	"ext": func(path string, info os.FileInfo) string {
+		if info.IsDir() {
+			return ""
+		}
+		return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	},
+	"basename": func(path string, _ os.FileInfo) string { return filepath.Base(path) },
+	"hostname": func(_ string, _ os.FileInfo) string {
+		name, _ := os.Hostname()
+		return name
+	},
+	"sha256": func(path string, _ os.FileInfo) string {
+		sum := sha256.Sum256([]byte(filepath.Base(path)))
+		return hex.EncodeToString(sum[:])
+	},
+}
+
+// checkCategoryPaths validates the path templates in Config.CategoryPaths.
+func checkCategoryPaths(paths map[string]string) error {
+	for k, v := range paths {
+		if strings.HasPrefix(v, "/") {
+			return errors.New(fmt.Sprintf("path template of category %q must be relative", k))
+		}
+		for _, m := range pathVariable.FindAllStringSubmatch(v, -1) {
+			if _, ok := pathVariables[m[1]]; !ok {
+				return errors.New(fmt.Sprintf("unknown variable %q in path template of category %q", m[1], k))
+			}
+		}
+		for _, c := range strings.Split(pathVariable.ReplaceAllString(v, "x"), "/") {
+			if c == "." || c == ".." || strings.ContainsAny(c, "{}") {
+				return errors.New(fmt.Sprintf("bad path template %q of category %q", v, k))
+			}
+		}
+	}
+	return nil
+}
+
+// ExpandPath expands the path template of category for the object at path, returning the
+// folders to put the object in, relative to the category folder. Folders that expand to an empty
+// name are left out. It returns nil if the category has no path template.
+func (r config) ExpandPath(category, path string) ([]string, error) {
+	template, ok := r.CategoryPaths[category]
+	if !ok || template == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to stat '%s': %v", path, err))
+	}
+	expanded := pathVariable.ReplaceAllStringFunc(template, func(v string) string {
+		m := pathVariable.FindStringSubmatch(v)
+		value := strings.Replace(pathVariables[m[1]](path, info), "/", "_", -1)
+		if m[2] != "" {
+			if n, _ := strconv.Atoi(m[2]); n < len(value) {
+				value = value[:n]
+			}
+		}
+		return value
+	})
+	var ret []string
+	for _, v := range strings.Split(expanded, "/") {
+		if v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RateWindow is a daily window of time, from Start to End in the form of "15:04", during which
+// uploads are limited to Limit bytes per second instead of Config.UploadRateLimit; a Limit of 0
+// means no limit. A window with End before Start spans midnight.
+type RateWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Limit int64  `json:"limit"`
+}
+
+// minutes returns the start and end of the window in minutes since midnight.
+func (r RateWindow) minutes() (int, int, error) {
+	start, err := time.Parse("15:04", r.Start)
+	if err != nil {
+		return 0, 0, errors.New(fmt.Sprintf("invalid start %q: %v", r.Start, err))
+	}
+	end, err := time.Parse("15:04", r.End)
+	if err != nil {
+		return 0, 0, errors.New(fmt.Sprintf("invalid end %q: %v", r.End, err))
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// UploadRateAt returns the upload rate limit in bytes per second in effect at t, following
+// UploadSchedule; 0 means no limit. The first window containing t applies.
+func (r config) UploadRateAt(t time.Time) int64 {
+	now := t.Hour()*60 + t.Minute()
+	for _, w := range r.UploadSchedule {
+		start, end, err := w.minutes()
+		if err != nil {
+			continue
+		}
+		if start <= end && start <= now && now < end ||
+			start > end && (start <= now || now < end) {
+			return w.Limit
+		}
+	}
+	return r.UploadRateLimit
+}
+
+// checkRateLimits validates the upload rate limit and schedule of r.
+func (r config) checkRateLimits() error {
+	if r.UploadRateLimit < 0 {
+		return errors.New(`"upload-rate-limit" must not be negative`)
+	}
+	for i, w := range r.UploadSchedule {
+		if _, _, err := w.minutes(); err != nil {
+			return errors.New(fmt.Sprintf(`window %d of "upload-rate-schedule": %v`, i, err))
+		} else if w.Limit < 0 {
+			return errors.New(fmt.Sprintf(`window %d of "upload-rate-schedule": limit must not be negative`, i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadRateAt(t *testing.T) {
+	conf := NewConfig()
+	conf.UploadRateLimit = 100
+	conf.UploadSchedule = []RateWindow{
+		{Start: "09:00", End: "17:00", Limit: 10},
+		// spans midnight
+		{Start: "23:00", End: "06:30", Limit: 0},
+		// empty, as start == end
+		{Start: "20:00", End: "20:00", Limit: 1},
+		// the first window containing the time applies
+		{Start: "16:00", End: "18:00", Limit: 20},
+		{Start: "bad", End: "19:00", Limit: 30},
+	}
+	for _, c := range []struct {
+		at   string
+		want int64
+	}{
+		{"08:59", 100},
+		{"09:00", 10},
+		{"16:30", 10},
+		{"17:00", 20},
+		{"18:00", 100},
+		{"18:30", 100},
+		{"20:00", 100},
+		{"22:59", 100},
+		{"23:00", 0},
+		{"00:00", 0},
+		{"06:29", 0},
+		{"06:30", 100},
+	} {
+		at, err := time.Parse("15:04", c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conf.UploadRateAt(at); got != c.want {
+			t.Errorf("rate at %s is %d, want %d", c.at, got, c.want)
+		}
+	}
+}
+
+func TestCheckRateLimits(t *testing.T) {
+	for _, c := range []struct {
+		limit    int64
+		schedule []RateWindow
+		ok       bool
+	}{
+		{0, nil, true},
+		{-1, nil, false},
+		{100, []RateWindow{{Start: "23:00", End: "06:00", Limit: 10}}, true},
+		{100, []RateWindow{{Start: "25:00", End: "06:00", Limit: 10}}, false},
+		{100, []RateWindow{{Start: "23:00", End: "6am", Limit: 10}}, false},
+		{100, []RateWindow{{Start: "23:00", End: "06:00", Limit: -1}}, false},
+	} {
+		conf := NewConfig()
+		conf.UploadRateLimit, conf.UploadSchedule = c.limit, c.schedule
+		if err := conf.checkRateLimits(); (err == nil) != c.ok {
+			t.Errorf("limit %d with schedule %v: error %v", c.limit, c.schedule, err)
+		}
+	}
+}
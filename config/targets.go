@@ -0,0 +1,134 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A TargetConfig is a directory watched by `drivesyncd`, with options that take precedence over
+// the global ones for the objects in it; see ForPath. Options left out fall back to the global
+// ones.
+type TargetConfig struct {
+	Path string `json:"path"`
+	// TargetConfig.DefaultCategory is the category of the objects in the target; without
+	// Guesser, they all go into it without guessing, and otherwise it's what the Guessers fall
+	// back on
+	DefaultCategory string `json:"default-category,omitempty"`
+	// TargetConfig.Guesser is a chain of Guessers, as Config.Guesser
+	Guesser string `json:"guesser,omitempty"`
+	// TargetConfig.Ignore are shell patterns of the objects in the target not to be synced; see
+	// Ignored
+	Ignore        []string `json:"ignore,omitempty"`
+	ForceRecheck  *bool    `json:"force-recheck,omitempty"`
+	CreateMissing *bool    `json:"create-missing,omitempty"`
+	ArchiveRoot   string   `json:"archive-root,omitempty"`
+}
+
+// contains returns whether path is dir or inside it.
+func contains(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// checkTargets validates the targets, cleaning their paths. Targets must not overlap, as the
+// objects in them would be synced twice.
+func checkTargets(targets []TargetConfig) error {
+	for i := range targets {
+		t := &targets[i]
+		if t.Path == "" {
+			return errors.New(`"path" not set for a target`)
+		}
+		t.Path = filepath.Clean(t.Path)
+		for _, v := range targets[:i] {
+			if contains(v.Path, t.Path) || contains(t.Path, v.Path) {
+				return errors.New(fmt.Sprintf("targets %q and %q overlap", v.Path, t.Path))
+			}
+		}
+		if t.DefaultCategory != "" {
+			if err := CheckCategory(t.DefaultCategory); err != nil {
+				return errors.New(fmt.Sprintf("target %q: %v", t.Path, err))
+			}
+		}
+		if t.Guesser != "" {
+			if err := checkGuesser(t.Guesser); err != nil {
+				return errors.New(fmt.Sprintf("target %q: %v", t.Path, err))
+			}
+		}
+		for _, v := range t.Ignore {
+			if _, err := filepath.Match(v, ""); err != nil {
+				return errors.New(fmt.Sprintf("target %q: bad ignore pattern %q: %v", t.Path, v, err))
+			}
+		}
+	}
+	return nil
+}
+
+// targetOf returns the target containing path, if any.
+func (r config) targetOf(path string) (TargetConfig, bool) {
+	path = filepath.Clean(path)
+	for _, v := range r.Targets {
+		if contains(v.Path, path) {
+			return v, true
+		}
+	}
+	return TargetConfig{}, false
+}
+
+// ForPath returns the configuration for syncing the object at path, with the options of the
+// target containing it, if any, taking precedence.
+func (r config) ForPath(path string) config {
+	t, ok := r.targetOf(path)
+	if !ok {
+		return r
+	}
+	if t.DefaultCategory != "" {
+		r.DefaultCategory = t.DefaultCategory
+		// no guessing unless the target has Guessers of its own
+		r.Guesser = t.Guesser
+	} else if t.Guesser != "" {
+		r.Guesser = t.Guesser
+	}
+	if t.ForceRecheck != nil {
+		r.ForceRecheck = *t.ForceRecheck
+	}
+	if t.CreateMissing != nil {
+		r.CreateMissing = *t.CreateMissing
+	}
+	if t.ArchiveRoot != "" {
+		r.ArchiveRootName = t.ArchiveRoot
+	}
+	return r
+}
+
+// Ignored returns whether the object at path is not to be synced: its name is in IgnoreList, or
+// it's in a target and matched by an ignore pattern of the target, along with everything in it.
+// Patterns containing a "/" are matched against the path relative to the target, e.g.
+// "Sample/*.mkv"; the others against names, e.g. "*.nfo".
+func (r config) Ignored(path string) bool {
+	if _, ok := IgnoreList[filepath.Base(path)]; ok {
+		return true
+	}
+	t, ok := r.targetOf(path)
+	if !ok || len(t.Ignore) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(t.Path, filepath.Clean(path))
+	if err != nil || rel == "." {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, name := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, v := range t.Ignore {
+			subject := name
+			if strings.Contains(v, "/") {
+				subject = prefix
+			}
+			if ok, _ := filepath.Match(v, subject); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckTargets(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		targets []TargetConfig
+		err     string
+	}{
+		{"disjoint", []TargetConfig{{Path: "/data/music"}, {Path: "/data/musicals"}, {Path: "/data/video/"}}, ""},
+		{"no path", []TargetConfig{{Path: "/data/music"}, {}}, `"path" not set`},
+		{"same", []TargetConfig{{Path: "/data/music"}, {Path: "/data/music/"}}, "overlap"},
+		{"inside", []TargetConfig{{Path: "/data/music"}, {Path: "/data/video"}, {Path: "/data/music/flac"}}, "overlap"},
+		{"outside", []TargetConfig{{Path: "/data/music/flac"}, {Path: "/data/./music"}}, "overlap"},
+		{"bad category", []TargetConfig{{Path: "/data/music", DefaultCategory: "../Music"}}, "target"},
+		{"bad guesser", []TargetConfig{{Path: "/data/music", Guesser: "rules, psychic"}}, `unknown guesser "psychic"`},
+		{"bad ignore pattern", []TargetConfig{{Path: "/data/music", Ignore: []string{"*.nfo", "[a-"}}}, "bad ignore pattern"},
+	} {
+		err := checkTargets(c.targets)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error %v, want one containing %q", c.name, err, c.err)
+		}
+	}
+	targets := []TargetConfig{{Path: "/data/music/"}, {Path: "/data//video/./"}}
+	if err := checkTargets(targets); err != nil {
+		t.Fatal(err)
+	}
+	if targets[0].Path != "/data/music" || targets[1].Path != "/data/video" {
+		t.Errorf("paths not cleaned: %v", targets)
+	}
+}
+
+func TestForPath(t *testing.T) {
+	yes, no := true, false
+	conf := NewConfig()
+	conf.DefaultCategory = "Misc"
+	conf.Guesser = "rules,content"
+	conf.ArchiveRootName = "archive"
+	conf.ForceRecheck = false
+	conf.CreateMissing = true
+	conf.Targets = []TargetConfig{
+		{Path: "/data/music", DefaultCategory: "Music"},
+		{Path: "/data/video", DefaultCategory: "Films", Guesser: "torrent"},
+		{Path: "/data/downloads", Guesser: "learning"},
+		{Path: "/data/backups", ForceRecheck: &yes, CreateMissing: &no, ArchiveRoot: "backup-archive"},
+	}
+	for _, c := range []struct {
+		path          string
+		category      string
+		guesser       string
+		forceRecheck  bool
+		createMissing bool
+		archiveRoot   string
+	}{
+		{"/data/other/a.flac", "Misc", "rules,content", false, true, "archive"},
+		{"/data/musicals/a", "Misc", "rules,content", false, true, "archive"},
+		// the category of the target clears the global Guessers
+		{"/data/music/Album", "Music", "", false, true, "archive"},
+		{"/data/music", "Music", "", false, true, "archive"},
+		{"/data/video/Film/", "Films", "torrent", false, true, "archive"},
+		{"/data/downloads/x", "Misc", "learning", false, true, "archive"},
+		{"/data/backups/2020/dump.sql", "Misc", "rules,content", true, false, "backup-archive"},
+	} {
+		got := conf.ForPath(c.path)
+		if got.DefaultCategory != c.category || got.Guesser != c.guesser {
+			t.Errorf("%s: category %q, guesser %q; want %q, %q", c.path, got.DefaultCategory, got.Guesser,
+				c.category, c.guesser)
+		}
+		if got.ForceRecheck != c.forceRecheck || got.CreateMissing != c.createMissing ||
+			got.ArchiveRootName != c.archiveRoot {
+			t.Errorf("%s: force-recheck %v, create-missing %v, archive root %q; want %v, %v, %q", c.path,
+				got.ForceRecheck, got.CreateMissing, got.ArchiveRootName, c.forceRecheck, c.createMissing,
+				c.archiveRoot)
+		}
+	}
+	if conf.DefaultCategory != "Misc" || conf.Guesser != "rules,content" {
+		t.Error("global options changed")
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	conf := NewConfig()
+	conf.Targets = []TargetConfig{
+		{Path: "/data/video", Ignore: []string{"*.nfo", "Sample", "*/Extras/*.mkv"}},
+		{Path: "/data/music"},
+	}
+	for _, c := range []struct {
+		path string
+		want bool
+	}{
+		{"/data/video", false},
+		{"/data/video/Film", false},
+		{"/data/video/Film/film.mkv", false},
+		// names only match at any depth
+		{"/data/video/Film/film.nfo", true},
+		{"/data/video/film.nfo", true},
+		{"/data/video/Film/Sample", true},
+		{"/data/video/Film/Sample/sample.mkv", true},
+		{"/data/video/Film/Samples", false},
+		// patterns with "/" match the path relative to the target
+		{"/data/video/Film/Extras/a.mkv", true},
+		{"/data/video/Film/Extras/a.srt", false},
+		{"/data/video/Extras/a.mkv", false},
+		{"/data/video/Show/S01/Extras/a.mkv", false},
+		{"/data/video/Film/Extras/Sub/a.mkv", false},
+		// IgnoreList applies everywhere
+		{"/data/music/Album/.DS_Store", true},
+		{"/elsewhere/.git", true},
+		{"/data/music/Album/a.nfo", false},
+		{"/elsewhere/a.nfo", false},
+	} {
+		if got := conf.Ignored(c.path); got != c.want {
+			t.Errorf("Ignored(%s) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
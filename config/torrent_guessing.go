@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/KireinaHoro/DriveSync/torrent"
+)
+
+// TorrentRule maps objects downloaded with torrents from a tracker, or with a comment, to
+// Category. Tracker is matched case-insensitively as a substring of the announce URLs, and
+// Comment is a regular expression; if both are set, both have to match.
+type TorrentRule struct {
+	Tracker  string `json:"tracker,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Category string `json:"category"`
+}
+
+// torrentRule is a TorrentRule ready for matching.
+type torrentRule struct {
+	tracker  string
+	comment  *regexp.Regexp
+	category string
+}
+
+// compileTorrentRules validates rules, turning them into torrentRules.
+func compileTorrentRules(rules []TorrentRule) ([]torrentRule, error) {
+	var ret []torrentRule
+	for i, v := range rules {
+		if v.Tracker == "" && v.Comment == "" {
+			return nil, errors.New(fmt.Sprintf("torrent rule %d: neither tracker nor comment set", i))
+		} else if v.Category == "" {
+			return nil, errors.New(fmt.Sprintf("torrent rule %d: category not set", i))
+		}
+		rule := torrentRule{tracker: strings.ToLower(v.Tracker), category: v.Category}
+		if v.Comment != "" {
+			re, err := regexp.Compile(v.Comment)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("torrent rule %d: bad comment regex: %v", i, err))
+			}
+			rule.comment = re
+		}
+		ret = append(ret, rule)
+	}
+	return ret, nil
+}
+
+func (r torrentRule) match(m *torrent.Metainfo) bool {
+	if r.tracker != "" {
+		var ok bool
+		for _, t := range m.Trackers {
+			if strings.Contains(strings.ToLower(t), r.tracker) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return r.comment == nil || r.comment.MatchString(m.Comment)
+}
+
+// TorrentGuesser looks for the .torrent file of objects in Config.TorrentDir. The category of
+// the first rule in Config.TorrentRules matching the torrent is chosen; failing that, the file
+// list of the torrent is scored by extension with the content rules, as ContentGuesser does
+// with files on disk.
+type torrentGuesser struct{}
+
+func (r torrentGuesser) Guess(basename string) string {
+	if category, ok := r.TryGuess(basename); ok {
+		return category
+	}
+	return Config.Get().DefaultCategory
+}
+
+// TryGuess abstains if there's no .torrent file for the object, or nothing in it tells the
+// category.
+func (r torrentGuesser) TryGuess(objectPath string) (string, bool) {
+	conf := Config.Get()
+	if conf.TorrentDir == "" {
+		return "", false
+	}
+	m, err := torrent.Find(conf.TorrentDir, objectPath)
+	if err != nil {
+		log.Printf("W: Failed to look for torrent of %q: %v", objectPath, err)
+		return "", false
+	} else if m == nil {
+		return "", false
+	}
+	for _, v := range conf.torrentRules {
+		if v.match(m) {
+			return v.category, true
+		}
+	}
+	rules := conf.ContentRules
+	if len(rules) == 0 {
+		rules = DefaultContentRules
+	}
+	scores := make(map[string]float64)
+	for _, f := range m.Files {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(f.Path), "."))
+		if category, score := scoreByRules(rules, ext, "", f.Length); category != "" {
+			scores[category] += score
+		}
+	}
+	category := bestCategory(scores)
+	return category, category != ""
+}
+
+var TorrentGuesser torrentGuesser
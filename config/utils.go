@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -86,15 +88,25 @@ func ReadConfig(isDaemon bool) error {
 		}
 		newConfig := config{
 			ArchiveRootName:   ArchiveRootName,
+			Backend:           Backend,
 			ClientSecretPath:  parentPath + "client_secret.json",
 			CreateMissing:     CreateMissing,
 			DefaultCategory:   Category,
 			ForceRecheck:      ForceRecheck,
+			Guesser:           GuesserName,
+			GuesserModel:      parentPath + GuesserModelName,
+			GuessConfidence:   GuessConfidence,
+			Incremental:       Incremental,
 			LogFile:           logPath + "/drivesyncd.log",
+			MaxUploads:        MaxUploads,
+			MaxRetries:        MaxRetries,
 			PidFile:           pidPath + "/drivesyncd.pid",
 			RetryRatio:        RetryRatio,
 			RetryStartingRate: RetryStartingRate,
 			ScanInterval:      ScanInterval,
+			StableTime:        StableTime,
+			StateFile:         parentPath + StateFileName,
+			UploadChunkSize:   UploadChunkSize,
 			Verbose:           Verbose,
 			UseProxy:          UseProxy,
 		}
@@ -109,7 +121,7 @@ func ReadConfig(isDaemon bool) error {
 		}
 		log.Printf("I: Default config file created at %q.", configPath)
 		if isDaemon {
-			return errors.New(`please set field "target" in the configuration file`)
+			return errors.New(`please set field "target" or "targets" in the configuration file`)
 		} else {
 			return nil
 		}
@@ -125,12 +137,131 @@ func ReadConfig(isDaemon bool) error {
 	}
 	if newConfig.Target != "" {
 		newConfig.Target = filepath.Clean(newConfig.Target)
-	} else if isDaemon {
-		return errors.New(`please set field "target" in the configuration file`)
+		newConfig.Targets = append([]TargetConfig{{Path: newConfig.Target}}, newConfig.Targets...)
+	} else if isDaemon && len(newConfig.Targets) == 0 {
+		return errors.New(`please set field "target" or "targets" in the configuration file`)
+	}
+	if err := checkTargets(newConfig.Targets); err != nil {
+		return err
+	}
+	if newConfig.DefaultCategory == "" {
+		newConfig.DefaultCategory = Category
+	} else if err := CheckCategory(newConfig.DefaultCategory); err != nil {
+		return errors.New(fmt.Sprintf(`"default-category": %v`, err))
+	}
+	if newConfig.MaxUploads == 0 {
+		newConfig.MaxUploads = MaxUploads
+	} else if newConfig.MaxUploads < 0 {
+		return errors.New(`"max-concurrent-uploads" must be positive`)
+	}
+	if newConfig.MaxRetries == 0 {
+		newConfig.MaxRetries = MaxRetries
+	} else if newConfig.MaxRetries < 0 {
+		return errors.New(`"max-retries" must be positive`)
+	}
+	if newConfig.RetryStartingRate == 0 {
+		newConfig.RetryStartingRate = RetryStartingRate
+	} else if newConfig.RetryStartingRate < 0 {
+		return errors.New(`"retry-starting-rate" must be positive`)
+	}
+	if newConfig.RetryRatio == 0 {
+		newConfig.RetryRatio = RetryRatio
+	} else if newConfig.RetryRatio < 0 {
+		return errors.New(`"retry-ratio" must be positive`)
+	}
+	if newConfig.UploadChunkSize == 0 {
+		newConfig.UploadChunkSize = UploadChunkSize
+	} else if newConfig.UploadChunkSize < 0 || newConfig.UploadChunkSize%(256<<10) != 0 {
+		return errors.New(`"upload-chunk-size" must be a positive multiple of 262144 (256 KiB)`)
+	}
+	if err := newConfig.checkRateLimits(); err != nil {
+		return err
+	}
+	if newConfig.Guesser == "" {
+		newConfig.Guesser = GuesserName
+	} else if err := checkGuesser(newConfig.Guesser); err != nil {
+		return err
+	}
+	if newConfig.categoryRules, err = compileRules(newConfig.CategoryRules); err != nil {
+		return err
+	}
+	if err := checkContentRules(newConfig.ContentRules); err != nil {
+		return err
+	}
+	if newConfig.torrentRules, err = compileTorrentRules(newConfig.TorrentRules); err != nil {
+		return err
+	}
+	if newConfig.TorrentDir != "" {
+		newConfig.TorrentDir = filepath.Clean(newConfig.TorrentDir)
+	}
+	if err := checkLayouts(newConfig.CategoryLayouts); err != nil {
+		return err
+	}
+	if err := checkCategoryPaths(newConfig.CategoryPaths); err != nil {
+		return err
+	}
+	if newConfig.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(newConfig.MetricsAddress); err != nil {
+			return errors.New(fmt.Sprintf(`failed to parse "metrics-address": %v`, err))
+		}
+	}
+	if newConfig.GuessCommandTimeout == "" {
+		newConfig.GuessCommandTimeout = GuessTimeout
+	}
+	if err := checkGuessCommand(newConfig.Guesser, newConfig.GuessCommand, newConfig.GuessCommandTimeout); err != nil {
+		return err
+	}
+	for _, v := range newConfig.Targets {
+		if err := checkGuessCommand(v.Guesser, newConfig.GuessCommand, newConfig.GuessCommandTimeout); err != nil {
+			return errors.New(fmt.Sprintf("target %q: %v", v.Path, err))
+		}
+	}
+	if newConfig.GuesserModel == "" {
+		newConfig.GuesserModel = filepath.Join(filepath.Dir(configPath), GuesserModelName)
+	}
+	if newConfig.GuessConfidence == 0 {
+		newConfig.GuessConfidence = GuessConfidence
+	} else if newConfig.GuessConfidence < 0 || newConfig.GuessConfidence > 1 {
+		return errors.New(`"guess-confidence" must be between 0 and 1`)
+	}
+	if newConfig.StateFile == "" {
+		newConfig.StateFile = filepath.Join(filepath.Dir(configPath), StateFileName)
+	}
+	switch newConfig.Backend {
+	case "":
+		newConfig.Backend = Backend
+	case "drive":
+	case "local":
+		if newConfig.LocalRoot == "" {
+			return errors.New(`set up to use local backend yet "local-root" not set in configuration`)
+		}
+		newConfig.LocalRoot = filepath.Clean(newConfig.LocalRoot)
+	default:
+		return errors.New(fmt.Sprintf("unknown backend %q", newConfig.Backend))
 	}
 	if _, err := time.ParseDuration(newConfig.ScanInterval); err != nil {
 		return errors.New(fmt.Sprintf("failed to parse scan-interval: %v", err))
 	}
+	if newConfig.StableTime == "" {
+		newConfig.StableTime = StableTime
+	} else if d, err := time.ParseDuration(newConfig.StableTime); err != nil {
+		return errors.New(fmt.Sprintf("failed to parse stable-time: %v", err))
+	} else if d < 0 {
+		return errors.New(`"stable-time" must not be negative`)
+	}
+	if newConfig.IncompletePatterns == nil {
+		newConfig.IncompletePatterns = DefaultIncompletePatterns
+	}
+	for _, v := range newConfig.IncompletePatterns {
+		if _, err := filepath.Match(v, ""); err != nil {
+			return errors.New(fmt.Sprintf("bad incomplete pattern %q: %v", v, err))
+		}
+	}
+	if newConfig.CheckOpenFiles {
+		if _, err := os.Stat("/proc/self/fd"); err != nil {
+			return errors.New(fmt.Sprintf(`"check-open-files" needs /proc: %v`, err))
+		}
+	}
 	if usr.Uid != "0" {
 		f, err := os.Create(filepath.Dir(newConfig.LogFile) + "/.test-drivesyncd")
 		if err != nil {
@@ -175,3 +306,8 @@ func ReloadConfig() error {
 	}
 	return nil
 }
+
+// ControlSocket returns the path of the control socket of `drivesyncd`, next to its pid file.
+func (r config) ControlSocket() string {
+	return strings.TrimSuffix(r.PidFile, filepath.Ext(r.PidFile)) + ".sock"
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type ErrorNotFound string
 
@@ -26,8 +29,30 @@ func (r ErrorSetMarkFailed) Error() string {
 	return string(r)
 }
 
+type ErrorCancelled string
+
+func (r ErrorCancelled) Error() string {
+	return string(r)
+}
+
 type ErrorMultipleResults []string
 
 func (r ErrorMultipleResults) Error() string {
 	return "multiple results: " + strings.Join(r, " ")
 }
+
+// ErrorRetryFailed is an operation that failed for good, after Attempts tries; Err is the error
+// of the last one.
+type ErrorRetryFailed struct {
+	Job      string
+	Attempts int
+	Err      error
+}
+
+func (r ErrorRetryFailed) Error() string {
+	return fmt.Sprintf("[Job #%s] retry failed after %d attempt(s): %v", r.Job, r.Attempts, r.Err)
+}
+
+func (r ErrorRetryFailed) Unwrap() error {
+	return r.Err
+}
@@ -0,0 +1,284 @@
+// Package metrics keeps the counters, gauges and histograms of DriveSync, and serves them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is something served by Handler.
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registry     []metric
+	registryLock sync.Mutex
+)
+
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry = append(registry, m)
+}
+
+// Handler returns an http.Handler serving all metrics created so far, in the order of creation.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		registryLock.Lock()
+		metrics := append([]metric(nil), registry...)
+		registryLock.Unlock()
+		var buf bytes.Buffer
+		for _, v := range metrics {
+			v.write(&buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// desc describes a metric: its name, help text, type and label names.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (r desc) header(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(r.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", r.name, help, r.name, r.kind)
+}
+
+// labelPairs formats the label names of the metric with values, followed by the extra name and
+// value pairs, e.g. `{category="Music",le="0.5"}`.
+func (r desc) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, r.labels[i], escape.Replace(v)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escape.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// key returns the key of the series with given label values, checking their number.
+func (r desc) key(values []string) string {
+	if len(values) != len(r.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label value(s), got %d", r.name, len(r.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sample is the value of a series of a counter or gauge.
+type sample struct {
+	labels []string
+	v      float64
+}
+
+// value is a counter or gauge.
+type value struct {
+	desc
+	m      sync.Mutex
+	series map[string]*sample
+}
+
+func newValue(name, help, kind string, labels []string) *value {
+	r := &value{desc: desc{name: name, help: help, kind: kind, labels: labels},
+		series: make(map[string]*sample)}
+	register(r)
+	return r
+}
+
+// get returns the sample of the series with given label values, creating it if needed. r.m must
+// be held.
+func (r *value) get(labels []string) *sample {
+	k := r.key(labels)
+	s, ok := r.series[k]
+	if !ok {
+		s = &sample{labels: append([]string(nil), labels...)}
+		r.series[k] = s
+	}
+	return s
+}
+
+func (r *value) write(w io.Writer) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.header(w)
+	keys := make([]string, 0, len(r.series))
+	for k := range r.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := r.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", r.name, r.labelPairs(s.labels), formatFloat(s.v))
+	}
+}
+
+// counter is a value that only goes up, with a series for every set of label values.
+type counter struct {
+	v *value
+}
+
+// NewCounter returns a counter with given label names. By convention, the names of counters end
+// with "_total".
+func NewCounter(name, help string, labels ...string) *counter {
+	return &counter{newValue(name, help, "counter", labels)}
+}
+
+// Add adds d, which must not be negative, to the series with given label values.
+func (r *counter) Add(d float64, labels ...string) {
+	if d < 0 {
+		panic(fmt.Sprintf("counter %s can't go down", r.v.name))
+	}
+	r.v.m.Lock()
+	defer r.v.m.Unlock()
+	r.v.get(labels).v += d
+}
+
+// Inc adds 1 to the series with given label values.
+func (r *counter) Inc(labels ...string) {
+	r.Add(1, labels...)
+}
+
+// gauge is a value that goes up and down, with a series for every set of label values.
+type gauge struct {
+	v *value
+}
+
+// NewGauge returns a gauge with given label names.
+func NewGauge(name, help string, labels ...string) *gauge {
+	return &gauge{newValue(name, help, "gauge", labels)}
+}
+
+// Set sets the series with given label values to v.
+func (r *gauge) Set(v float64, labels ...string) {
+	r.v.m.Lock()
+	defer r.v.m.Unlock()
+	r.v.get(labels).v = v
+}
+
+// Add adds d to the series with given label values.
+func (r *gauge) Add(d float64, labels ...string) {
+	r.v.m.Lock()
+	defer r.v.m.Unlock()
+	r.v.get(labels).v += d
+}
+
+// gaugeFunc is a gauge without labels whose value is taken when served.
+type gaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc adds a gauge without labels whose value is returned by f whenever it's served.
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(&gaugeFunc{desc{name: name, help: help, kind: "gauge"}, f})
+}
+
+func (r *gaugeFunc) write(w io.Writer) {
+	r.header(w)
+	fmt.Fprintf(w, "%s %s\n", r.name, formatFloat(r.f()))
+}
+
+// histogramSample is a series of a histogram.
+type histogramSample struct {
+	labels []string
+	// counts are the numbers of observations in each bucket, not cumulative
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogram counts observations in buckets, with a series for every set of label values.
+type histogram struct {
+	desc
+	// buckets are the upper bounds of the buckets, in increasing order
+	buckets []float64
+	m       sync.Mutex
+	series  map[string]*histogramSample
+}
+
+// NewHistogram returns a histogram with given bucket upper bounds, in increasing order, and
+// label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	r := &histogram{desc: desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets, series: make(map[string]*histogramSample)}
+	register(r)
+	return r
+}
+
+// ExponentialBuckets returns n bucket upper bounds, the first being start and every other one
+// factor times the one before.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	ret := make([]float64, n)
+	for i := range ret {
+		ret[i] = start
+		start *= factor
+	}
+	return ret
+}
+
+// Observe adds v to the series with given label values.
+func (r *histogram) Observe(v float64, labels ...string) {
+	k := r.key(labels)
+	r.m.Lock()
+	defer r.m.Unlock()
+	s, ok := r.series[k]
+	if !ok {
+		s = &histogramSample{labels: append([]string(nil), labels...), counts: make([]uint64, len(r.buckets))}
+		r.series[k] = s
+	}
+	if i := sort.SearchFloat64s(r.buckets, v); i < len(r.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (r *histogram) write(w io.Writer) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.header(w)
+	keys := make([]string, 0, len(r.series))
+	for k := range r.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := r.series[k]
+		var n uint64
+		for i, v := range r.buckets {
+			n += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", r.name, r.labelPairs(s.labels, "le", formatFloat(v)), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", r.name, r.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", r.name, r.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", r.name, r.labelPairs(s.labels), s.count)
+	}
+}
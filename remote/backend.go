@@ -0,0 +1,54 @@
+package remote
+
+import "io"
+
+// An Entry is an object in a folder of a Backend.
+type Entry struct {
+	ID    string
+	Name  string
+	IsDir bool
+	// Size is 0 for folders.
+	Size int64
+	// Md5Checksum is empty for folders, and if the Backend doesn't list it.
+	Md5Checksum string
+}
+
+// A Backend is a storage that objects get archived into. Objects on a Backend are referred to
+// with opaque IDs, which are only meaningful to the Backend that produced them.
+//
+// Google Drive is the default Backend; see NewDriveBackend.
+type Backend interface {
+	// RootID returns the ID of the top-level folder that holds the archive root.
+	RootID() string
+	// GetLeafFromParent resolves the ID of the requested leaf in given folder ID.
+	// It returns an E.ErrorNotFound if no such leaf exists, and an E.ErrorMultipleResults
+	// if more than one leaf matches.
+	GetLeafFromParent(leafName, parentID string, wantFolder bool) (string, error)
+	// CreateDirectory creates the directory with name leafName inside directory
+	// with ID of parentID, returning the ID of the created folder.
+	//
+	// Note: the caller shall check if the directory with leafName exists.
+	CreateDirectory(leafName, parentID string) (string, error)
+	// CreateFile creates the file with name leafName inside directory with ID of parentID,
+	// uploads the contents of the file at leafPath, and returns the ID and the md5Checksum of
+	// the created file. If C.Config.ForceRecheck is true for leafPath, it returns an
+	// E.ErrorChecksumMismatch if the MD5 sums of remote and local don't match.
+	//
+	// Note: the caller shall check if the file with leafName exists.
+	CreateFile(leafPath, leafName, parentID string) (string, string, error)
+	// GetChecksum returns the md5Checksum of the file with given ID.
+	GetChecksum(fileID string) (string, error)
+	// Download returns the contents of the file with given ID from byte offset on. Backends
+	// may ignore offset; start is the offset the returned contents actually start at.
+	Download(fileID string, offset int64) (body io.ReadCloser, start int64, err error)
+	// Delete removes the object with given ID.
+	Delete(fileID string) error
+	// ListChildren returns the objects in the folder with given ID.
+	ListChildren(parentID string) ([]Entry, error)
+}
+
+// A PropertySetter is a Backend that can attach key-value properties to the objects on it.
+type PropertySetter interface {
+	// SetProperties adds props to the properties of the object with given ID.
+	SetProperties(id string, props map[string]string) error
+}
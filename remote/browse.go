@@ -0,0 +1,84 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+)
+
+// ResolvePath resolves the object at p under the archive root, e.g. "Music/My Great Record",
+// without creating anything; an empty p resolves the archive root itself. It returns an
+// E.ErrorNotFound if there's no such object.
+func ResolvePath(b Backend, p string) (Entry, error) {
+	return resolvePathIn(b, C.Config.Get().ArchiveRootName, p)
+}
+
+// resolvePathIn resolves the object at p under the archive root named rootName; see ResolvePath.
+func resolvePathIn(b Backend, rootName, p string) (Entry, error) {
+	rootID, ok := C.ArchiveRootIDs.Get(rootName)
+	if !ok {
+		id, err := b.GetLeafFromParent(rootName, b.RootID(), true)
+		if err != nil {
+			return Entry{}, err
+		}
+		rootID = id
+		C.ArchiveRootIDs.Set(rootName, rootID)
+	}
+	ret := Entry{ID: rootID, Name: rootName, IsDir: true}
+	var segments []string
+	for _, v := range strings.Split(p, "/") {
+		if v != "" {
+			segments = append(segments, v)
+		}
+	}
+	for i, v := range segments {
+		id, err := b.GetLeafFromParent(v, ret.ID, true)
+		if _, ok := err.(E.ErrorNotFound); ok && i == len(segments)-1 {
+			// the last one may be a file
+			id, err = b.GetLeafFromParent(v, ret.ID, false)
+			if err == nil {
+				return fileEntry(b, ret.ID, id)
+			}
+		}
+		if err != nil {
+			return Entry{}, err
+		}
+		ret = Entry{ID: id, Name: v, IsDir: true}
+	}
+	return ret, nil
+}
+
+// List returns the objects in the folder at p under the archive root, sorted by name, or the
+// file at p alone; see ResolvePath.
+func List(b Backend, p string) ([]Entry, error) {
+	e, err := ResolvePath(b, p)
+	if err != nil {
+		return nil, err
+	} else if !e.IsDir {
+		return []Entry{e}, nil
+	}
+	entries, err := b.ListChildren(e.ID)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to list '%s': %v", p, err))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// fileEntry returns the Entry of the file with given ID in the folder parentID, to get its size.
+func fileEntry(b Backend, parentID, id string) (Entry, error) {
+	entries, err := b.ListChildren(parentID)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, v := range entries {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return Entry{}, E.ErrorNotFound(fmt.Sprintf("error: no '%s' in '%s'", id, parentID))
+}
@@ -0,0 +1,216 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// uploadLimiter limits the bandwidth of all uploads to Google Drive, following
+// C.Config.UploadRateLimit and C.Config.UploadSchedule.
+var uploadLimiter = U.NewLimiter(func() int64 {
+	return C.Config.Get().UploadRateAt(time.Now())
+})
+
+// driveBackend is the Backend that stores objects on Google Drive.
+type driveBackend struct {
+	srv *drive.Service
+	// client is the *http.Client srv is built on, used for resumable uploads
+	client *http.Client
+}
+
+// NewDriveBackend wraps a *drive.Service obtained from A.Authenticate into a Backend.
+// Files larger than C.Config.UploadChunkSize are uploaded in a resumable manner with client,
+// which shall be the *http.Client srv is built on; if client is nil, every file is uploaded
+// in one go.
+func NewDriveBackend(srv *drive.Service, client *http.Client) *driveBackend {
+	return &driveBackend{srv: srv, client: client}
+}
+
+func (r *driveBackend) RootID() string {
+	return "root"
+}
+
+// queryEscaper escapes strings to be quoted in the queries of files.list.
+var queryEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func (r *driveBackend) GetLeafFromParent(leafName, parentID string, wantFolder bool) (string, error) {
+	var q []string
+	q = append(q, fmt.Sprintf("('%s' in parents)", queryEscaper.Replace(parentID)))
+	q = append(q, fmt.Sprintf("name='%s'", queryEscaper.Replace(leafName)))
+	if wantFolder {
+		q = append(q, fmt.Sprintf("mimeType='%s'", C.DriveFolderType))
+	} else {
+		q = append(q, fmt.Sprintf("mimeType!='%s'", C.DriveFolderType))
+	}
+	q = append(q, "trashed=false")
+	ansList, err := r.srv.Files.List().Q(strings.Join(q, "and")).Fields("files(id)").Do()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to fetch ID of '%s': %v", leafName, err))
+	} else if len(ansList.Files) == 0 {
+		return "", E.ErrorNotFound(fmt.Sprintf("error: no '%s' in '%s'", leafName, parentID))
+	} else if len(ansList.Files) > 1 {
+		// return an E.ErrorMultipleResults
+		var ret []string
+		for _, f := range ansList.Files {
+			ret = append(ret, f.Id)
+		}
+		return "", E.ErrorMultipleResults(ret)
+	}
+	return ansList.Files[0].Id, nil
+}
+
+func (r *driveBackend) CreateDirectory(leafName, parentID string) (string, error) {
+	createInfo := &drive.File{
+		Name:        leafName,
+		Description: leafName,
+		MimeType:    C.DriveFolderType,
+		Parents:     []string{parentID},
+	}
+	info, err := r.srv.Files.Create(createInfo).Fields("id").Do()
+	if err != nil {
+		//return "", errors.New(fmt.Sprintf("failed to create on Drive server: %v", err))
+		return "", err
+	} else {
+		return info.Id, nil
+	}
+}
+
+func (r *driveBackend) CreateFile(leafPath, leafName, parentID string) (string, string, error) {
+	conf := C.Config.Get().ForPath(leafPath)
+	if r.client != nil && conf.UploadChunkSize > 0 {
+		if fi, err := os.Stat(leafPath); err == nil && fi.Size() > conf.UploadChunkSize {
+			info, err := r.createFileResumable(leafPath, leafName, parentID)
+			if err != nil {
+				return "", "", err
+			}
+			if conf.ForceRecheck {
+				if err := checkUploaded(info, leafPath); err != nil {
+					return "", "", err
+				}
+			}
+			return info.Id, info.Md5Checksum, nil
+		}
+	}
+	uploadFile, err := os.Open(leafPath)
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("failed to open file '%s': %v", leafPath, err))
+	}
+	defer uploadFile.Close()
+	createInfo := &drive.File{
+		Name:        leafName,
+		Description: leafName,
+		MimeType:    mime.TypeByExtension(filepath.Ext(leafName)),
+		Parents:     []string{parentID},
+	}
+	// the md5Checksum of the stored copy is recorded in the sync state
+	media := uploadLimiter.Reader(transfers.reader(leafPath, uploadFile, 0))
+	intermediateCall := r.srv.Files.Create(createInfo).Media(media).Fields("id, md5Checksum")
+	retVal, retErr := make(chan *drive.File, 1), make(chan error, 1)
+	go func() {
+		info, err := intermediateCall.Do()
+		retErr <- err
+		retVal <- info
+	}()
+	var info *drive.File
+	if conf.ForceRecheck {
+		f, err := os.Open(leafPath)
+		if err != nil {
+			return "", "", errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
+		}
+		defer f.Close()
+
+		// calculate the MD5 hash of the file
+		realSum, err := U.CalculateSum(f)
+		if err != nil {
+			return "", "", errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+		}
+
+		err = <-retErr
+		if err != nil {
+			return "", "", err
+		}
+		info = <-retVal
+		if sum := info.Md5Checksum; sum != realSum {
+			return "", "", checksumMismatch(fmt.Sprintf(
+				"md5Checksum mismatch: remote %s, local %s", sum, realSum))
+		}
+		//log.Printf("file '%s' has identical remote/local md5Checksum", leafPath)
+	} else {
+		err := <-retErr
+		if err != nil {
+			return "", "", err
+		}
+		info = <-retVal
+	}
+	return info.Id, info.Md5Checksum, nil
+}
+
+func (r *driveBackend) GetChecksum(fileID string) (string, error) {
+	file, err := r.srv.Files.Get(fileID).Fields("md5Checksum").Do()
+	if err != nil {
+		return "", err
+	}
+	return file.Md5Checksum, nil
+}
+
+func (r *driveBackend) Download(fileID string, offset int64) (io.ReadCloser, int64, error) {
+	call := r.srv.Files.Get(fileID)
+	if offset > 0 {
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := call.Download()
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// the Range header was ignored; the whole file is returned
+		offset = 0
+	}
+	return resp.Body, offset, nil
+}
+
+func (r *driveBackend) Delete(fileID string) error {
+	return r.srv.Files.Delete(fileID).Do()
+}
+
+func (r *driveBackend) SetProperties(id string, props map[string]string) error {
+	_, err := r.srv.Files.Update(id, &drive.File{Properties: props}).Fields("id").Do()
+	return err
+}
+
+func (r *driveBackend) ListChildren(parentID string) ([]Entry, error) {
+	var ret []Entry
+	call := r.srv.Files.List().Q(fmt.Sprintf("('%s' in parents)and trashed=false", queryEscaper.Replace(parentID))).
+		Fields("nextPageToken, files(id, name, mimeType, size, md5Checksum)").PageSize(1000)
+	err := call.Pages(context.Background(), func(list *drive.FileList) error {
+		for _, f := range list.Files {
+			ret = append(ret, Entry{
+				ID:          f.Id,
+				Name:        f.Name,
+				IsDir:       f.MimeType == C.DriveFolderType,
+				Size:        f.Size,
+				Md5Checksum: f.Md5Checksum,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		// as is, for retryIfNeeded
+		return nil, err
+	}
+	return ret, nil
+}
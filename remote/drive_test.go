@@ -0,0 +1,159 @@
+package remote
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+
+	E "github.com/KireinaHoro/DriveSync/errors"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+)
+
+func TestSyncDirectory(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{
+		"Rel/a.flac":     "aaa",
+		"Rel/CD1/b.flac": "bbb",
+	})
+	path := filepath.Join(src, "Rel")
+	if err := SyncDirectory(nil, b, path, "Music"); err != nil {
+		t.Fatalf("SyncDirectory: %v", err)
+	}
+	for _, tc := range []struct {
+		path []string
+		want string
+	}{
+		{[]string{"archive", "Music", "Rel", "a.flac"}, "aaa"},
+		{[]string{"archive", "Music", "Rel", "CD1", "b.flac"}, "bbb"},
+	} {
+		id, ok := s.Resolve(tc.path...)
+		if !ok {
+			t.Fatalf("%v not uploaded", tc.path)
+		}
+		if got, _ := s.Content(id); string(got) != tc.want {
+			t.Errorf("content of %v = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+	if _, ok := SyncDirectory(nil, b, path, "Music").(E.ErrorAlreadySynced); !ok {
+		t.Error("second sync not reported as already synced")
+	}
+}
+
+func TestCreateFileWithCheckReplaces(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "new"})
+	dir := s.Mkdir("d", drivetest.RootID)
+	oldID := s.Put("f.bin", dir, []byte("old"))
+	id, sum, err := createFileWithCheck(b, filepath.Join(src, "f.bin"), "f.bin", dir)
+	if err != nil {
+		t.Fatalf("createFileWithCheck: %v", err)
+	}
+	if id == oldID {
+		t.Error("existing file with other content kept")
+	}
+	// MD5 of "new"
+	if sum != "22af645d1859cb5ca6da0c484f1f37ea" {
+		t.Errorf("md5Checksum = %s, want the one of the upload", sum)
+	}
+	files := s.Find("f.bin", dir)
+	if len(files) != 1 {
+		t.Fatalf("%d copies of f.bin, want 1", len(files))
+	}
+	if got, _ := s.Content(files[0].Id); string(got) != "new" {
+		t.Errorf("content = %q, want %q", got, "new")
+	}
+}
+
+func TestCreateFileWithCheckSkipsIdentical(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "same"})
+	dir := s.Mkdir("d", drivetest.RootID)
+	oldID := s.Put("f.bin", dir, []byte("same"))
+	id, sum, err := createFileWithCheck(b, filepath.Join(src, "f.bin"), "f.bin", dir)
+	if err != nil {
+		t.Fatalf("createFileWithCheck: %v", err)
+	}
+	if id != oldID {
+		t.Errorf("ID = %s, want existing %s", id, oldID)
+	}
+	// MD5 of "same"
+	if sum != "51037a4a37730f52c8732586d3aaa316" {
+		t.Errorf("md5Checksum = %s, want the one of the existing file", sum)
+	}
+	if n := s.Requests(drivetest.OpUpload); n != 0 {
+		t.Errorf("%d uploads, want none", n)
+	}
+}
+
+func TestCreateFileWithCheckRemovesDuplicates(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "new"})
+	dir := s.Mkdir("d", drivetest.RootID)
+	s.Put("f.bin", dir, []byte("old1"))
+	s.Put("f.bin", dir, []byte("old2"))
+	if _, err := b.GetLeafFromParent("f.bin", dir, false); err == nil {
+		t.Fatal("duplicates not reported")
+	} else if _, ok := err.(E.ErrorMultipleResults); !ok {
+		t.Fatalf("duplicates reported as %T: %v", err, err)
+	}
+	if _, _, err := createFileWithCheck(b, filepath.Join(src, "f.bin"), "f.bin", dir); err != nil {
+		t.Fatalf("createFileWithCheck: %v", err)
+	}
+	files := s.Find("f.bin", dir)
+	if len(files) != 1 {
+		t.Fatalf("%d copies of f.bin, want 1", len(files))
+	}
+	if got, _ := s.Content(files[0].Id); string(got) != "new" {
+		t.Errorf("content = %q, want %q", got, "new")
+	}
+}
+
+func TestRetryIfNeeded(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"rate limit", &googleapi.Error{Code: 403, Message: "User Rate Limit Exceeded"}, "rate-limit"},
+		{"forbidden", &googleapi.Error{Code: 403, Message: "The user does not have permission"}, ""},
+		{"not found", &googleapi.Error{Code: 404, Message: "File not found"}, ""},
+		{"internal", &googleapi.Error{Code: 500, Message: "Internal Error"}, "server-error"},
+		{"unavailable", &googleapi.Error{Code: 503, Message: "Service Unavailable"}, "server-error"},
+		{"network", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, "network"},
+		{"checksum", E.ErrorChecksumMismatch("md5Checksum mismatch"), "checksum-mismatch"},
+		{"other", errors.New("failed to open file"), ""},
+	} {
+		if got := retryReason(tc.err); got != tc.want {
+			t.Errorf("%s: retryReason = %q, want %q", tc.name, got, tc.want)
+		}
+		if got := retryIfNeeded(tc.err); got != (tc.want != "") {
+			t.Errorf("%s: retryIfNeeded = %v", tc.name, got)
+		}
+	}
+}
+
+func TestGetLeafFromParentQuotes(t *testing.T) {
+	s, b := newTestDrive(t)
+	root := s.Mkdir("archive", drivetest.RootID)
+	dir := s.Mkdir(`Rock 'n' Roll`, root)
+	name := `it's a \ test.flac`
+	id := s.Put(name, dir, []byte("x"))
+	if got, err := b.GetLeafFromParent(name, dir, false); err != nil || got != id {
+		t.Errorf("GetLeafFromParent = %q, %v; want %q", got, err, id)
+	}
+	e, err := ResolvePath(b, `Rock 'n' Roll/`+name)
+	if err != nil {
+		t.Fatalf("ResolvePath: %v", err)
+	}
+	if e.ID != id {
+		t.Errorf("resolved to %s, want %s", e.ID, id)
+	}
+}
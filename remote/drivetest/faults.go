@@ -0,0 +1,188 @@
+package drivetest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Operations a Fault can be restricted to.
+const (
+	OpList   = "list"   // files.list
+	OpCreate = "create" // files.create without media, e.g. of a folder
+	OpUpload = "upload" // files.create with media, including every chunk of a resumable upload
+	OpGet    = "get"    // files.get, both metadata and alt=media
+	OpDelete = "delete" // files.delete
+	OpUpdate = "update" // files.update without media
+)
+
+// A Fault describes a misbehaviour of the Server, to exercise the error and retry paths of
+// its clients. A Fault applies to the Nth request matching Op, and to the Times-1 matching
+// requests after it.
+type Fault struct {
+	// Op restricts the Fault to one of the Op* operations; empty matches every request.
+	Op string
+	// Nth is the 1-based index, among matching requests, of the first request to fault;
+	// 0 is treated as 1.
+	Nth int
+	// Times is the number of consecutive matching requests to fault; 0 is treated as 1,
+	// and a negative value faults every matching request from the Nth on.
+	Times int
+
+	// Latency delays the handling of the request.
+	Latency time.Duration
+	// Drop makes the server read part of the request body, then close the connection
+	// without replying. Note that net/http may transparently resend an idempotent request
+	// that got dropped on a reused connection.
+	Drop bool
+	// Status, if nonzero, makes the request fail with the HTTP status code and Message.
+	Status  int
+	Message string
+	// BadChecksum makes files.create and files.get reply with a wrong md5Checksum, while the
+	// content gets stored as usual.
+	BadChecksum bool
+}
+
+// fault is a Fault installed on a Server.
+type fault struct {
+	Fault
+	seen int
+}
+
+// matches counts a request of operation op against f, reporting whether it shall fault.
+func (f *fault) matches(op string) bool {
+	if f.Op != "" && f.Op != op {
+		return false
+	}
+	f.seen++
+	nth, times := f.Nth, f.Times
+	if nth <= 0 {
+		nth = 1
+	}
+	if times == 0 {
+		times = 1
+	}
+	return f.seen >= nth && (times < 0 || f.seen < nth+times)
+}
+
+type contextKey string
+
+const badChecksumKey contextKey = "bad_checksum"
+
+// Inject installs a Fault on the server. Faults stay installed until ClearFaults is called;
+// when several match a request, their effects are combined.
+func (s *Server) Inject(f Fault) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f})
+}
+
+// ClearFaults removes all installed Faults.
+func (s *Server) ClearFaults() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the number of requests of given operation the server has received,
+// including the faulted ones. An empty op counts all requests.
+func (s *Server) Requests(op string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if op == "" {
+		var n int
+		for _, v := range s.requests {
+			n += v
+		}
+		return n
+	}
+	return s.requests[op]
+}
+
+// opOf classifies a request into one of the Op* operations.
+func opOf(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		return OpUpload
+	case r.Method == "GET" && r.URL.Path == "/drive/v3/files":
+		return OpList
+	case r.Method == "POST":
+		return OpCreate
+	case r.Method == "DELETE":
+		return OpDelete
+	case r.Method == "PATCH":
+		return OpUpdate
+	default:
+		return OpGet
+	}
+}
+
+// applyFaults counts the request and applies the matching Faults. It returns false if the
+// request has been dealt with and shall not be processed further; otherwise the returned
+// request is to be processed in place of r.
+func (s *Server) applyFaults(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	op := opOf(r)
+	var combined Fault
+	s.m.Lock()
+	s.requests[op]++
+	for _, f := range s.faults {
+		if f.matches(op) {
+			combined.Latency += f.Latency
+			combined.Drop = combined.Drop || f.Drop
+			combined.BadChecksum = combined.BadChecksum || f.BadChecksum
+			if f.Status != 0 {
+				combined.Status, combined.Message = f.Status, f.Message
+			}
+		}
+	}
+	s.m.Unlock()
+
+	if combined.Latency > 0 {
+		time.Sleep(combined.Latency)
+	}
+	if combined.Drop {
+		dropConnection(w, r)
+		return nil, false
+	}
+	if combined.Status != 0 {
+		message := combined.Message
+		if message == "" {
+			message = http.StatusText(combined.Status)
+		}
+		writeError(w, combined.Status, "injectedFault", message)
+		return nil, false
+	}
+	if combined.BadChecksum {
+		r = r.WithContext(context.WithValue(r.Context(), badChecksumKey, true))
+	}
+	return r, true
+}
+
+// dropConnection reads half of the request body, as if the connection broke mid-upload,
+// and closes the underlying connection.
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > 0 {
+		io.CopyN(ioutil.Discard, r.Body, r.ContentLength/2)
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic("drivetest: connection can't be hijacked")
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic("drivetest: failed to hijack connection: " + err.Error())
+	}
+	conn.Close()
+}
+
+// corruptChecksum replaces the md5Checksum of a reply if the request is to be faulted so.
+func corruptChecksum(r *http.Request, sum string) string {
+	if r.Context().Value(badChecksumKey) == nil || sum == "" {
+		return sum
+	}
+	// a well-formed yet wrong sum
+	return strings.Repeat("0", len(sum)-1) + "1"
+}
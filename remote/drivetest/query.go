@@ -0,0 +1,253 @@
+package drivetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// predicate reports whether a file matches (part of) a search query.
+type predicate func(f *drive.File) bool
+
+// queryParser is a recursive-descent parser for the subset of the Drive v3 search query
+// language used by DriveSync:
+//
+//	'<id>' in parents
+//	name = '<name>'      name != '<name>'      name contains '<part>'
+//	mimeType = '<type>'  mimeType != '<type>'
+//	trashed = true|false trashed != true|false
+//
+// combined with `and`, `or`, `not` and parentheses. Connectives are recognized even without
+// surrounding spaces, as getLeafFromParent joins the terms with a bare "and".
+type queryParser struct {
+	s   string
+	pos int
+}
+
+// parseQuery compiles a search query into a predicate. An empty query matches everything.
+func parseQuery(q string) (predicate, error) {
+	p := &queryParser{s: q}
+	p.skipSpace()
+	if p.eof() {
+		return func(*drive.File) bool { return true }, nil
+	}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected trailing input")
+	}
+	return pred, nil
+}
+
+func (p *queryParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) errorf(format string, v ...interface{}) error {
+	return errors.New(fmt.Sprintf("invalid query %q at offset %d: %s", p.s, p.pos,
+		fmt.Sprintf(format, v...)))
+}
+
+// acceptKeyword consumes kw (case-insensitively) if the input continues with it.
+func (p *queryParser) acceptKeyword(kw string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(strings.ToLower(p.s[p.pos:]), kw) {
+		p.pos += len(kw)
+		return true
+	}
+	return false
+}
+
+// acceptWord consumes kw only if it is not the prefix of a longer identifier, so that e.g.
+// "notes" is not mistaken for "not".
+func (p *queryParser) acceptWord(kw string) bool {
+	start := p.pos
+	if !p.acceptKeyword(kw) {
+		return false
+	}
+	if !p.eof() && isIdentRune(rune(p.s[p.pos])) {
+		p.pos = start
+		return false
+	}
+	return true
+}
+
+func (p *queryParser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *drive.File) bool { return l(f) || right(f) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(f *drive.File) bool { return l(f) && right(f) }
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (predicate, error) {
+	if p.acceptWord("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *drive.File) bool { return !inner(f) }, nil
+	}
+	if p.acceptKeyword("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return inner, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *queryParser) parseTerm() (predicate, error) {
+	p.skipSpace()
+	if !p.eof() && p.s[p.pos] == '\'' {
+		// '<value>' in <collection>
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptWord("in") {
+			return nil, p.errorf("expected 'in'")
+		}
+		field := p.parseIdent()
+		if field != "parents" {
+			return nil, p.errorf("unsupported collection %q", field)
+		}
+		return func(f *drive.File) bool {
+			for _, v := range f.Parents {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	field := p.parseIdent()
+	if field == "" {
+		return nil, p.errorf("expected field name")
+	}
+	var op string
+	switch {
+	case p.acceptKeyword("!="):
+		op = "!="
+	case p.acceptKeyword("="):
+		op = "="
+	case p.acceptWord("contains"):
+		op = "contains"
+	default:
+		return nil, p.errorf("expected operator")
+	}
+	switch field {
+	case "name", "mimeType":
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		get := func(f *drive.File) string { return f.Name }
+		if field == "mimeType" {
+			get = func(f *drive.File) string { return f.MimeType }
+		}
+		switch op {
+		case "=":
+			return func(f *drive.File) bool { return get(f) == value }, nil
+		case "!=":
+			return func(f *drive.File) bool { return get(f) != value }, nil
+		default:
+			return func(f *drive.File) bool { return strings.Contains(get(f), value) }, nil
+		}
+	case "trashed":
+		var value bool
+		if p.acceptKeyword("true") {
+			value = true
+		} else if !p.acceptKeyword("false") {
+			return nil, p.errorf("expected boolean")
+		}
+		switch op {
+		case "=":
+			return func(f *drive.File) bool { return f.Trashed == value }, nil
+		case "!=":
+			return func(f *drive.File) bool { return f.Trashed != value }, nil
+		}
+	}
+	return nil, p.errorf("unsupported term %s %s", field, op)
+}
+
+// parseIdent consumes an identifier.
+func (p *queryParser) parseIdent() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() && isIdentRune(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// parseString consumes a single-quoted string literal, handling backslash escapes.
+func (p *queryParser) parseString() (string, error) {
+	p.skipSpace()
+	if p.eof() || p.s[p.pos] != '\'' {
+		return "", p.errorf("expected string literal")
+	}
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated escape")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case '\'':
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string literal")
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,593 @@
+// Package drivetest provides an in-process fake of the Google Drive v3 REST API, so that code
+// working on a *drive.Service can be exercised without a live Drive account.
+//
+// Only the endpoints DriveSync uses are served: files.list (with the subset of the search
+// query language described in query.go), files.create (metadata only, multipart and resumable
+// media uploads), files.get (metadata and alt=media), files.update (name, description and
+// properties) and files.delete. The root folder of the
+// fake Drive has the ID "root". Faults can be injected into the server with Server.Inject.
+package drivetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+)
+
+// RootID is the ID of the root folder of the fake Drive.
+const RootID = "root"
+
+// object is a file or folder stored on the fake Drive.
+type object struct {
+	file    drive.File
+	content []byte
+}
+
+// upload is a resumable upload session.
+type upload struct {
+	file    drive.File
+	content []byte
+	// id is the ID of the created file once the upload is complete
+	id string
+}
+
+// Server is a fake Google Drive server listening on a local address.
+type Server struct {
+	// URL is the base URL of the server, of the form http://ipaddr:port with no trailing slash.
+	URL string
+
+	ts      *httptest.Server
+	m       sync.Mutex
+	objects map[string]*object
+	uploads map[string]*upload
+	nextID  int
+
+	faults   []*fault
+	requests map[string]int
+}
+
+// NewServer starts and returns a new Server holding an empty Drive.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		objects:  make(map[string]*object),
+		uploads:  make(map[string]*upload),
+		requests: make(map[string]int),
+	}
+	s.objects[RootID] = &object{file: drive.File{
+		Id:       RootID,
+		Name:     "My Drive",
+		MimeType: C.DriveFolderType,
+	}}
+	s.ts = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.ts.Close()
+}
+
+// Client returns the *http.Client the *drive.Service returned by Service is built on.
+func (s *Server) Client() *http.Client {
+	return s.ts.Client()
+}
+
+// Service returns a *drive.Service talking to the server.
+func (s *Server) Service() (*drive.Service, error) {
+	return drive.NewService(context.Background(),
+		option.WithEndpoint(s.URL+"/drive/v3/"),
+		option.WithHTTPClient(s.ts.Client()))
+}
+
+// Mkdir creates a folder named name in parentID directly, returning its ID. It's meant for
+// seeding the fake Drive before a test.
+func (s *Server) Mkdir(name, parentID string) string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.insert(drive.File{
+		Name:     name,
+		MimeType: C.DriveFolderType,
+		Parents:  []string{parentID},
+	}, nil)
+}
+
+// Put creates a file named name with given content in parentID directly, returning its ID.
+// It's meant for seeding the fake Drive before a test.
+func (s *Server) Put(name, parentID string, content []byte) string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.insert(drive.File{
+		Name:    name,
+		Parents: []string{parentID},
+	}, content)
+}
+
+// Children returns the untrashed objects in folder parentID, sorted by name.
+func (s *Server) Children(parentID string) []*drive.File {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var ret []*drive.File
+	for _, o := range s.objects {
+		for _, p := range o.file.Parents {
+			if p == parentID && !o.file.Trashed {
+				f := o.file
+				ret = append(ret, &f)
+				break
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name == ret[j].Name {
+			return ret[i].Id < ret[j].Id
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// Find returns all untrashed objects named name in folder parentID. More than one result
+// means the fake Drive holds duplicates.
+func (s *Server) Find(name, parentID string) []*drive.File {
+	var ret []*drive.File
+	for _, f := range s.Children(parentID) {
+		if f.Name == name {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// Resolve walks the folder names in path from the root, returning the ID of the last element.
+// It returns false if any element is missing or ambiguous.
+func (s *Server) Resolve(path ...string) (string, bool) {
+	id := RootID
+	for _, name := range path {
+		found := s.Find(name, id)
+		if len(found) != 1 {
+			return "", false
+		}
+		id = found[0].Id
+	}
+	return id, true
+}
+
+// Content returns the content of the file with given ID.
+func (s *Server) Content(id string) ([]byte, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	o, ok := s.objects[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.content...), true
+}
+
+// insert stores a new object, filling in the server-side fields. s.m must be held.
+func (s *Server) insert(file drive.File, content []byte) string {
+	s.nextID++
+	file.Id = fmt.Sprintf("fake-%06d", s.nextID)
+	if len(file.Parents) == 0 {
+		file.Parents = []string{RootID}
+	}
+	if file.MimeType != C.DriveFolderType {
+		sum := md5.Sum(content)
+		file.Md5Checksum = hex.EncodeToString(sum[:])
+		file.Size = int64(len(content))
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	file.CreatedTime, file.ModifiedTime = now, now
+	s.objects[file.Id] = &object{file: file, content: content}
+	return file.Id
+}
+
+// remove deletes the object with given ID along with its descendants. s.m must be held.
+func (s *Server) remove(id string) {
+	delete(s.objects, id)
+	for childID, o := range s.objects {
+		for _, p := range o.file.Parents {
+			if p == id {
+				s.remove(childID)
+				break
+			}
+		}
+	}
+}
+
+// checkParents returns an error message if any of the parents is not an existing folder.
+// s.m must be held.
+func (s *Server) checkParents(parents []string) string {
+	for _, p := range parents {
+		if o, ok := s.objects[p]; !ok || o.file.MimeType != C.DriveFolderType {
+			return fmt.Sprintf("File not found: %s.", p)
+		}
+	}
+	return ""
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r, ok := s.applyFaults(w, r)
+	if !ok {
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/drive/v3/files":
+		switch r.Method {
+		case "GET":
+			s.handleList(w, r)
+		case "POST":
+			s.handleCreate(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "Method not allowed.")
+		}
+	case strings.HasPrefix(path, "/drive/v3/files/"):
+		id := strings.TrimPrefix(path, "/drive/v3/files/")
+		switch r.Method {
+		case "GET":
+			s.handleGet(w, r, id)
+		case "PATCH":
+			s.handleUpdate(w, r, id)
+		case "DELETE":
+			s.handleDelete(w, r, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "Method not allowed.")
+		}
+	case path == "/upload/drive/v3/files" && (r.Method == "POST" || r.Method == "PUT"):
+		switch r.URL.Query().Get("uploadType") {
+		case "multipart":
+			s.handleMultipartUpload(w, r)
+		case "resumable":
+			if id := r.URL.Query().Get("upload_id"); id != "" {
+				s.handleUploadChunk(w, r, id)
+			} else {
+				s.handleResumableStart(w, r)
+			}
+		case "media":
+			s.handleMediaUpload(w, r)
+		default:
+			writeError(w, http.StatusBadRequest, "invalid", "Invalid uploadType.")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "Not Found")
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	pred, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	s.m.Lock()
+	list := &drive.FileList{Kind: "drive#fileList", Files: []*drive.File{}}
+	for _, o := range s.objects {
+		if o.file.Id != RootID && pred(&o.file) {
+			f := o.file
+			list.Files = append(list.Files, &f)
+		}
+	}
+	s.m.Unlock()
+	sort.Slice(list.Files, func(i, j int) bool { return list.Files[i].Id < list.Files[j].Id })
+	// the page token is the index of the first file of the page
+	var start int
+	if t := r.URL.Query().Get("pageToken"); t != "" {
+		if start, err = strconv.Atoi(t); err != nil || start < 0 || start > len(list.Files) {
+			writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid page token: %s.", t))
+			return
+		}
+	}
+	end := len(list.Files)
+	if n, _ := strconv.Atoi(r.URL.Query().Get("pageSize")); n > 0 && start+n < end {
+		end = start + n
+		list.NextPageToken = strconv.Itoa(end)
+	}
+	list.Files = list.Files[start:end]
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var file drive.File
+	if err := json.NewDecoder(r.Body).Decode(&file); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return
+	}
+	s.finishCreate(w, r, file, nil)
+}
+
+func (s *Server) handleMediaUpload(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	s.finishCreate(w, r, drive.File{Name: "Untitled", MimeType: r.Header.Get("Content-Type")}, content)
+}
+
+func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		writeError(w, http.StatusBadRequest, "badContent", "Missing multipart boundary.")
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	var file drive.File
+	if err := json.NewDecoder(part).Decode(&file); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	content, err := ioutil.ReadAll(part)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	s.finishCreate(w, r, file, content)
+}
+
+func (s *Server) handleResumableStart(w http.ResponseWriter, r *http.Request) {
+	var file drive.File
+	if err := json.NewDecoder(r.Body).Decode(&file); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return
+	}
+	s.m.Lock()
+	if msg := s.checkParents(file.Parents); msg != "" {
+		s.m.Unlock()
+		writeError(w, http.StatusNotFound, "notFound", msg)
+		return
+	}
+	s.nextID++
+	id := fmt.Sprintf("upload-%06d", s.nextID)
+	s.uploads[id] = &upload{file: file}
+	s.m.Unlock()
+	w.Header().Set("Location", s.URL+"/upload/drive/v3/files?uploadType=resumable&upload_id="+id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleUploadChunk accepts one chunk of a resumable upload, described by a Content-Range
+// header of the form "bytes first-last/total", "bytes first-last/*" or "bytes */total".
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	first, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badContent", err.Error())
+		return
+	}
+	s.m.Lock()
+	u, ok := s.uploads[id]
+	if !ok {
+		s.m.Unlock()
+		writeError(w, http.StatusNotFound, "notFound", "No such upload session.")
+		return
+	}
+	if u.id != "" {
+		// the upload is complete already; reply with the file as Drive does
+		o, ok := s.objects[u.id]
+		var file drive.File
+		if ok {
+			file = o.file
+		}
+		s.m.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("File not found: %s.", u.id))
+			return
+		}
+		file.Md5Checksum = corruptChecksum(r, file.Md5Checksum)
+		writeJSON(w, http.StatusOK, &file)
+		return
+	}
+	if first >= 0 {
+		if first > int64(len(u.content)) {
+			s.m.Unlock()
+			writeError(w, http.StatusBadRequest, "badContent", "Chunk does not continue the upload.")
+			return
+		}
+		// overlapping chunks are allowed when the client re-sends data
+		u.content = append(u.content[:first], data...)
+	}
+	received := int64(len(u.content))
+	if total < 0 || received < total {
+		s.m.Unlock()
+		writeIncomplete(w, r, received)
+		return
+	}
+	file, content := u.file, u.content
+	u.content = nil
+	s.m.Unlock()
+	fileID := s.finishCreate(w, r, file, content)
+	s.m.Lock()
+	u.id = fileID
+	s.m.Unlock()
+}
+
+// finishCreate validates and stores a new object, writing it to w. It returns the ID of the
+// object, or an empty string if it could not be created.
+func (s *Server) finishCreate(w http.ResponseWriter, r *http.Request, file drive.File, content []byte) string {
+	if file.Name == "" {
+		file.Name = "Untitled"
+	}
+	s.m.Lock()
+	if msg := s.checkParents(file.Parents); msg != "" {
+		s.m.Unlock()
+		writeError(w, http.StatusNotFound, "notFound", msg)
+		return ""
+	}
+	id := s.insert(file, content)
+	ret := s.objects[id].file
+	s.m.Unlock()
+	ret.Md5Checksum = corruptChecksum(r, ret.Md5Checksum)
+	writeJSON(w, http.StatusOK, &ret)
+	return id
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, id string) {
+	s.m.Lock()
+	o, ok := s.objects[id]
+	var file drive.File
+	var content []byte
+	if ok {
+		file, content = o.file, o.content
+	}
+	s.m.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("File not found: %s.", id))
+		return
+	}
+	if r.URL.Query().Get("alt") == "media" {
+		if file.MimeType == C.DriveFolderType {
+			writeError(w, http.StatusForbidden, "fileNotDownloadable",
+				"Only files with binary content can be downloaded.")
+			return
+		}
+		http.ServeContent(w, r, file.Name, time.Time{}, bytes.NewReader(content))
+		return
+	}
+	file.Md5Checksum = corruptChecksum(r, file.Md5Checksum)
+	writeJSON(w, http.StatusOK, &file)
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request, id string) {
+	var patch drive.File
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error())
+		return
+	}
+	s.m.Lock()
+	o, ok := s.objects[id]
+	var file drive.File
+	if ok {
+		if patch.Name != "" {
+			o.file.Name = patch.Name
+		}
+		if patch.Description != "" {
+			o.file.Description = patch.Description
+		}
+		if len(patch.Properties) > 0 {
+			// copy, as the map may have been handed out with copies of the file
+			props := make(map[string]string)
+			for k, v := range o.file.Properties {
+				props[k] = v
+			}
+			for k, v := range patch.Properties {
+				props[k] = v
+			}
+			o.file.Properties = props
+		}
+		file = o.file
+	}
+	s.m.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("File not found: %s.", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, &file)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, id string) {
+	s.m.Lock()
+	_, ok := s.objects[id]
+	if ok && id != RootID {
+		s.remove(id)
+	}
+	s.m.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("File not found: %s.", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseContentRange parses the Content-Range header of an upload chunk. first is -1 for a
+// status query ("bytes */total"), and total is -1 if unknown ("bytes first-last/*").
+func parseContentRange(v string) (first, total int64, err error) {
+	bad := errors.New(fmt.Sprintf("invalid Content-Range %q", v))
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, bad
+	}
+	parts := strings.SplitN(strings.TrimPrefix(v, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, bad
+	}
+	total = -1
+	if parts[1] != "*" {
+		if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, bad
+		}
+	}
+	first = -1
+	if parts[0] != "*" {
+		r := strings.SplitN(parts[0], "-", 2)
+		if first, err = strconv.ParseInt(r[0], 10, 64); err != nil {
+			return 0, 0, bad
+		}
+	}
+	return first, total, nil
+}
+
+// writeIncomplete tells the client how many bytes of a resumable upload have been received.
+func writeIncomplete(w http.ResponseWriter, r *http.Request, received int64) {
+	if received > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusPermanentRedirect)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format googleapi.CheckResponse understands.
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"errors": []map[string]string{{
+				"domain":  "global",
+				"reason":  reason,
+				"message": message,
+			}},
+			"code":    code,
+			"message": message,
+		},
+	})
+}
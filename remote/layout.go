@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	S "github.com/KireinaHoro/DriveSync/state"
+	TG "github.com/KireinaHoro/DriveSync/tags"
+)
+
+// audioLayout holds where the files of an object synced with C.LayoutAudioTags go.
+type audioLayout struct {
+	// root is the path of the object
+	root string
+	// dirs: key: file path; value: album folder, relative to the category, e.g. "Artist/Album (2001)"
+	dirs map[string]string
+	// names: key: file path; value: name on the Backend
+	names map[string]string
+	// ids: key: album folder; value: ID on the Backend
+	ids map[string]string
+}
+
+// planAudioLayout reads the tags of the audio files under root, placing each of them in
+// Artist/Album (Year)/NN - Title.ext. Files without tags keep their names, and go into the
+// album of the tagged files in the same directory, or else into the first album of the object.
+//
+// It returns nil if there's no tagged audio file under root.
+func planAudioLayout(root string) *audioLayout {
+	r := &audioLayout{root: root, dirs: make(map[string]string), names: make(map[string]string),
+		ids: make(map[string]string)}
+	// albums: key: local directory; value: album folder of its first tagged file
+	albums := make(map[string]string)
+	// taken: key: album folder; value: names given to files in it
+	taken := make(map[string]map[string]struct{})
+	var untagged []string
+	var first string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// syncTree will report it
+			return nil
+		}
+		if C.Config.Get().Ignored(path) || info.IsDir() {
+			return nil
+		} else if strings.HasPrefix(info.Name(), S.MarkPrefix) {
+			return nil
+		}
+		t, err := TG.Read(path)
+		if err != nil {
+			if err != TG.ErrorNoTags {
+				log.Printf("W: %v", err)
+			}
+			untagged = append(untagged, path)
+			return nil
+		}
+		artist := t.AlbumArtist
+		if artist == "" {
+			artist = t.Artist
+		}
+		if artist == "" {
+			artist = "Unknown Artist"
+		}
+		album := t.Album
+		if album == "" {
+			album = "Unknown Album"
+		}
+		if t.Year != "" {
+			album += fmt.Sprintf(" (%s)", t.Year)
+		}
+		dir := cleanName(artist) + "/" + cleanName(album)
+		name := info.Name()
+		if t.Title != "" {
+			name = cleanName(t.Title) + filepath.Ext(name)
+			if t.Track > 0 {
+				name = fmt.Sprintf("%02d - %s", t.Track, name)
+			}
+		}
+		r.dirs[path], r.names[path] = dir, uniqueName(taken, dir, name)
+		if _, ok := albums[filepath.Dir(path)]; !ok {
+			albums[filepath.Dir(path)] = dir
+		}
+		if first == "" {
+			first = dir
+		}
+		return nil
+	})
+	if first == "" {
+		return nil
+	}
+	for _, v := range untagged {
+		dir, ok := albums[filepath.Dir(v)]
+		if !ok {
+			dir = first
+		}
+		r.dirs[v], r.names[v] = dir, uniqueName(taken, dir, filepath.Base(v))
+	}
+	return r
+}
+
+// cleanName makes a tag value usable as the name of a file or folder.
+func cleanName(v string) string {
+	v = strings.Replace(v, "/", "_", -1)
+	if v == "." || v == ".." {
+		return "_"
+	}
+	return v
+}
+
+// uniqueName returns name, numbered if it's been given to another file in dir already, as
+// files of the same name in a folder would replace each other.
+func uniqueName(taken map[string]map[string]struct{}, dir, name string) string {
+	if taken[dir] == nil {
+		taken[dir] = make(map[string]struct{})
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		if _, ok := taken[dir][name]; !ok {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	taken[dir][name] = struct{}{}
+	return name
+}
+
+// locate returns the ID of the album folder the file at path goes into, creating the folder
+// in the upload location of the object if needed, and the name of the file there. ok is false if the file is not
+// in the layout. ctx is used for logging.
+func (r *audioLayout) locate(ctx context.Context, reader *bufio.Reader, b Backend, path, category string) (parentID,
+	name string, ok bool, err error) {
+	dir, ok := r.dirs[path]
+	if !ok {
+		return "", "", false, nil
+	}
+	name = r.names[path]
+	if id, ok := r.ids[dir]; ok {
+		return id, name, true, nil
+	}
+	parentID, err = getUploadLocation(reader, b, category, r.root)
+	if err != nil {
+		return "", "", true, err
+	}
+	for _, v := range strings.Split(dir, "/") {
+		leafName, id := v, ""
+		err = withRetry(ctx, func() error {
+			var err error
+			id, err = createDirectoryWithCheck(b, leafName, parentID)
+			return err
+		}, retryIfNeeded)
+		if err != nil {
+			return "", "", true, err
+		}
+		parentID = id
+	}
+	if C.Config.Get().Verbose {
+		log.Printf("Using directory '%s' in category %s with ID %s", dir, category, parentID)
+	}
+	r.ids[dir] = parentID
+	return parentID, name, true, nil
+}
@@ -0,0 +1,193 @@
+package remote
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// tempPrefix prefixes the names of files being copied into the archive.
+const tempPrefix = ".drivesync-"
+
+// localBackend is the Backend that stores objects in a local directory tree, e.g. a NAS mount.
+// IDs of objects are their absolute paths, so the layout under root is identical to the one
+// maintained on Google Drive: root/archive-root/<category>/...
+type localBackend struct {
+	root string
+}
+
+// NewLocalBackend returns a Backend that archives objects under the directory root.
+func NewLocalBackend(root string) (*localBackend, error) {
+	if root == "" {
+		return nil, errors.New("local root not set")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to resolve local root: %v", err))
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to stat local root: %v", err))
+	} else if !fi.IsDir() {
+		return nil, errors.New(fmt.Sprintf("local root %q is not a directory", root))
+	}
+	return &localBackend{root: root}, nil
+}
+
+func (r *localBackend) RootID() string {
+	return r.root
+}
+
+// checkLeafName returns an error if leafName is not a single path element, which would have it
+// point elsewhere than into its parent.
+func checkLeafName(leafName string) error {
+	if leafName == "" || leafName == "." || leafName == ".." || strings.Contains(leafName, "/") {
+		return errors.New(fmt.Sprintf("bad name %q", leafName))
+	}
+	return nil
+}
+
+func (r *localBackend) GetLeafFromParent(leafName, parentID string, wantFolder bool) (string, error) {
+	if err := checkLeafName(leafName); err != nil {
+		return "", err
+	}
+	leafPath := filepath.Join(parentID, leafName)
+	fi, err := os.Stat(leafPath)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir() != wantFolder) {
+		return "", E.ErrorNotFound(fmt.Sprintf("error: no '%s' in '%s'", leafName, parentID))
+	} else if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to stat '%s': %v", leafPath, err))
+	}
+	return leafPath, nil
+}
+
+func (r *localBackend) CreateDirectory(leafName, parentID string) (string, error) {
+	if err := checkLeafName(leafName); err != nil {
+		return "", err
+	}
+	leafPath := filepath.Join(parentID, leafName)
+	if err := os.Mkdir(leafPath, 0755); err != nil {
+		return "", err
+	}
+	return leafPath, nil
+}
+
+func (r *localBackend) CreateFile(leafPath, leafName, parentID string) (string, string, error) {
+	if err := checkLeafName(leafName); err != nil {
+		return "", "", err
+	}
+	conf := C.Config.Get().ForPath(leafPath)
+	src, err := os.Open(leafPath)
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("failed to open file '%s': %v", leafPath, err))
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("failed to stat file '%s': %v", leafPath, err))
+	}
+	// write to a temporary file first so that no partial file is left under the real name
+	dst, err := ioutil.TempFile(parentID, tempPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(dst.Name())
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(dst, h), transfers.reader(leafPath, src, 0))
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", "", errors.New(fmt.Sprintf("failed to copy file '%s': %v", leafPath, err))
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if conf.ForceRecheck {
+		if err := checkCopy(dst.Name(), sum); err != nil {
+			return "", "", err
+		}
+	}
+	if err := os.Chmod(dst.Name(), 0644); err != nil {
+		return "", "", err
+	}
+	os.Chtimes(dst.Name(), srcInfo.ModTime(), srcInfo.ModTime())
+	id := filepath.Join(parentID, leafName)
+	if err := os.Rename(dst.Name(), id); err != nil {
+		return "", "", err
+	}
+	return id, sum, nil
+}
+
+// checkCopy re-reads the stored copy at path, as the data we've written may not be what lands on
+// disk, returning an E.ErrorChecksumMismatch if its MD5 isn't realSum.
+func checkCopy(path, realSum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
+	}
+	defer f.Close()
+	sum, err := U.CalculateSum(f)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+	}
+	if sum != realSum {
+		return checksumMismatch(fmt.Sprintf("md5Checksum mismatch: remote %s, local %s", sum, realSum))
+	}
+	return nil
+}
+
+func (r *localBackend) GetChecksum(fileID string) (string, error) {
+	f, err := os.Open(fileID)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return U.CalculateSum(f)
+}
+
+func (r *localBackend) Download(fileID string, offset int64) (io.ReadCloser, int64, error) {
+	f, err := os.Open(fileID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, offset, nil
+}
+
+func (r *localBackend) Delete(fileID string) error {
+	return os.RemoveAll(fileID)
+}
+
+func (r *localBackend) ListChildren(parentID string) ([]Entry, error) {
+	infos, err := ioutil.ReadDir(parentID)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Entry
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), tempPrefix) {
+			// copy in progress
+			continue
+		}
+		e := Entry{ID: filepath.Join(parentID, fi.Name()), Name: fi.Name(), IsDir: fi.IsDir()}
+		if !e.IsDir {
+			e.Size = fi.Size()
+			if e.Md5Checksum, err = r.GetChecksum(e.ID); err != nil {
+				return nil, errors.New(fmt.Sprintf("failed to calculate md5Checksum of '%s': %v", e.ID, err))
+			}
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
@@ -0,0 +1,148 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	E "github.com/KireinaHoro/DriveSync/errors"
+)
+
+// newTestLocal returns a local Backend archiving into a new temporary directory, with the
+// configuration reset by resetConfig.
+func newTestLocal(t *testing.T) (string, Backend) {
+	resetConfig()
+	root := tempDir(t)
+	b, err := NewLocalBackend(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, b
+}
+
+func TestLocalCreateFile(t *testing.T) {
+	// rechecking the copy
+	root, b := newTestLocal(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"a.flac": "new content"})
+	path := filepath.Join(src, "a.flac")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	// replaced as a whole
+	writeFiles(t, root, map[string]string{"a.flac": "old"})
+	id, sum, err := b.CreateFile(path, "a.flac", root)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if id != filepath.Join(root, "a.flac") {
+		t.Errorf("ID %q, want the path of the copy", id)
+	}
+	// MD5 of "new content"
+	if sum != "96c15c2bb2921193bf290df8cd85e2ba" {
+		t.Errorf("md5Checksum %s, want the one of the copy", sum)
+	}
+	if data, err := ioutil.ReadFile(id); err != nil || string(data) != "new content" {
+		t.Errorf("copy holds %q (%v)", data, err)
+	}
+	if fi, err := os.Stat(id); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("copy not dated as the file: %v", err)
+	}
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("%d files left in the folder, want the copy only", len(infos))
+	}
+}
+
+func TestLocalCreateFileCancelled(t *testing.T) {
+	root, b := newTestLocal(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"a.flac": "content"})
+	path := filepath.Join(src, "a.flac")
+	p := Track(src)
+	defer p.Stop()
+	p.Cancel()
+	if _, _, err := b.CreateFile(path, "a.flac", root); err == nil {
+		t.Fatal("cancelled copy succeeded")
+	}
+	if infos, _ := ioutil.ReadDir(root); len(infos) != 0 {
+		t.Errorf("%s left behind", infos[0].Name())
+	}
+}
+
+func TestLocalCheckCopy(t *testing.T) {
+	root, _ := newTestLocal(t)
+	writeFiles(t, root, map[string]string{"a.flac": "content"})
+	path := filepath.Join(root, "a.flac")
+	// MD5 of "content"
+	if err := checkCopy(path, "9a0364b9e99bb480dd25e1f0284c8555"); err != nil {
+		t.Errorf("intact copy rejected: %v", err)
+	}
+	if err := checkCopy(path, "00000000000000000000000000000000"); err == nil {
+		t.Error("mismatching copy accepted")
+	} else if _, ok := err.(E.ErrorChecksumMismatch); !ok {
+		t.Errorf("mismatch reported as %v", err)
+	}
+}
+
+func TestLocalListChildren(t *testing.T) {
+	root, b := newTestLocal(t)
+	writeFiles(t, root, map[string]string{
+		"a.flac":            "content",
+		"Folder/b.flac":     "b",
+		tempPrefix + "1234": "partial",
+	})
+	entries, err := b.ListChildren(root)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2: %v", len(entries), entries)
+	}
+	want := []Entry{
+		{ID: filepath.Join(root, "Folder"), Name: "Folder", IsDir: true},
+		{ID: filepath.Join(root, "a.flac"), Name: "a.flac", Size: 7,
+			Md5Checksum: "9a0364b9e99bb480dd25e1f0284c8555"},
+	}
+	for i, e := range entries {
+		if e != want[i] {
+			t.Errorf("entry %d is %+v, want %+v", i, e, want[i])
+		}
+	}
+}
+
+func TestLocalBackendRejectsBadNames(t *testing.T) {
+	root := tempDir(t)
+	archive := filepath.Join(root, "archive")
+	if err := os.Mkdir(archive, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewLocalBackend(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", ".", "..", "../escaped", "a/b"} {
+		if _, err := b.CreateDirectory(name, archive); err == nil {
+			t.Errorf("directory %q created", name)
+		}
+		if _, err := b.GetLeafFromParent(name, archive, true); err == nil {
+			t.Errorf("%q looked up", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !os.IsNotExist(err) {
+		t.Error("directory created outside of the archive")
+	}
+	writeFiles(t, root, map[string]string{"src/f.flac": "content"})
+	if _, _, err := b.CreateFile(filepath.Join(root, "src", "f.flac"), "../f.flac", archive); err == nil {
+		t.Error("file with a bad name created")
+	}
+	if _, err := os.Stat(filepath.Join(root, "f.flac")); !os.IsNotExist(err) {
+		t.Error("file created outside of the archive")
+	}
+}
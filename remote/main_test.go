@@ -0,0 +1,75 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "drivesync-state")
+	if err != nil {
+		panic(err)
+	}
+	if err := S.Open(filepath.Join(dir, "state.db")); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestDrive starts a fake Drive with an "archive" root, returning it with a Backend talking
+// to it, with the configuration reset by resetConfig.
+func newTestDrive(t *testing.T) (*drivetest.Server, Backend) {
+	s := drivetest.NewServer()
+	t.Cleanup(s.Close)
+	srv, err := s.Service()
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	resetConfig()
+	return s, NewDriveBackend(srv, s.Client())
+}
+
+// resetConfig resets the configuration to archive into "archive", creating missing folders and
+// rechecking uploads, and clears the caches of folder IDs.
+func resetConfig() {
+	conf := C.NewConfig()
+	conf.ArchiveRootName = "archive"
+	conf.CreateMissing = true
+	conf.ForceRecheck = true
+	C.Config.Set(conf)
+	C.ArchiveRootIDs = U.NewSafeMap()
+	C.CategoryIDs = U.NewSafeMap()
+	C.PathIDs = U.NewSafeMap()
+}
+
+// writeFiles creates the files under dir, with their content; key: path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for k, v := range files {
+		p := filepath.Join(dir, k)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tempDir returns a new temporary directory, removed at the end of the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "drivesync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
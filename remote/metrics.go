@@ -0,0 +1,83 @@
+package remote
+
+import (
+	"time"
+
+	E "github.com/KireinaHoro/DriveSync/errors"
+	M "github.com/KireinaHoro/DriveSync/metrics"
+)
+
+// The metrics of syncing, served by `drivesyncd` if C.Config.MetricsAddress is set.
+var (
+	uploadedBytes = M.NewCounter("drivesync_uploaded_bytes_total",
+		"Bytes sent in uploads, including the ones sent again on retries.")
+	objects = M.NewCounter("drivesync_objects_total",
+		"Objects synced, by category and result: synced, already-synced, failed or cancelled.",
+		"category", "result")
+	files = M.NewCounter("drivesync_files_total",
+		"Files in objects synced, by category and result: synced, already-synced, failed or cancelled.",
+		"category", "result")
+	retries = M.NewCounter("drivesync_retries_total",
+		"Operations retried, by reason: rate-limit, server-error, checksum-mismatch, session-expired, network "+
+			"or other.", "reason")
+	checksumMismatches = M.NewCounter("drivesync_checksum_mismatches_total",
+		"Uploads and downloads whose MD5 didn't match the one of their source.")
+	uploadDuration = M.NewHistogram("drivesync_upload_duration_seconds",
+		"Time taken by successful uploads of files, including retries.", M.ExponentialBuckets(0.25, 2, 15))
+	lastSync = M.NewGauge("drivesync_last_sync_timestamp_seconds",
+		"Unix time of the last object synced successfully.")
+)
+
+func init() {
+	M.NewGaugeFunc("drivesync_queued_uploads", "Uploads waiting for a slot in the upload queue.",
+		func() float64 { return float64(uploadPool.Queued()) })
+	M.NewGaugeFunc("drivesync_active_uploads", "Uploads in progress.", func() float64 {
+		transfers.m.Lock()
+		defer transfers.m.Unlock()
+		return float64(len(transfers.files))
+	})
+}
+
+// syncResult returns the result label of a sync that returned err.
+func syncResult(err error) string {
+	switch err.(type) {
+	case nil:
+		return "synced"
+	case E.ErrorAlreadySynced:
+		return "already-synced"
+	case E.ErrorCancelled:
+		return "cancelled"
+	default:
+		return "failed"
+	}
+}
+
+// countObject counts the result of syncing an object into category, as returned by Sync.
+func countObject(category string, err error) {
+	if err == nil {
+		lastSync.Set(float64(time.Now().Unix()))
+	}
+	objects.Inc(category, syncResult(err))
+}
+
+// countFile counts the result of uploading a file of an object into category, err being the
+// error returned by uploadFile.
+func countFile(category string, err error) {
+	files.Inc(category, syncResult(err))
+}
+
+// countRetry counts a retry due to err.
+func countRetry(err error) {
+	reason := retryReason(err)
+	if reason == "" {
+		// retried by a shouldRetry other than retryIfNeeded
+		reason = "other"
+	}
+	retries.Inc(reason)
+}
+
+// checksumMismatch returns an E.ErrorChecksumMismatch with msg, counting it.
+func checksumMismatch(msg string) error {
+	checksumMismatches.Inc()
+	return E.ErrorChecksumMismatch(msg)
+}
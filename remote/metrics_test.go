@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	M "github.com/KireinaHoro/DriveSync/metrics"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+)
+
+// scrape returns the lines served by M.Handler.
+func scrape(t *testing.T) map[string]bool {
+	srv := httptest.NewServer(M.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	lines := make(map[string]bool)
+	for _, v := range strings.Split(string(body), "\n") {
+		lines[v] = true
+	}
+	return lines
+}
+
+// counted returns the values of series served by M.Handler, 0 for those not served yet.
+func counted(t *testing.T, series []string) []float64 {
+	lines := scrape(t)
+	ret := make([]float64, len(series))
+	for i, v := range series {
+		for line := range lines {
+			if strings.HasPrefix(line, v+" ") {
+				n, err := strconv.ParseFloat(strings.TrimPrefix(line, v+" "), 64)
+				if err != nil {
+					t.Fatalf("bad sample %q: %v", line, err)
+				}
+				ret[i] = n
+			}
+		}
+	}
+	return ret
+}
+
+func TestSyncCountsFiles(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.Incremental = true
+	conf.MaxUploads = 1
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{
+		"Rel/a.flac": "aaa",
+		"Rel/b.flac": "bbb",
+		"Rel/c.flac": "ccc",
+	})
+	// the counters are shared by the other tests, and by the runs of -count
+	series := []string{
+		`drivesync_files_total{category="Counted",result="synced"}`,
+		`drivesync_files_total{category="Counted",result="already-synced"}`,
+		`drivesync_files_total{category="Counted",result="failed"}`,
+		`drivesync_objects_total{category="Counted",result="synced"}`,
+		`drivesync_objects_total{category="Counted",result="already-synced"}`,
+		`drivesync_objects_total{category="Counted",result="failed"}`,
+	}
+	before := counted(t, series)
+	path := filepath.Join(src, "Rel")
+	if err := SyncDirectory(nil, b, path, "Counted"); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFiles(t, src, map[string]string{"Rel/d.flac": "ddd"})
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Status: 404})
+	if err := SyncDirectory(nil, b, path, "Counted"); err == nil {
+		t.Fatal("failed upload not reported")
+	}
+	// not re-synced, so d.flac isn't counted
+	conf.Incremental = false
+	C.Config.Set(conf)
+	if _, ok := SyncDirectory(nil, b, path, "Counted").(E.ErrorAlreadySynced); !ok {
+		t.Fatal("third sync not reported as already synced")
+	}
+	after := counted(t, series)
+	for i, want := range []float64{3, 6, 1, 1, 1, 1} {
+		if got := after[i] - before[i]; got != want {
+			t.Errorf("%s went up by %v, want %v", series[i], got, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// A PlanAction is what syncing would do to an object on the Backend.
+type PlanAction string
+
+const (
+	// PlanCreateFolder is a folder to be created.
+	PlanCreateFolder PlanAction = "create-folder"
+	// PlanUpload is a file to be uploaded.
+	PlanUpload PlanAction = "upload"
+	// PlanMatch is a file whose remote copy has the same md5Checksum, and won't be uploaded.
+	PlanMatch PlanAction = "match"
+	// PlanDeleteDuplicate is a remote file to be deleted before uploading the file of its name.
+	PlanDeleteDuplicate PlanAction = "delete-duplicate"
+)
+
+// A PlanEntry is an action in a Plan.
+type PlanEntry struct {
+	Action PlanAction `json:"action"`
+	// Remote is the path on the Backend, starting with the archive root.
+	Remote string `json:"remote"`
+	// Path is the local path, if any.
+	Path string `json:"path,omitempty"`
+	// RemoteID is the ID of the existing remote object, if any.
+	RemoteID string `json:"remote-id,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// A Plan lists what syncing a target would do; see PlanSync.
+type Plan struct {
+	Target   string `json:"target"`
+	Category string `json:"category"`
+	// AlreadySynced is true if the target is recorded in the sync state, and won't be synced.
+	AlreadySynced bool        `json:"already-synced"`
+	Entries       []PlanEntry `json:"entries"`
+}
+
+// planner resolves remote folders read-only, keeping track of those to be created.
+type planner struct {
+	b    Backend
+	ctx  context.Context
+	plan *Plan
+	// folders: key: remote path; value: ID, or "" if the folder is to be created
+	folders map[string]string
+}
+
+// PlanSync works out what Sync would do to the Backend for the object at path in category,
+// without changing anything: it resolves the existing remote folders, and compares the
+// md5Checksum of existing remote files, as createFileWithCheck does. Files that are recorded in
+// the sync state and unchanged since are left out of the plan.
+func PlanSync(b Backend, path, category string) (*Plan, error) {
+	path = filepath.Clean(path)
+	conf := C.Config.Get().ForPath(path)
+	p := &planner{
+		b:       b,
+		ctx:     U.CtxWithLoggerID(context.Background(), "plan"),
+		plan:    &Plan{Target: path, Category: category},
+		folders: make(map[string]string),
+	}
+	if id, ok := C.ArchiveRootIDs.Get(conf.ArchiveRootName); ok {
+		p.folders[conf.ArchiveRootName] = id
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to stat path: %v", err))
+	}
+	if conf.Ignored(path) || strings.HasPrefix(info.Name(), S.MarkPrefix) {
+		return p.plan, nil
+	}
+	rec, ok, err := S.Get(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to check sync state: %v", err))
+	} else if ok && (!info.IsDir() || !conf.Incremental) {
+		p.plan.AlreadySynced = true
+		return p.plan, nil
+	} else if ok && rec.Category != "" {
+		category = rec.Category
+		p.plan.Category = category
+	}
+	if id, ok := C.CategoryIDs.Get(conf.ArchiveRootName + "/" + category); ok {
+		p.folders[conf.ArchiveRootName+"/"+category] = id
+	}
+	// the remote path of the upload location, as getUploadLocation would resolve it
+	dirs, err := uploadFolders(category, path)
+	if err != nil {
+		return nil, err
+	}
+	location := func(more ...string) []string {
+		return append(append([]string{conf.ArchiveRootName, category}, dirs...), more...)
+	}
+	if !info.IsDir() {
+		return p.plan, p.planFile(path, info, location(), info.Name())
+	}
+	manifest := make(map[string]S.Record)
+	if ok {
+		err = S.Walk(path, func(rec S.Record) error {
+			manifest[rec.Path] = rec
+			return nil
+		})
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to read sync state: %v", err))
+		}
+	}
+	var layout *audioLayout
+	if conf.Layout(category) == C.LayoutAudioTags {
+		layout = planAudioLayout(path)
+	}
+	root := filepath.Dir(path)
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if conf.Ignored(path) {
+			return nil
+		} else if strings.HasPrefix(info.Name(), S.MarkPrefix) {
+			return nil
+		}
+		if rec, ok := manifest[path]; ok && !info.IsDir() {
+			if unchanged, err := fileUnchanged(rec, path, info); err != nil {
+				return err
+			} else if unchanged {
+				return nil
+			}
+		}
+		rel, _ := filepath.Rel(root, path)
+		if layout != nil {
+			if info.IsDir() {
+				return nil
+			}
+			if dir, ok := layout.dirs[path]; ok {
+				return p.planFile(path, info, location(strings.Split(dir, "/")...), layout.names[path])
+			}
+		}
+		segments := location(strings.Split(filepath.ToSlash(rel), "/")...)
+		if info.IsDir() {
+			_, err := p.resolve(segments)
+			return err
+		}
+		return p.planFile(path, info, segments[:len(segments)-1], info.Name())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.plan, nil
+}
+
+// resolve returns the ID of the remote folder at the path given by segments, or "" if it's to be
+// created, in which case it's added to the plan.
+func (r *planner) resolve(segments []string) (string, error) {
+	key := strings.Join(segments, "/")
+	if id, ok := r.folders[key]; ok {
+		return id, nil
+	}
+	parentID := r.b.RootID()
+	if len(segments) > 1 {
+		var err error
+		parentID, err = r.resolve(segments[:len(segments)-1])
+		if err != nil {
+			return "", err
+		}
+	}
+	var id string
+	if parentID != "" {
+		err := withRetry(r.ctx, func() error {
+			var err error
+			id, err = r.b.GetLeafFromParent(segments[len(segments)-1], parentID, true)
+			if _, ok := err.(E.ErrorNotFound); ok {
+				return nil
+			}
+			return err
+		}, retryIfNeeded)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("failed to look up folder '%s': %v", key, err))
+		}
+	}
+	if id == "" {
+		r.plan.Entries = append(r.plan.Entries, PlanEntry{Action: PlanCreateFolder, Remote: key})
+	}
+	r.folders[key] = id
+	return id, nil
+}
+
+// planFile adds what uploading the file at path as leafName into the folder at the path given
+// by segments takes to the plan.
+func (r *planner) planFile(path string, info os.FileInfo, segments []string, leafName string) error {
+	parentID, err := r.resolve(segments)
+	if err != nil {
+		return err
+	}
+	remote := strings.Join(segments, "/") + "/" + leafName
+	upload := PlanEntry{Action: PlanUpload, Remote: remote, Path: path, Size: info.Size()}
+	if parentID == "" {
+		r.plan.Entries = append(r.plan.Entries, upload)
+		return nil
+	}
+	var fileID string
+	var lookupErr error
+	err = withRetry(r.ctx, func() error {
+		fileID, lookupErr = r.b.GetLeafFromParent(leafName, parentID, false)
+		switch lookupErr.(type) {
+		case E.ErrorNotFound, E.ErrorMultipleResults:
+			// answers rather than failures
+			return nil
+		}
+		return lookupErr
+	}, retryIfNeeded)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to look up file '%s': %v", remote, err))
+	}
+	switch err := lookupErr.(type) {
+	case nil:
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
+		}
+		defer f.Close()
+		realSum, err := U.CalculateSum(f)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to calculate checksum: %v", err))
+		}
+		remoteSum, err := r.b.GetChecksum(fileID)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to get checksum of '%s': %v", remote, err))
+		}
+		if realSum == remoteSum {
+			r.plan.Entries = append(r.plan.Entries, PlanEntry{Action: PlanMatch, Remote: remote, Path: path,
+				RemoteID: fileID, Size: info.Size()})
+			return nil
+		}
+		r.plan.Entries = append(r.plan.Entries, PlanEntry{Action: PlanDeleteDuplicate, Remote: remote,
+			RemoteID: fileID})
+	case E.ErrorNotFound:
+	case E.ErrorMultipleResults:
+		for _, v := range err {
+			r.plan.Entries = append(r.plan.Entries, PlanEntry{Action: PlanDeleteDuplicate, Remote: remote,
+				RemoteID: v})
+		}
+	}
+	r.plan.Entries = append(r.plan.Entries, upload)
+	return nil
+}
+
+// WriteText writes the plan to w in a human-readable form.
+func (r *Plan) WriteText(w io.Writer) {
+	if r.AlreadySynced {
+		fmt.Fprintf(w, "'%s' is already synced; nothing to do.\n", r.Target)
+		return
+	}
+	fmt.Fprintf(w, "Plan for syncing '%s' into category %s:\n", r.Target, r.Category)
+	counts := make(map[PlanAction]int)
+	var size int64
+	for _, v := range r.Entries {
+		counts[v.Action]++
+		switch v.Action {
+		case PlanCreateFolder:
+			fmt.Fprintf(w, "  create folder     %s\n", v.Remote)
+		case PlanUpload:
+			size += v.Size
+			fmt.Fprintf(w, "  upload            %s (%d bytes, from %s)\n", v.Remote, v.Size, v.Path)
+		case PlanMatch:
+			fmt.Fprintf(w, "  already matching  %s (%s)\n", v.Remote, v.RemoteID)
+		case PlanDeleteDuplicate:
+			fmt.Fprintf(w, "  delete duplicate  %s (%s)\n", v.Remote, v.RemoteID)
+		}
+	}
+	fmt.Fprintf(w, "%d folder(s) to create, %d file(s) to upload (%d bytes), %d file(s) matching, "+
+		"%d duplicate(s) to delete.\n", counts[PlanCreateFolder], counts[PlanUpload], size, counts[PlanMatch],
+		counts[PlanDeleteDuplicate])
+}
@@ -0,0 +1,90 @@
+package remote
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+)
+
+func TestPlanSync(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{
+		"Rel/same.flac":    "same",
+		"Rel/changed.flac": "new",
+		"Rel/dup.flac":     "dup",
+		"Rel/new.flac":     "new!",
+		"Rel/Sub/x.flac":   "x",
+	})
+	archive := s.Mkdir("archive", drivetest.RootID)
+	rel := s.Mkdir("Rel", s.Mkdir("Music", archive))
+	sameID := s.Put("same.flac", rel, []byte("same"))
+	changedID := s.Put("changed.flac", rel, []byte("old"))
+	dupIDs := []string{s.Put("dup.flac", rel, []byte("dup")), s.Put("dup.flac", rel, []byte("dup"))}
+	path := filepath.Join(src, "Rel")
+	plan, err := PlanSync(b, path, "Music")
+	if err != nil {
+		t.Fatalf("PlanSync: %v", err)
+	}
+	file := func(name string) string { return filepath.Join(path, name) }
+	want := []PlanEntry{
+		{Action: PlanCreateFolder, Remote: "archive/Music/Rel/Sub"},
+		{Action: PlanUpload, Remote: "archive/Music/Rel/Sub/x.flac", Path: file("Sub/x.flac"), Size: 1},
+		{Action: PlanDeleteDuplicate, Remote: "archive/Music/Rel/changed.flac", RemoteID: changedID},
+		{Action: PlanUpload, Remote: "archive/Music/Rel/changed.flac", Path: file("changed.flac"), Size: 3},
+		{Action: PlanDeleteDuplicate, Remote: "archive/Music/Rel/dup.flac", RemoteID: dupIDs[0]},
+		{Action: PlanDeleteDuplicate, Remote: "archive/Music/Rel/dup.flac", RemoteID: dupIDs[1]},
+		{Action: PlanUpload, Remote: "archive/Music/Rel/dup.flac", Path: file("dup.flac"), Size: 3},
+		{Action: PlanUpload, Remote: "archive/Music/Rel/new.flac", Path: file("new.flac"), Size: 4},
+		{Action: PlanMatch, Remote: "archive/Music/Rel/same.flac", Path: file("same.flac"), RemoteID: sameID,
+			Size: 4},
+	}
+	if plan.Target != path || plan.Category != "Music" || plan.AlreadySynced {
+		t.Errorf("plan of %s into %s, already synced: %v", plan.Target, plan.Category, plan.AlreadySynced)
+	}
+	if len(plan.Entries) != len(want) {
+		t.Errorf("%d entries, want %d: %+v", len(plan.Entries), len(want), plan.Entries)
+	}
+	for i := 0; i < len(plan.Entries) && i < len(want); i++ {
+		if !reflect.DeepEqual(plan.Entries[i], want[i]) {
+			t.Errorf("entry %d is %+v, want %+v", i, plan.Entries[i], want[i])
+		}
+	}
+	for _, op := range []string{drivetest.OpCreate, drivetest.OpUpload, drivetest.OpDelete,
+		drivetest.OpUpdate} {
+		if n := s.Requests(op); n != 0 {
+			t.Errorf("%d %s requests, want none", n, op)
+		}
+	}
+	var text bytes.Buffer
+	plan.WriteText(&text)
+	summary := "1 folder(s) to create, 4 file(s) to upload (11 bytes), 1 file(s) matching, 3 duplicate(s) to delete."
+	if !strings.Contains(text.String(), summary) {
+		t.Errorf("plan written as:\n%s", text.String())
+	}
+}
+
+func TestPlanSyncAlreadySynced(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "content"})
+	path := filepath.Join(src, "f.bin")
+	if err := SyncFile(nil, b, path, "Misc"); err != nil {
+		t.Fatalf("SyncFile: %v", err)
+	}
+	creates := s.Requests(drivetest.OpCreate)
+	plan, err := PlanSync(b, path, "Misc")
+	if err != nil {
+		t.Fatalf("PlanSync: %v", err)
+	}
+	if !plan.AlreadySynced || len(plan.Entries) != 0 {
+		t.Errorf("plan of synced file: %+v", plan)
+	}
+	if n := s.Requests(drivetest.OpCreate); n != creates {
+		t.Errorf("%d create requests while planning", n-creates)
+	}
+}
@@ -0,0 +1,175 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// partSuffix is appended to the names of files being restored, until they're complete.
+const partSuffix = ".drivesync-part"
+
+// maxMismatches is the number of times a file is downloaded again after its md5Checksum didn't
+// match; a copy that keeps mismatching is likely damaged in the archive.
+const maxMismatches = 2
+
+// Restore downloads the object at p under the archive root, e.g. "Music/My Great Record", into
+// the local directory dest, along with everything in it if it's a folder.
+//
+// Files are downloaded into partial files first, which get resumed if they're found by a later
+// call, and are renamed once their md5Checksum has been verified. Downloads are retried as
+// uploads are, though only maxMismatches times for checksum mismatches. Files that exist
+// locally already are skipped if identical; other failures are logged, and reported together
+// when everything else has been restored.
+func Restore(b Backend, p, dest string) error {
+	e, err := ResolvePath(b, p)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to find '%s' in the archive: %v", p, err))
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	ctx := U.CtxWithLoggerID(context.Background(), fmt.Sprintf("%05x", rand.Uint32()%0xfffff))
+	if failed := restoreEntry(ctx, b, e, dest); failed > 0 {
+		return errors.New(fmt.Sprintf("failed to restore %d object(s)", failed))
+	}
+	return nil
+}
+
+// restoreEntry restores e into the local directory dir, returning the number of objects that
+// failed.
+func restoreEntry(ctx context.Context, b Backend, e Entry, dir string) int {
+	path := filepath.Join(dir, cleanName(e.Name))
+	if !e.IsDir {
+		var mismatches int
+		err := withRetry(ctx, func() error {
+			return restoreFile(ctx, b, e, path)
+		}, func(err error) bool {
+			if _, ok := err.(E.ErrorChecksumMismatch); ok {
+				mismatches++
+				return mismatches <= maxMismatches
+			}
+			return retryIfNeeded(err)
+		})
+		if err != nil {
+			log.Printf("E: Failed to restore '%s': %v", path, err)
+			return 1
+		}
+		return 0
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Printf("E: Failed to create directory '%s': %v", path, err)
+		return 1
+	}
+	var entries []Entry
+	err := withRetry(ctx, func() error {
+		var err error
+		entries, err = b.ListChildren(e.ID)
+		return err
+	}, retryIfNeeded)
+	if err != nil {
+		log.Printf("E: Failed to list '%s' (%s): %v", e.Name, e.ID, err)
+		return 1
+	}
+	var failed int
+	for _, v := range entries {
+		failed += restoreEntry(ctx, b, v, path)
+	}
+	return failed
+}
+
+// restoreFile downloads the file e to path, resuming the partial file if any.
+func restoreFile(ctx context.Context, b Backend, e Entry, path string) error {
+	l := U.GetLogger(ctx)
+	conf := C.Config.Get()
+	sum, err := b.GetChecksum(e.ID)
+	if err != nil {
+		return err
+	} else if sum == "" {
+		// e.g. Google Docs, which have no binary content
+		l.Printf("W: Skipped '%s' (%s), which can't be downloaded.", e.Name, e.ID)
+		return nil
+	}
+	if f, err := os.Open(path); err == nil {
+		localSum, err := U.CalculateSum(f)
+		f.Close()
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+		} else if localSum != sum {
+			return errors.New("a different file exists at the path")
+		}
+		if conf.Verbose {
+			l.Printf("'%s' has been restored already.", path)
+		}
+		return nil
+	}
+	part := path + partSuffix
+	out, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > e.Size {
+		// not a partial download of this file
+		offset = 0
+	}
+	if offset < e.Size || e.Size == 0 {
+		if offset > 0 {
+			l.Printf("Resuming download of '%s' at byte %d.", path, offset)
+		} else if conf.Verbose {
+			l.Printf("Downloading '%s'...", path)
+		}
+		body, start, err := b.Download(e.ID, offset)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		if err := out.Truncate(start); err != nil {
+			return err
+		} else if _, err := out.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		// on failure, the partial file is kept for the retry to resume
+		if _, err := io.Copy(out, body); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	realSum, err := U.CalculateSum(f)
+	f.Close()
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+	} else if realSum != sum {
+		// start over on retry
+		os.Remove(part)
+		return checksumMismatch(fmt.Sprintf(
+			"md5Checksum mismatch: remote %s, local %s", sum, realSum))
+	}
+	if err := os.Rename(part, path); err != nil {
+		return err
+	}
+	if conf.Verbose {
+		l.Printf("Restored '%s' (from %s).", path, e.ID)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+)
+
+func TestRestoreGivesUpOnMismatches(t *testing.T) {
+	s, b := newTestDrive(t)
+	root := s.Mkdir("archive", drivetest.RootID)
+	s.Put("a.flac", s.Mkdir("Music", root), []byte("aaa"))
+	// the md5Checksum in the metadata never matches the content
+	s.Inject(drivetest.Fault{Op: drivetest.OpGet, Times: -1, BadChecksum: true})
+	dest := tempDir(t)
+	if err := Restore(b, "Music/a.flac", dest); err == nil {
+		t.Fatal("mismatch not reported")
+	}
+	// the metadata and the content, for every try
+	if n, want := s.Requests(drivetest.OpGet), 2*(maxMismatches+1); n != want {
+		t.Errorf("%d requests, want %d", n, want)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "a.flac")); !os.IsNotExist(err) {
+		t.Error("mismatching file restored")
+	}
+}
+
+func TestRestoreRetriesListing(t *testing.T) {
+	s, b := newTestDrive(t)
+	root := s.Mkdir("archive", drivetest.RootID)
+	rel := s.Mkdir("Rel", s.Mkdir("Music", root))
+	s.Put("a.flac", rel, []byte("aaa"))
+	// count the lookups of the path, with the archive root cached, to fail the listing after them
+	if _, err := ResolvePath(b, "Music/Rel"); err != nil {
+		t.Fatal(err)
+	}
+	n := s.Requests(drivetest.OpList)
+	if _, err := ResolvePath(b, "Music/Rel"); err != nil {
+		t.Fatal(err)
+	}
+	lookups := s.Requests(drivetest.OpList) - n
+	s.Inject(drivetest.Fault{Op: drivetest.OpList, Nth: lookups + 1, Status: 503})
+	dest := tempDir(t)
+	if err := Restore(b, "Music/Rel", dest); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(dest, "Rel", "a.flac")); string(got) != "aaa" {
+		t.Errorf("a.flac restored as %q", got)
+	}
+}
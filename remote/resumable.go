@@ -0,0 +1,233 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// sessionLifetime is how long we trust a resumable upload session to be alive. Google Drive
+// keeps sessions for a week.
+const sessionLifetime = 6 * 24 * time.Hour
+
+// errSessionExpired denotes that the server no longer knows an upload session.
+var errSessionExpired = errors.New("upload session expired")
+
+// createFileResumable uploads the file at leafPath as leafName into parentID in chunks of
+// C.Config.UploadChunkSize bytes with the resumable upload protocol of Google Drive.
+//
+// The session URI and the offset reached are stored in the state database, so that an upload
+// interrupted by an error or a restart continues where it stopped when createFileResumable is
+// called again for the same file.
+func (r *driveBackend) createFileResumable(leafPath, leafName, parentID string) (*drive.File, error) {
+	conf := C.Config.Get()
+	f, err := os.Open(leafPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to open file '%s': %v", leafPath, err))
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to stat file '%s': %v", leafPath, err))
+	}
+	var info *drive.File
+	var offset int64
+	u, ok, err := S.GetUpload(leafPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to get upload session: %v", err))
+	}
+	if ok && u.ParentID == parentID && u.Size == fi.Size() && u.ModTime.Equal(fi.ModTime()) &&
+		!u.Expired(sessionLifetime) {
+		info, offset, err = r.queryUpload(u.SessionURI, u.Size)
+		if err == errSessionExpired {
+			ok = false
+		} else if err != nil {
+			return nil, err
+		} else if conf.Verbose {
+			log.Printf("Resuming upload of '%s' at byte %d.", leafPath, offset)
+		}
+	} else {
+		ok = false
+	}
+	if !ok {
+		u = S.Upload{
+			Path:      leafPath,
+			ParentID:  parentID,
+			Size:      fi.Size(),
+			ModTime:   fi.ModTime(),
+			StartTime: time.Now(),
+		}
+		u.SessionURI, err = r.startUpload(leafName, parentID, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		if err := S.PutUpload(u); err != nil {
+			log.Printf("W: Failed to save upload session of %q: %v", leafPath, err)
+		}
+	}
+	for info == nil {
+		// a pause takes effect between chunks
+		if err := transfers.hold(leafPath); err != nil {
+			return nil, err
+		}
+		n := conf.UploadChunkSize
+		if rest := u.Size - offset; rest < n {
+			n = rest
+		}
+		info, offset, err = r.uploadChunk(u.SessionURI, uploadLimiter.Reader(
+			transfers.reader(leafPath, io.NewSectionReader(f, offset, n), offset)), offset, n, u.Size)
+		if err == errSessionExpired {
+			// start over next time
+			S.DeleteUpload(leafPath)
+			return nil, err
+		} else if err != nil {
+			return nil, err
+		}
+		u.Offset = offset
+		if info == nil {
+			if err := S.PutUpload(u); err != nil {
+				log.Printf("W: Failed to save upload session of %q: %v", leafPath, err)
+			}
+		}
+	}
+	if err := S.DeleteUpload(leafPath); err != nil {
+		log.Printf("W: Failed to remove upload session of %q: %v", leafPath, err)
+	}
+	return info, nil
+}
+
+// startUpload initiates a resumable upload session, returning its URI.
+func (r *driveBackend) startUpload(leafName, parentID string, size int64) (string, error) {
+	mimeType := mime.TypeByExtension(filepath.Ext(leafName))
+	body, err := json.Marshal(&drive.File{
+		Name:        leafName,
+		Description: leafName,
+		MimeType:    mimeType,
+		Parents:     []string{parentID},
+	})
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("uploadType", "resumable")
+	params.Set("fields", "id, md5Checksum")
+	urls := googleapi.ResolveRelative(r.srv.BasePath, "/upload/drive/v3/files") + "?" + params.Encode()
+	req, err := http.NewRequest("POST", urls, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if mimeType != "" {
+		req.Header.Set("X-Upload-Content-Type", mimeType)
+	}
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return "", errors.New("no session URI in response to resumable upload")
+	}
+	return loc, nil
+}
+
+// queryUpload asks the server how far the upload at sessionURI has got.
+func (r *driveBackend) queryUpload(sessionURI string, size int64) (*drive.File, int64, error) {
+	req, err := http.NewRequest("PUT", sessionURI, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return r.doUploadRequest(req)
+}
+
+// uploadChunk sends n bytes from chunk, which start at offset of a file of given size, returning
+// the offset reached; if the upload is complete, the created file is returned instead.
+func (r *driveBackend) uploadChunk(sessionURI string, chunk io.Reader, offset, n, size int64) (*drive.File, int64, error) {
+	req, err := http.NewRequest("PUT", sessionURI, chunk)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.ContentLength = n
+	if n > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	}
+	return r.doUploadRequest(req)
+}
+
+// doUploadRequest sends a request to an upload session, interpreting the response: either the
+// created file, or the offset reached if the upload is incomplete.
+func (r *driveBackend) doUploadRequest(req *http.Request) (*drive.File, int64, error) {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPermanentRedirect ||
+		resp.Header.Get("X-Http-Status-Code-Override") == "308":
+		// incomplete; Range looks like "bytes=0-42", and is absent if nothing was received
+		var offset int64
+		if rng := resp.Header.Get("Range"); rng != "" {
+			i := strings.LastIndex(rng, "-")
+			last, err := strconv.ParseInt(rng[i+1:], 10, 64)
+			if i < 0 || err != nil {
+				return nil, 0, errors.New(fmt.Sprintf("invalid Range %q in upload response", rng))
+			}
+			offset = last + 1
+		}
+		return nil, offset, nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		var info drive.File
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return nil, 0, errors.New(fmt.Sprintf("failed to decode upload response: %v", err))
+		}
+		return &info, 0, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, 0, errSessionExpired
+	}
+	return nil, 0, googleapi.CheckResponse(resp)
+}
+
+// checkUploaded verifies the md5Checksum of an uploaded file against the local file at leafPath.
+func checkUploaded(info *drive.File, leafPath string) error {
+	f, err := os.Open(leafPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
+	}
+	defer f.Close()
+	realSum, err := U.CalculateSum(f)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+	}
+	if sum := info.Md5Checksum; sum != realSum {
+		return checksumMismatch(fmt.Sprintf(
+			"md5Checksum mismatch: remote %s, local %s", sum, realSum))
+	}
+	return nil
+}
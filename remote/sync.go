@@ -10,66 +10,258 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
-	"google.golang.org/api/drive/v3"
 
 	C "github.com/KireinaHoro/DriveSync/config"
 	E "github.com/KireinaHoro/DriveSync/errors"
+	S "github.com/KireinaHoro/DriveSync/state"
+	T "github.com/KireinaHoro/DriveSync/torrent"
 	U "github.com/KireinaHoro/DriveSync/utils"
 )
 
-// SyncDirectory accepts a path to recursively upload to Google Drive to the specified category,
+// uploadPool runs the uploads of all syncs, C.Config.MaxUploads at a time.
+var uploadPool = U.NewPool(C.MaxUploads)
+
+// uploadJob queues job in uploadPool, following changes to C.Config.MaxUploads.
+func uploadJob(job func(ctx context.Context)) {
+	if n := C.Config.Get().MaxUploads; n > 0 {
+		uploadPool.Resize(n)
+	}
+	uploadPool.Submit(job)
+}
+
+// uploadFile uploads the file at path as leafName into parentID with retries, logging with the
+// job ID in ctx, returning the ID and the md5Checksum of the uploaded file. It returns an
+// E.ErrorCancelled if the upload gets cancelled; see Progress.
+func uploadFile(ctx context.Context, b Backend, path, leafName, parentID string) (string, string, error) {
+	conf := C.Config.Get()
+	l := U.GetLogger(ctx)
+	if err := transfers.check(path); err != nil {
+		return "", "", err
+	}
+	if conf.Verbose {
+		l.Printf("Uploading %q...", path)
+	}
+	transfers.start(path)
+	start := time.Now()
+	var id, sum string
+	// createFileWithCheck will check if file with the same name exists
+	err := withRetry(ctx, func() error {
+		if err := transfers.hold(path); err != nil {
+			return err
+		}
+		var err error
+		id, sum, err = createFileWithCheck(b, path, leafName, parentID)
+		return err
+	}, func(err error) bool {
+		return transfers.check(path) == nil && retryIfNeeded(err)
+	})
+	transfers.finish(path, err == nil)
+	if err == nil {
+		uploadDuration.Observe(time.Since(start).Seconds())
+	}
+	if cerr := transfers.check(path); err != nil && cerr != nil {
+		return "", "", cerr
+	}
+	if err == nil && conf.Verbose {
+		l.Printf("Uploaded file '%s' (from %s) with ID %s", leafName, path, id)
+	}
+	return id, sum, err
+}
+
+// SyncDirectory accepts a path to recursively upload to the Backend to the specified category,
 // returning any error that happens in the process.
 //
-// It creates a ".sync_finished" mark file in the directory upon finishing, and will return
-// an ErrorAlreadySynced directly if that mark is present.
-func SyncDirectory(reader *bufio.Reader, srv *drive.Service, path, category string) error {
+// It records the directory and everything in it in the state database upon finishing, and
+// will return an ErrorAlreadySynced directly if the directory is recorded there. If
+// C.Config.Incremental is true, a recorded directory gets re-synced instead: files that are
+// new or changed since the last sync are uploaded into the existing remote folder, and an
+// ErrorAlreadySynced is only returned if there's no such file. An ErrorCancelled is returned if
+// the sync gets cancelled; see Progress.
+//
+// If the layout of the category is C.LayoutAudioTags, the audio files in the directory are
+// uploaded into Artist/Album folders by their tags instead of mirroring the tree; see
+// planAudioLayout. The directories are recorded without remote IDs then.
+func SyncDirectory(reader *bufio.Reader, b Backend, path, category string) (err error) {
 	conf := C.Config.Get()
 	// trim the trailing slash
 	path = filepath.Clean(path)
-	markFilePath := path + "/.sync_finished"
-	// check if we have the mark file
-	if _, err := os.Stat(markFilePath); err == nil {
+	defer func() { countObject(category, err) }()
+	// check if we have synced the directory
+	rec, ok, err := S.Get(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to check sync state: %v", err))
+	} else if ok && !conf.Incremental {
+		if rec.Category != "" {
+			category = rec.Category
+		}
+		// the files in it count as already synced, as on an incremental sync finding no change
+		S.Walk(path, func(rec S.Record) error {
+			if !rec.IsDir {
+				files.Inc(category, "already-synced")
+			}
+			return nil
+		})
 		return E.ErrorAlreadySynced("folder already synced")
-	} else if !os.IsNotExist(err) {
-		return errors.New(fmt.Sprintf("failed to check sync mark: %v", err))
 	}
+	// manifest: key: path; value: record of the last sync
+	manifest := make(map[string]S.Record)
 	// parentIDs: key: path; value: parent ID
 	parentIDs := make(map[string]string)
+	if ok && rec.Category != "" {
+		category = rec.Category
+	}
+	var layout *audioLayout
+	if conf.Layout(category) == C.LayoutAudioTags {
+		layout = planAudioLayout(path)
+	}
+	if ok {
+		if rec.RemoteID == "" && layout == nil {
+			// imported from a mark file; find the remote folder by name
+			rec.RemoteID, err = getSyncedDirectory(reader, b, path, category)
+			if err != nil {
+				return err
+			}
+		}
+		err = S.Walk(path, func(rec S.Record) error {
+			manifest[rec.Path] = rec
+			if rec.IsDir && rec.RemoteID != "" {
+				parentIDs[rec.Path] = rec.RemoteID
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to read sync state: %v", err))
+		}
+		if rec.RemoteID != "" {
+			parentIDs[path] = rec.RemoteID
+		}
+	}
+	folders, err := uploadFolders(category, path)
+	if err != nil {
+		return err
+	}
+	records, uploaded, err := syncTree(reader, b, path, category, manifest, parentIDs, layout)
+	if _, ok := err.(E.ErrorCancelled); ok {
+		return err
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("failed to sync directory: %v", err))
+	}
+	for i := range records {
+		if records[i].Path == path {
+			records[i].Folders = folders
+		}
+	}
+	// mark the folder as already synced
+	err = S.PutAll(records)
+	if err != nil {
+		return E.ErrorSetMarkFailed(err.Error())
+	}
+	if ok && uploaded == 0 {
+		return E.ErrorAlreadySynced("folder already synced, with no changes since")
+	}
+	if conf.Verbose {
+		log.Printf("Sync completed for directory '%s' into category %s.", path, category)
+	}
+	return nil
+}
+
+// syncTree walks the directory at path, uploading what's not in manifest, or has changed since
+// the record in manifest was made, to the category. Remote folders that are known already are
+// given in parentIDs, and won't be looked up again. Files are placed by layout instead if it's
+// not nil.
+//
+// It returns the records to store for the walked paths, and the number of files uploaded. Once the
+// sync gets cancelled, the walk stops and an E.ErrorCancelled is returned; likewise, once an
+// upload fails, no more uploads are started and its error is returned after the ones in progress
+// finish.
+func syncTree(reader *bufio.Reader, b Backend, path, category string, manifest map[string]S.Record,
+	parentIDs map[string]string, layout *audioLayout) ([]S.Record, int, error) {
+	conf := C.Config.Get()
+	// for logging the folders created on the way
+	ctx := U.CtxWithLoggerID(context.Background(), fmt.Sprintf("%05x", rand.Uint32()%0xfffff))
 	var uploadWg sync.WaitGroup
+	// records of synced paths, to be stored upon finishing
+	var records []S.Record
+	var recordsLock sync.Mutex
+	var uploaded int
+	// cancelled is the error of the first upload cancelled, and failed the one of the first
+	// upload failed
+	var cancelled, failed error
 	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Printf("Error occured while visiting path %s: %v", path, err)
 			return err
 		}
-		if _, ok := C.IgnoreList[info.Name()]; ok {
+		if err := transfers.check(path); err != nil {
+			return err
+		}
+		recordsLock.Lock()
+		err = failed
+		recordsLock.Unlock()
+		if err != nil {
+			return err
+		}
+		if conf.Ignored(path) {
+			return nil
+		} else if strings.HasPrefix(info.Name(), S.MarkPrefix) {
 			return nil
-		} else if strings.HasPrefix(info.Name(), ".sync_finished") {
+		}
+		if id, ok := parentIDs[path]; ok && info.IsDir() {
+			// remote folder already known
+			recordsLock.Lock()
+			records = append(records, newRecord(path, info, id, "", category))
+			recordsLock.Unlock()
 			return nil
 		}
-		parentPath, _ := filepath.Split(path)
-		// trim the trailing slash
-		parentPath = filepath.Clean(parentPath)
-		parentID, ok := parentIDs[parentPath]
+		if rec, ok := manifest[path]; ok && !info.IsDir() {
+			if unchanged, err := fileUnchanged(rec, path, info); err != nil {
+				return err
+			} else if unchanged {
+				// keep the record up to date with what we've checked
+				rec.Size, rec.ModTime = info.Size(), info.ModTime()
+				files.Inc(category, "already-synced")
+				recordsLock.Lock()
+				records = append(records, rec)
+				recordsLock.Unlock()
+				return nil
+			}
+		}
+		if layout != nil && info.IsDir() {
+			// no remote folder mirrors the directory
+			recordsLock.Lock()
+			records = append(records, newRecord(path, info, "", "", category))
+			recordsLock.Unlock()
+			return nil
+		}
+		parentID, leafName, ok := "", info.Name(), false
+		if layout != nil {
+			if parentID, leafName, ok, err = layout.locate(ctx, reader, b, path, category); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			parentPath, _ := filepath.Split(path)
+			// trim the trailing slash
+			parentPath = filepath.Clean(parentPath)
+			parentID, ok = parentIDs[parentPath]
+		}
 		if !ok {
 			//log.Println("cache miss: ", parentPath)
 			// parent path not present; this is the root of folder to upload
-			parentID, err = getUploadLocation(reader, srv, category)
+			parentID, err = getUploadLocation(reader, b, category, path)
 			if err != nil {
 				return err
 			}
 		}
-		// prepare for worker goroutine
-		ctx := context.Background()
-		// routineID for logging
-		routineID := fmt.Sprintf("%05x", rand.Uint32()%0xfffff)
 		if info.IsDir() {
 			// createDirectoryWithCheck will check if file with the same name exists
 			id := new(string)
-			err := withRetry(U.CtxWithLoggerID(ctx, routineID), func() error {
+			err := withRetry(ctx, func() error {
 				var err error
-				*id, err = createDirectoryWithCheck(srv, info.Name(), parentID)
+				*id, err = createDirectoryWithCheck(b, info.Name(), parentID)
 				return err
 			}, retryIfNeeded)
 			if err != nil {
@@ -77,6 +269,9 @@ func SyncDirectory(reader *bufio.Reader, srv *drive.Service, path, category stri
 			} else {
 				// record parent entry
 				parentIDs[path] = *id
+				recordsLock.Lock()
+				records = append(records, newRecord(path, info, *id, "", category))
+				recordsLock.Unlock()
 				//log.Println("added parent map entry: ", path, id)
 				if conf.Verbose {
 					log.Printf("Created directory '%s' (from %s) with ID %s", info.Name(), path, *id)
@@ -84,82 +279,101 @@ func SyncDirectory(reader *bufio.Reader, srv *drive.Service, path, category stri
 			}
 		} else {
 			uploadWg.Add(1)
-			go func() {
+			uploadJob(func(ctx context.Context) {
 				defer uploadWg.Done()
-				// createFileWithCheck will check if file with the same name exists
-				id := new(string)
-				err := withRetry(U.CtxWithLoggerID(ctx, routineID), func() error {
-					var err error
-					*id, err = createFileWithCheck(srv, path, parentID)
-					return err
-				}, retryIfNeeded)
-				if err != nil {
-					log.Fatalf("Unexpected error while uploading file '%s' (from %s): %v", info.Name(), path, err)
+				recordsLock.Lock()
+				stop := failed != nil
+				recordsLock.Unlock()
+				if stop {
+					// queued before the failure
+					return
 				}
-				if conf.Verbose {
-					log.Printf("Uploaded file '%s' (from %s) with ID %s", info.Name(), path, *id)
+				id, sum, err := uploadFile(ctx, b, path, leafName, parentID)
+				countFile(category, err)
+				if _, ok := err.(E.ErrorCancelled); ok {
+					recordsLock.Lock()
+					cancelled = err
+					recordsLock.Unlock()
+					return
+				} else if err != nil {
+					recordsLock.Lock()
+					if failed == nil {
+						failed = errors.New(fmt.Sprintf("failed to upload file '%s': %v", path, err))
+					}
+					recordsLock.Unlock()
+					return
 				}
-			}()
+				rec := newRecord(path, info, id, sum, category)
+				recordsLock.Lock()
+				records = append(records, rec)
+				uploaded++
+				recordsLock.Unlock()
+			})
 		}
 		return nil
 	})
-	// wait for all goroutines to finish working
+	// wait for all uploads to finish
 	uploadWg.Wait()
-	if err != nil {
-		return errors.New(fmt.Sprintf("failed to sync directory: %v", err))
-	}
-	// mark the folder as already synced
-	_, err = os.Create(markFilePath)
-	if err != nil {
-		return E.ErrorSetMarkFailed(err.Error())
+	if err == nil && cancelled != nil {
+		err = cancelled
+	} else if err == nil && failed != nil {
+		err = failed
 	}
-	if conf.Verbose {
-		log.Printf("Sync completed for directory '%s' into category %s.", path, category)
-	}
-	return nil
+	return records, uploaded, err
 }
 
-// SyncFile accepts a path to upload to Google Drive to the specified category,
+// SyncFile accepts a path to upload to the Backend to the specified category,
 // returning any error that happens in the process.
 //
-// It creates a (".sync_finished-"+filepath.Base(path)) mark file in the directory containing
-// the file, and will return an ErrorAlreadySynced directly if that mark is present.
-func SyncFile(reader *bufio.Reader, srv *drive.Service, path, category string) error {
-	conf := C.Config.Get()
+// It records the file in the state database upon finishing, and will return an
+// ErrorAlreadySynced directly if the file is recorded there, or an ErrorCancelled if the upload
+// gets cancelled.
+func SyncFile(reader *bufio.Reader, b Backend, path, category string) (err error) {
 	// clean the path to avoid surprises
 	path = filepath.Clean(path)
-	parentPath, basename := filepath.Split(path)
-	if _, ok := C.IgnoreList[basename]; ok || strings.HasPrefix(basename, ".sync_finished") {
+	basename := filepath.Base(path)
+	if C.Config.Get().Ignored(path) || strings.HasPrefix(basename, S.MarkPrefix) {
 		// file to be ignored
 		log.Printf(`I: Useless file %q ignored for syncing.`, path)
 		return nil
 	}
-	markFilePath := parentPath + ".sync_finished-" + basename
-	// check if we have the mark file
-	if _, err := os.Stat(markFilePath); err == nil {
+	defer func() { countObject(category, err) }()
+	// check if we have synced the file
+	if _, ok, err := S.Get(path); err != nil {
+		return errors.New(fmt.Sprintf("failed to check sync state: %v", err))
+	} else if ok {
+		files.Inc(category, "already-synced")
 		return E.ErrorAlreadySynced("file already synced")
-	} else if !os.IsNotExist(err) {
-		return errors.New(fmt.Sprintf("failed to check sync mark: %v", err))
 	}
-	parentID, err := getUploadLocation(reader, srv, category)
+	info, err := os.Stat(path)
 	if err != nil {
-		return err
+		return errors.New(fmt.Sprintf("failed to stat file: %v", err))
 	}
-	ctx := context.Background()
-	routineID := fmt.Sprintf("%05x", rand.Uint32()%0xfffff)
-	id := new(string)
-	err = withRetry(U.CtxWithLoggerID(ctx, routineID), func() error {
-		var err error
-		*id, err = createFileWithCheck(srv, path, parentID)
+	folders, err := uploadFolders(category, path)
+	if err != nil {
 		return err
-	}, retryIfNeeded)
+	}
+	parentID, err := getUploadLocation(reader, b, category, path)
 	if err != nil {
-		log.Fatalf("Unexpected error while uploading file '%s' (from %s): %v", basename, path, err)
+		return err
 	}
-	if conf.Verbose {
-		log.Printf("Uploaded file '%s' (from %s) with ID %s", basename, path, *id)
+	var id, sum string
+	var uploadWg sync.WaitGroup
+	uploadWg.Add(1)
+	uploadJob(func(ctx context.Context) {
+		defer uploadWg.Done()
+		id, sum, err = uploadFile(ctx, b, path, basename, parentID)
+	})
+	uploadWg.Wait()
+	countFile(category, err)
+	if _, ok := err.(E.ErrorCancelled); ok {
+		return err
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("failed to upload file: %v", err))
 	}
-	_, err = os.Create(markFilePath)
+	rec := newRecord(path, info, id, sum, category)
+	rec.Folders = folders
+	err = S.Put(rec)
 	if err != nil {
 		return E.ErrorSetMarkFailed(err.Error())
 	}
@@ -167,11 +381,11 @@ func SyncFile(reader *bufio.Reader, srv *drive.Service, path, category string) e
 	return nil
 }
 
-// Sync accepts a path to an object, either a directory or a file, and uploads it to Google
-// Drive to the specified category, returning any error that happens in the process.
+// Sync accepts a path to an object, either a directory or a file, and uploads it to the
+// Backend to the specified category, returning any error that happens in the process.
 //
 // It calls the corresponding function (either `SyncDirectory` or `SyncFile`) for processing.
-func Sync(reader *bufio.Reader, srv *drive.Service, path, category string) error {
+func Sync(reader *bufio.Reader, b Backend, path, category string) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return errors.New(fmt.Sprintf("failed to open path: %v", err))
@@ -180,15 +394,59 @@ func Sync(reader *bufio.Reader, srv *drive.Service, path, category string) error
 		return errors.New(fmt.Sprintf("failed to stat path: %v", err))
 	} else {
 		if fi.IsDir() {
-			return SyncDirectory(reader, srv, path, category)
+			return SyncDirectory(reader, b, path, category)
 		} else {
-			return SyncFile(reader, srv, path, category)
+			return SyncFile(reader, b, path, category)
 		}
 	}
 }
 
 // SyncWithGuess accepts a C.Guesser and relevant arguments to call Sync, guessing the appropriate
-// category automatically.
-func SyncWithGuess(reader *bufio.Reader, srv *drive.Service, path string, guesser C.Guesser) error {
-	return Sync(reader, srv, path, guesser.Guess(filepath.Base(path)))
+// category automatically. A C.PathGuesser gets the full path to guess with.
+//
+// If C.Config.RecordInfohash is true, the infohash of the torrent the object was downloaded with
+// is recorded in the "infohash" property of the object on the Backend after syncing, provided
+// that the Backend is a PropertySetter and the .torrent file is in C.Config.TorrentDir.
+func SyncWithGuess(reader *bufio.Reader, b Backend, path string, guesser C.Guesser) error {
+	var err error
+	if g, ok := guesser.(C.PathGuesser); ok {
+		err = Sync(reader, b, path, g.GuessPath(path))
+	} else {
+		err = Sync(reader, b, path, guesser.Guess(filepath.Base(path)))
+	}
+	if err == nil && C.Config.Get().RecordInfohash {
+		if err := recordInfohash(b, path); err != nil {
+			log.Printf("W: Failed to record infohash of %q: %v", path, err)
+		}
+	}
+	return err
+}
+
+// recordInfohash sets the "infohash" property of the synced object at path.
+func recordInfohash(b Backend, path string) error {
+	conf := C.Config.Get()
+	p, ok := b.(PropertySetter)
+	if !ok || conf.TorrentDir == "" {
+		return nil
+	}
+	m, err := T.Find(conf.TorrentDir, path)
+	if err != nil || m == nil {
+		return err
+	}
+	rec, ok, err := S.Get(path)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("object not recorded in sync state")
+	} else if rec.RemoteID == "" {
+		// laid out by tags; there's no remote folder for the object
+		return nil
+	}
+	if err := p.SetProperties(rec.RemoteID, map[string]string{"infohash": m.InfoHash}); err != nil {
+		return err
+	}
+	if conf.Verbose {
+		log.Printf("Recorded infohash %s of %q.", m.InfoHash, path)
+	}
+	return nil
 }
@@ -0,0 +1,205 @@
+package remote
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+	S "github.com/KireinaHoro/DriveSync/state"
+)
+
+func TestSyncDirectoryUploadFails(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.MaxUploads = 1
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{
+		"Rel/a.flac": "aaa",
+		"Rel/b.flac": "bbb",
+		"Rel/c.flac": "ccc",
+	})
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Status: 404})
+	path := filepath.Join(src, "Rel")
+	err := SyncDirectory(nil, b, path, "Music")
+	if err == nil {
+		t.Fatal("failed upload not reported")
+	} else if _, ok := err.(E.ErrorAlreadySynced); ok {
+		t.Fatalf("failed upload reported as %v", err)
+	}
+	if n := s.Requests(drivetest.OpUpload); n != 1 {
+		t.Errorf("%d uploads, want none after the failed one", n)
+	}
+	if _, ok, _ := S.Get(path); ok {
+		t.Error("directory recorded as synced")
+	}
+	// the next sync completes it
+	s.ClearFaults()
+	if err := SyncDirectory(nil, b, path, "Music"); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	for _, name := range []string{"a.flac", "b.flac", "c.flac"} {
+		if _, ok := s.Resolve("archive", "Music", "Rel", name); !ok {
+			t.Errorf("%s not uploaded", name)
+		}
+	}
+}
+
+func TestSyncFileUploadFails(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "content"})
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Status: 404})
+	path := filepath.Join(src, "f.bin")
+	if err := SyncFile(nil, b, path, "Misc"); err == nil {
+		t.Fatal("failed upload not reported")
+	}
+	if _, ok, _ := S.Get(path); ok {
+		t.Error("file recorded as synced")
+	}
+}
+
+func TestSyncDirectoryKeepsTemplateFolders(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.CategoryPaths = map[string]string{"Backups": "{year}/{month}"}
+	conf.Incremental = true
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"Dump/a.sql": "aaa"})
+	path := filepath.Join(src, "Dump")
+	first := time.Date(2020, 1, 15, 0, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, first, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncDirectory(nil, b, path, "Backups"); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	// adding a file moves the modification time of the directory to now
+	writeFiles(t, src, map[string]string{"Dump/b.sql": "bbb"})
+	if err := SyncDirectory(nil, b, path, "Backups"); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if _, ok := s.Resolve("archive", "Backups", "2020", "01", "Dump", "b.sql"); !ok {
+		t.Error("new file not synced into the folders of the first sync")
+	}
+	if rec, _, _ := S.Get(path); strings.Join(rec.Folders, "/") != "2020/01" {
+		t.Errorf("recorded folders %v, want [2020 01]", rec.Folders)
+	}
+	report, err := Verify(b, path, "Backups")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if want := "archive/Backups/2020/01/Dump"; report.Remote != want {
+		t.Errorf("verified against %s, want %s", report.Remote, want)
+	}
+}
+
+func TestSyncDirectoryIncremental(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.Incremental = true
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{
+		"Rel/same.flac":    "same",
+		"Rel/touched.flac": "touched",
+		"Rel/changed.flac": "old",
+	})
+	path := filepath.Join(src, "Rel")
+	if err := SyncDirectory(nil, b, path, "Music"); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	before := s.Requests(drivetest.OpUpload)
+	later := time.Now().Add(time.Hour)
+	writeFiles(t, src, map[string]string{"Rel/changed.flac": "new content", "Rel/new.flac": "new"})
+	for _, name := range []string{"touched.flac", "changed.flac"} {
+		if err := os.Chtimes(filepath.Join(path, name), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SyncDirectory(nil, b, path, "Music"); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	// the file touched only is told unchanged by the md5Checksum recorded
+	if n := s.Requests(drivetest.OpUpload) - before; n != 2 {
+		t.Errorf("%d uploads, want the new and the changed file only", n)
+	}
+	for name, want := range map[string]string{
+		"same.flac":    "same",
+		"touched.flac": "touched",
+		"changed.flac": "new content",
+		"new.flac":     "new",
+	} {
+		id, ok := s.Resolve("archive", "Music", "Rel", name)
+		if !ok {
+			t.Errorf("%s not synced, or synced more than once", name)
+			continue
+		}
+		if got, _ := s.Content(id); string(got) != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+		rec, ok, _ := S.Get(filepath.Join(path, name))
+		if remoteSum, _ := b.GetChecksum(id); !ok || rec.RemoteID != id || rec.Md5Checksum != remoteSum {
+			t.Errorf("%s recorded as %+v, want ID %s and md5Checksum %s", name, rec, id, remoteSum)
+		}
+	}
+}
+
+func TestVerifyTargetArchiveRoot(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"video/Film/a.mkv": "aaa"})
+	conf := C.Config.Get()
+	conf.Targets = []C.TargetConfig{{Path: filepath.Join(src, "video"), ArchiveRoot: "video-archive"}}
+	C.Config.Set(conf)
+	path := filepath.Join(src, "video", "Film")
+	if err := SyncDirectory(nil, b, path, "Films"); err != nil {
+		t.Fatalf("SyncDirectory: %v", err)
+	}
+	if _, ok := s.Resolve("video-archive", "Films", "Film", "a.mkv"); !ok {
+		t.Fatal("not synced into the archive root of the target")
+	}
+	report, err := Verify(b, path, "Films")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.Remote != "video-archive/Films/Film" || len(report.Entries) != 0 {
+		t.Errorf("verified against %s, with problems %v", report.Remote, report.Entries)
+	}
+}
+
+func TestRecordInfohash(t *testing.T) {
+	s, b := newTestDrive(t)
+	src, torrents := tempDir(t), tempDir(t)
+	writeFiles(t, src, map[string]string{"Album/a.flac": "aaa", "Other/b.flac": "bbb"})
+	info := "d6:lengthi3e4:name5:Albume"
+	writeFiles(t, torrents, map[string]string{"album.torrent": "d8:announce12:http://t/ann4:info" + info + "e"})
+	conf := C.Config.Get()
+	conf.DefaultCategory = "Music"
+	conf.TorrentDir = torrents
+	conf.RecordInfohash = true
+	C.Config.Set(conf)
+	for _, name := range []string{"Album", "Other"} {
+		if err := SyncWithGuess(nil, b, filepath.Join(src, name), C.RulesGuesser); err != nil {
+			t.Fatalf("failed to sync %s: %v", name, err)
+		}
+	}
+	music, _ := s.Resolve("archive", "Music")
+	// SHA-1 of info
+	want := "c92d59d11ca30d1586629920d047d180d682919f"
+	for name, hash := range map[string]string{"Album": want, "Other": ""} {
+		folders := s.Find(name, music)
+		if len(folders) != 1 {
+			t.Fatalf("%d copies of %s, want 1", len(folders), name)
+		}
+		if got := folders[0].Properties["infohash"]; got != hash {
+			t.Errorf("infohash of %s is %q, want %q", name, got, hash)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+)
+
+// TrainGuesser trains a C.Model for C.LearningGuesser from the names of the objects in every
+// category folder under the archive root on the Backend, and saves it to
+// C.Config.GuesserModel. Categories with a path template or the C.LayoutAudioTags layout are left
+// out, as their folders hold the folders of the template or artists rather than objects.
+func TrainGuesser(b Backend) (*C.Model, error) {
+	conf := C.Config.Get()
+	rootID, ok := C.ArchiveRootIDs.Get(conf.ArchiveRootName)
+	if !ok {
+		var err error
+		rootID, err = b.GetLeafFromParent(conf.ArchiveRootName, b.RootID(), true)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to retrieve archive root '%s': %v",
+				conf.ArchiveRootName, err))
+		}
+	}
+	categories, err := b.ListChildren(rootID)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to list archive root: %v", err))
+	}
+	samples := make(map[string][]string)
+	for _, c := range categories {
+		if !c.IsDir {
+			continue
+		}
+		if conf.CategoryPaths[c.Name] != "" || conf.Layout(c.Name) == C.LayoutAudioTags {
+			if conf.Verbose {
+				log.Printf("Skipping category %s, whose objects aren't right under its folder.", c.Name)
+			}
+			continue
+		}
+		objects, err := b.ListChildren(c.ID)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to list category %s: %v", c.Name, err))
+		}
+		for _, o := range objects {
+			samples[c.Name] = append(samples[c.Name], o.Name)
+		}
+		if conf.Verbose {
+			log.Printf("Found %d object(s) in category %s.", len(objects), c.Name)
+		}
+	}
+	model := C.TrainModel(samples)
+	if err := model.Save(conf.GuesserModel); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to save model: %v", err))
+	}
+	return model, nil
+}
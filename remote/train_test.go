@@ -0,0 +1,28 @@
+package remote
+
+import (
+	"path/filepath"
+	"testing"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+)
+
+func TestTrainGuesserSkipsNestedCategories(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.GuesserModel = filepath.Join(tempDir(t), "model.json")
+	conf.CategoryPaths = map[string]string{"Backups": "{year}"}
+	conf.CategoryLayouts = map[string]string{"Music": C.LayoutAudioTags}
+	C.Config.Set(conf)
+	root := s.Mkdir("archive", "root")
+	s.Mkdir("2026", s.Mkdir("Backups", root))
+	s.Mkdir("Some Artist", s.Mkdir("Music", root))
+	s.Mkdir("Show.S01E01.1080p", s.Mkdir("TV", root))
+	model, err := TrainGuesser(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(model.Categories) != 1 || model.Categories["TV"] == nil {
+		t.Errorf("trained on %v, want TV only", model.Categories)
+	}
+}
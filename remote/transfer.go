@@ -0,0 +1,214 @@
+package remote
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	E "github.com/KireinaHoro/DriveSync/errors"
+)
+
+// A Transfer is an upload in progress.
+type Transfer struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Sent int64  `json:"sent"`
+}
+
+// Progress keeps track of the uploads of the files at or under a path, e.g. an object being
+// synced, and lets them be cancelled; see Track.
+type Progress struct {
+	root string
+	// done is the number of bytes of the finished uploads; guarded by transfers.m
+	done      int64
+	cancelled bool
+}
+
+// transferTable holds the uploads in progress, and the paths tracked.
+type transferTable struct {
+	m sync.Mutex
+	// c is broadcast when uploads get resumed or cancelled
+	c      *sync.Cond
+	paused bool
+	// files: key: local path; value: the upload of the file
+	files map[string]*Transfer
+	roots map[string]*Progress
+}
+
+// transfers holds the uploads to all Backends.
+var transfers = newTransferTable()
+
+func newTransferTable() *transferTable {
+	r := &transferTable{files: make(map[string]*Transfer), roots: make(map[string]*Progress)}
+	r.c = sync.NewCond(&r.m)
+	return r
+}
+
+// Track starts keeping track of the uploads of the files at or under root, until Stop is called.
+func Track(root string) *Progress {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	p := &Progress{root: filepath.Clean(root)}
+	transfers.roots[p.root] = p
+	return p
+}
+
+// Stop stops tracking the path, so that cancelling it doesn't affect later uploads.
+func (r *Progress) Stop() {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	if transfers.roots[r.root] == r {
+		delete(transfers.roots, r.root)
+	}
+}
+
+// Cancel makes the uploads of the files at or under the path fail with an E.ErrorCancelled, along
+// with the syncs running them, until Stop is called.
+func (r *Progress) Cancel() {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	r.cancelled = true
+	transfers.c.Broadcast()
+}
+
+// Sent returns the number of bytes uploaded so far, and the uploads in progress sorted by path.
+func (r *Progress) Sent() (int64, []Transfer) {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	sent := r.done
+	var ret []Transfer
+	for path, t := range transfers.files {
+		if under(r.root, path) {
+			sent += t.Sent
+			ret = append(ret, *t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return sent, ret
+}
+
+// PauseUploads holds all uploads: those in progress finish the request or chunk they are sending,
+// and no more requests or chunks are started until ResumeUploads is called.
+func PauseUploads() {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	transfers.paused = true
+}
+
+// ResumeUploads lets the uploads held by PauseUploads carry on.
+func ResumeUploads() {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	transfers.paused = false
+	transfers.c.Broadcast()
+}
+
+// UploadsPaused returns whether uploads are held by PauseUploads.
+func UploadsPaused() bool {
+	transfers.m.Lock()
+	defer transfers.m.Unlock()
+	return transfers.paused
+}
+
+// under returns whether path is root or inside it.
+func under(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// cancelled returns an E.ErrorCancelled if a tracked path containing path has been cancelled.
+// r.m must be held.
+func (r *transferTable) cancelled(path string) error {
+	for root, p := range r.roots {
+		if p.cancelled && under(root, path) {
+			return E.ErrorCancelled("sync of '" + root + "' cancelled")
+		}
+	}
+	return nil
+}
+
+// check returns an E.ErrorCancelled if the upload of the file at path has been cancelled.
+func (r *transferTable) check(path string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.cancelled(path)
+}
+
+// hold waits while uploads are paused, returning an E.ErrorCancelled if the upload of the file at
+// path has been cancelled. It's called before each request or chunk of the upload is started, so
+// that no request is left stalled in the middle of its body.
+func (r *transferTable) hold(path string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for r.paused && r.cancelled(path) == nil {
+		r.c.Wait()
+	}
+	return r.cancelled(path)
+}
+
+// start adds the upload of the file at path to the table.
+func (r *transferTable) start(path string) {
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.files[path] = &Transfer{Path: path, Size: size}
+}
+
+// finish removes the upload of the file at path from the table, counting it as done for the
+// tracked paths containing it if ok.
+func (r *transferTable) finish(path string, ok bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	t, found := r.files[path]
+	if !found {
+		return
+	}
+	delete(r.files, path)
+	if !ok {
+		return
+	}
+	for root, p := range r.roots {
+		if under(root, path) {
+			p.done += t.Size
+		}
+	}
+}
+
+// reader wraps rd, which reads the file at path from offset, so that the bytes read count as
+// sent, and reads fail once the upload is cancelled.
+func (r *transferTable) reader(path string, rd io.Reader, offset int64) io.Reader {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if t, ok := r.files[path]; ok {
+		t.Sent = offset
+	}
+	return &transferReader{r: rd, table: r, path: path}
+}
+
+type transferReader struct {
+	r     io.Reader
+	table *transferTable
+	path  string
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	t := r.table
+	if err := t.check(r.path); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		uploadedBytes.Add(float64(n))
+		t.m.Lock()
+		if f, ok := t.files[r.path]; ok {
+			f.Sent += int64(n)
+		}
+		t.m.Unlock()
+	}
+	return n, err
+}
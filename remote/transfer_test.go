@@ -0,0 +1,50 @@
+package remote
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+)
+
+func TestPauseHoldsChunks(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.UploadChunkSize = 1 << 20
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"big.bin": strings.Repeat("x", 3<<20)})
+	path := filepath.Join(src, "big.bin")
+	// the first chunk, after the request starting the session, is still being handled when
+	// uploads get paused
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Nth: 2, Latency: 200 * time.Millisecond})
+	p := Track(path)
+	defer p.Stop()
+	defer ResumeUploads()
+	errc := make(chan error, 1)
+	go func() { errc <- SyncFile(nil, b, path, "Misc") }()
+	for i := 0; s.Requests(drivetest.OpUpload) < 2; i++ {
+		if i == 100 {
+			t.Fatal("upload not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	PauseUploads()
+	time.Sleep(500 * time.Millisecond)
+	if sent, _ := p.Sent(); sent != 1<<20 {
+		t.Errorf("%d bytes sent while paused, want the chunk in flight", sent)
+	}
+	if n := s.Requests(drivetest.OpUpload) - 1; n != 1 {
+		t.Errorf("%d chunks sent while paused, want 1", n)
+	}
+	ResumeUploads()
+	if err := <-errc; err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if n := s.Requests(drivetest.OpUpload) - 1; n != 3 {
+		t.Errorf("%d chunks sent, want 3", n)
+	}
+}
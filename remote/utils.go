@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"mime"
 	"net"
 	"os"
 	"path/filepath"
@@ -13,11 +13,11 @@ import (
 	"time"
 
 	"golang.org/x/net/context"
-	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
 
 	C "github.com/KireinaHoro/DriveSync/config"
 	E "github.com/KireinaHoro/DriveSync/errors"
+	S "github.com/KireinaHoro/DriveSync/state"
 	U "github.com/KireinaHoro/DriveSync/utils"
 )
 
@@ -45,44 +45,20 @@ func yesNoResponse(reader *bufio.Reader, prompt string) bool {
 	}
 }
 
-// getLeafFromParent resolves the ID of the requested leaf in given folder ID.
-func getLeafFromParent(srv *drive.Service, leafName, parentID string, wantFolder bool) (string, error) {
-	var q []string
-	q = append(q, fmt.Sprintf("('%s' in parents)", parentID))
-	q = append(q, fmt.Sprintf("name='%s'", leafName))
-	if wantFolder {
-		q = append(q, fmt.Sprintf("mimeType='%s'", C.DriveFolderType))
-	} else {
-		q = append(q, fmt.Sprintf("mimeType!='%s'", C.DriveFolderType))
-	}
-	q = append(q, "trashed=false")
-	ansList, err := srv.Files.List().Q(strings.Join(q, "and")).Fields("files(id)").Do()
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("failed to fetch ID of '%s': %v", leafName, err))
-	} else if len(ansList.Files) == 0 {
-		return "", E.ErrorNotFound(fmt.Sprintf("error: no '%s' in '%s'", leafName, parentID))
-	} else if len(ansList.Files) > 1 {
-		// return an E.ErrorMultipleResults
-		var ret []string
-		for _, f := range ansList.Files {
-			ret = append(ret, f.Id)
-		}
-		return "", E.ErrorMultipleResults(ret)
-	}
-	return ansList.Files[0].Id, nil
-}
-
-// getUploadLocation resolves the folder ID of the given category.
-func getUploadLocation(reader *bufio.Reader, srv *drive.Service, category string) (string, error) {
-	conf := C.Config.Get()
+// getUploadLocation resolves the ID of the folder the object at path goes into in the given
+// category: the category folder, or the folder given by the path template of the category,
+// which is created if missing. An empty path resolves the category folder.
+func getUploadLocation(reader *bufio.Reader, b Backend, category, path string) (string, error) {
+	conf := C.Config.Get().ForPath(path)
 	var err error
 	// get the archive root
-	if C.ArchiveRootID == "" {
-		C.ArchiveRootID, err = getLeafFromParent(srv, conf.ArchiveRootName, "root", true)
+	rootID, ok := C.ArchiveRootIDs.Get(conf.ArchiveRootName)
+	if !ok {
+		rootID, err = b.GetLeafFromParent(conf.ArchiveRootName, b.RootID(), true)
 		if err != nil {
 			if _, ok := err.(E.ErrorNotFound); conf.CreateMissing || (ok &&
 				yesNoResponse(reader, "Archive root not found; create it now?")) {
-				C.ArchiveRootID, err = createDirectory(srv, conf.ArchiveRootName, "root")
+				rootID, err = b.CreateDirectory(conf.ArchiveRootName, b.RootID())
 				if err != nil {
 					return "", errors.New(fmt.Sprintf("failed to create archive root '%s': %v",
 						conf.ArchiveRootName, err))
@@ -95,15 +71,17 @@ func getUploadLocation(reader *bufio.Reader, srv *drive.Service, category string
 					conf.ArchiveRootName, err))
 			}
 		}
+		C.ArchiveRootIDs.Set(conf.ArchiveRootName, rootID)
 	}
 	// get the desired category
-	categoryID, ok := C.CategoryIDs.Get(category)
+	categoryKey := conf.ArchiveRootName + "/" + category
+	categoryID, ok := C.CategoryIDs.Get(categoryKey)
 	if !ok {
-		categoryID, err = getLeafFromParent(srv, category, C.ArchiveRootID, true)
+		categoryID, err = b.GetLeafFromParent(category, rootID, true)
 		if err != nil {
 			if _, ok := err.(E.ErrorNotFound); conf.CreateMissing || (ok &&
 				yesNoResponse(reader, fmt.Sprintf("Category '%s' not found; create it now?", category))) {
-				categoryID, err = createDirectory(srv, category, C.ArchiveRootID)
+				categoryID, err = b.CreateDirectory(category, rootID)
 				if err != nil {
 					return "", errors.New(fmt.Sprintf("failed to create category '%s': %v",
 						category, err))
@@ -116,31 +94,45 @@ func getUploadLocation(reader *bufio.Reader, srv *drive.Service, category string
 					category, err))
 			}
 		}
-		C.CategoryIDs.Set(category, categoryID)
+		C.CategoryIDs.Set(categoryKey, categoryID)
 	}
 	//fmt.Printf("Category folder ID: %s\n", categoryID)
-	return categoryID, nil
-}
-
-// createDirectory creates the directory with name leafName inside directory
-// with ID of parentID, returning the ID of the created folder.
-//
-// Note: the caller shall check if the directory with leafName exists.
-// Failing to do so will result in duplicate directories.
-func createDirectory(srv *drive.Service, leafName, parentID string) (string, error) {
-	createInfo := &drive.File{
-		Name:        leafName,
-		Description: leafName,
-		MimeType:    C.DriveFolderType,
-		Parents:     []string{parentID},
+	if path == "" {
+		return categoryID, nil
 	}
-	info, err := srv.Files.Create(createInfo).Fields("id").Do()
+	dirs, err := uploadFolders(category, path)
 	if err != nil {
-		//return "", errors.New(fmt.Sprintf("failed to create on Drive server: %v", err))
 		return "", err
-	} else {
-		return info.Id, nil
 	}
+	// resolve the folders of the template one by one, caching them like categories
+	parentID, key := categoryID, categoryKey
+	for _, v := range dirs {
+		key += "/" + v
+		if id, ok := C.PathIDs.Get(key); ok {
+			parentID = id
+			continue
+		}
+		parentID, err = createDirectoryWithCheck(b, v, parentID)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("failed to create folder '%s': %v", key, err))
+		}
+		C.PathIDs.Set(key, parentID)
+	}
+	return parentID, nil
+}
+
+// uploadFolders returns the folders the object at path goes into under the folder of category:
+// the ones recorded when it was synced into category, if it was, or the path template of
+// category expanded for it; see C.Config.ExpandPath. This way the dates of the template, which
+// change along with the object, keep pointing to where it went first.
+func uploadFolders(category, path string) ([]string, error) {
+	rec, ok, err := S.Get(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to check sync state: %v", err))
+	} else if ok && rec.Category == category && len(rec.Folders) > 0 {
+		return rec.Folders, nil
+	}
+	return C.Config.Get().ExpandPath(category, path)
 }
 
 // createDirectoryWithCheck checks if the directory with given name exists in given parentID.
@@ -148,11 +140,11 @@ func createDirectory(srv *drive.Service, leafName, parentID string) (string, err
 // will be created.
 //
 // This function is to eliminate the problem of duplicate files on remote.
-func createDirectoryWithCheck(srv *drive.Service, leafName, parentID string) (string, error) {
-	fileID, err := getLeafFromParent(srv, leafName, parentID, true)
+func createDirectoryWithCheck(b Backend, leafName, parentID string) (string, error) {
+	fileID, err := b.GetLeafFromParent(leafName, parentID, true)
 	if err != nil {
 		if _, ok := err.(E.ErrorNotFound); ok {
-			return createDirectory(srv, leafName, parentID)
+			return b.CreateDirectory(leafName, parentID)
 		} else {
 			return "", err
 		}
@@ -160,82 +152,13 @@ func createDirectoryWithCheck(srv *drive.Service, leafName, parentID string) (st
 	return fileID, nil
 }
 
-// createFile creates the file with path leafPath inside directory
-// with ID of parentID, uploads the contents of the file, and
-// returns the ID of the created file. If C.Config.ForceRecheck is true, it checks if the MD5
-// sums of remote and local matches.
-//
-// Note: the caller shall check if the file with leafName exists.
-// Failing to do so will result in duplicate files.
-func createFile(srv *drive.Service, leafPath, parentID string) (string, error) {
-	conf := C.Config.Get()
-	leafName := filepath.Base(leafPath)
-	uploadFile, err := os.Open(leafPath)
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("failed to open file '%s': %v", leafPath, err))
-	}
-	createInfo := &drive.File{
-		Name:        leafName,
-		Description: leafName,
-		MimeType:    mime.TypeByExtension(filepath.Ext(leafName)),
-		Parents:     []string{parentID},
-	}
-	//info, err := srv.Files.Create(createInfo).Media(uploadFile).Fields("id, md5Checksum").Do()
-	// we don't need the md5Checksum field if C.Config.ForceRecheck != true
-	intermediateCall := srv.Files.Create(createInfo).Media(uploadFile)
-	if conf.ForceRecheck {
-		intermediateCall = intermediateCall.Fields("id, md5Checksum")
-	} else {
-		intermediateCall = intermediateCall.Fields("id")
-	}
-	retVal, retErr := make(chan *drive.File), make(chan error)
-	go func() {
-		info, err := intermediateCall.Do()
-		retErr <- err
-		retVal <- info
-	}()
-	var info *drive.File
-	if conf.ForceRecheck {
-		f, err := os.Open(leafPath)
-		if err != nil {
-			return "", errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
-		}
-		defer f.Close()
-
-		// calculate the MD5 hash of the file
-		realSum, err := U.CalculateSum(f)
-		if err != nil {
-			return "", errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
-		}
-
-		err = <-retErr
-		if err != nil {
-			return "", err
-		}
-		info = <-retVal
-		if sum := info.Md5Checksum; sum != realSum {
-			return "", E.ErrorChecksumMismatch(fmt.Sprintf(
-				"md5Checksum mismatch: remote %s, local %s", sum, realSum))
-		}
-		//log.Printf("file '%s' has identical remote/local md5Checksum", leafPath)
-	} else {
-		err := <-retErr
-		if err != nil {
-			return "", err
-		}
-		info = <-retVal
-	}
-	return info.Id, nil
-}
-
 // createFileWithCheck checks if the file with given name exists in given parentID.
 // If such file exists, it will delete the existing file so that situation of duplicate files
-// won't occur.
+// won't occur. It returns the ID and the md5Checksum of the file on remote.
 //
 // This function is to eliminate the problem of duplicate files on remote.
-func createFileWithCheck(srv *drive.Service, leafPath, parentID string) (string, error) {
-	leafName := filepath.Base(leafPath)
-	fileID, err := getLeafFromParent(srv, leafName, parentID, false)
+func createFileWithCheck(b Backend, leafPath, leafName, parentID string) (string, string, error) {
+	fileID, err := b.GetLeafFromParent(leafName, parentID, false)
 	if err == nil {
 		// check file's checksum
 		f, err := os.Open(leafPath)
@@ -254,7 +177,7 @@ func createFileWithCheck(srv *drive.Service, leafPath, parentID string) (string,
 				}
 				goto AfterCheck
 			} else {
-				file, err := srv.Files.Get(fileID).Fields("md5Checksum").Do()
+				remoteSum, err := b.GetChecksum(fileID)
 				if err != nil {
 					// non-critical; log the failure and continue
 					if C.Verbose {
@@ -262,19 +185,19 @@ func createFileWithCheck(srv *drive.Service, leafPath, parentID string) (string,
 					}
 					goto AfterCheck
 				}
-				if realSum == file.Md5Checksum {
+				if realSum == remoteSum {
 					// we have identical copies of files
 					if C.Verbose {
 						log.Printf("File %q (%s) has identical remote and local versions, skipping re-upload.",
 							leafName, fileID)
 					}
-					return fileID, nil
+					return fileID, remoteSum, nil
 				}
 			}
 		}
 
 	AfterCheck:
-		err = srv.Files.Delete(fileID).Do()
+		err = b.Delete(fileID)
 		if err != nil {
 			if C.Verbose {
 				// non-critical; log the failure and continue
@@ -288,7 +211,7 @@ func createFileWithCheck(srv *drive.Service, leafPath, parentID string) (string,
 	} else if err, ok := err.(E.ErrorMultipleResults); ok {
 		// multiple results; we should delete all of them
 		for _, f := range err {
-			e := srv.Files.Delete(f).Do()
+			e := b.Delete(f)
 			if e != nil {
 				if C.Verbose {
 					// non-critical; log the failure and continue
@@ -301,61 +224,119 @@ func createFileWithCheck(srv *drive.Service, leafPath, parentID string) (string,
 			}
 		}
 	}
-	return createFile(srv, leafPath, parentID)
+	return b.CreateFile(leafPath, leafName, parentID)
 }
 
-// withRetry executes fn with retry upon failure in an exponential-backoff manner,
-// if the error returned by fn satisfies shouldRetry.
-func withRetry(ctx context.Context, fn func() error, shouldRetry func(error) bool) error {
-	conf := C.Config.Get()
-	l := U.GetLogger(ctx)
-	err := fn()
-	if shouldRetry(err) {
-		if conf.Verbose {
-			l.Printf("Need to retry due to: %v", err)
-		}
-		err = retry(ctx, fn, shouldRetry, C.RetryStartingRate, C.RetryRatio)
+// getSyncedDirectory resolves the ID of the remote folder a local directory has been synced to,
+// for records that don't carry it.
+func getSyncedDirectory(reader *bufio.Reader, b Backend, path, category string) (string, error) {
+	parentID, err := getUploadLocation(reader, b, category, path)
+	if err != nil {
+		return "", err
+	}
+	id, err := b.GetLeafFromParent(filepath.Base(path), parentID, true)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to find synced directory '%s': %v", path, err))
+	}
+	return id, nil
+}
+
+// fileUnchanged reports whether the file at path still has the content it had when rec was
+// made. The md5Checksum is only calculated if the file has been touched since.
+func fileUnchanged(rec S.Record, path string, info os.FileInfo) (bool, error) {
+	if rec.Size != info.Size() {
+		return false, nil
+	} else if rec.ModTime.Equal(info.ModTime()) {
+		return true, nil
+	} else if rec.Md5Checksum == "" {
+		return false, nil
 	}
+	f, err := os.Open(path)
 	if err != nil {
-		err = errors.New(fmt.Sprintf("[Job #%s] retry failed: %v", l, err))
+		return false, errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
 	}
-	return err
+	defer f.Close()
+	sum, err := U.CalculateSum(f)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("failed to calculate md5Checksum: %v", err))
+	}
+	return sum == rec.Md5Checksum, nil
 }
 
-// retry increases retry timeout by factor ratio every time until success, or if an error not
-// worth trying occurs.
-func retry(ctx context.Context, fn func() error, shouldRetry func(error) bool, currentRate, ratio int) error {
+// newRecord builds the state record of a path that has been synced to remoteID, with the
+// md5Checksum of the copy of files on remote.
+func newRecord(path string, info os.FileInfo, remoteID, md5Checksum, category string) S.Record {
+	rec := S.Record{
+		Path:     path,
+		IsDir:    info.IsDir(),
+		RemoteID: remoteID,
+		Category: category,
+		ModTime:  info.ModTime(),
+		SyncTime: time.Now(),
+	}
+	if !info.IsDir() {
+		rec.Size = info.Size()
+		rec.Md5Checksum = md5Checksum
+	}
+	return rec
+}
+
+// withRetry executes fn with retry upon failure in an exponential-backoff manner, if the error
+// returned by fn satisfies shouldRetry, up to C.Config.MaxRetries times. The error of the last
+// try is returned wrapped in an E.ErrorRetryFailed.
+func withRetry(ctx context.Context, fn func() error, shouldRetry func(error) bool) error {
 	conf := C.Config.Get()
 	l := U.GetLogger(ctx)
-	if conf.Verbose {
-		l.Printf("Waiting %d second(s) before retrying...", currentRate)
+	maxRetries := conf.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = C.MaxRetries
 	}
-	time.Sleep(time.Duration(currentRate) * time.Second)
+	currentRate := conf.RetryStartingRate
+	attempts := 1
 	err := fn()
-	if shouldRetry(err) {
-		err = retry(ctx, fn, shouldRetry, currentRate*ratio, ratio)
+	for ; shouldRetry(err) && attempts <= maxRetries; attempts++ {
+		countRetry(err)
+		if conf.Verbose {
+			l.Printf("Need to retry due to: %v", err)
+			l.Printf("Waiting %d second(s) before retrying...", currentRate)
+		}
+		time.Sleep(time.Duration(currentRate) * time.Second)
+		currentRate *= conf.RetryRatio
+		err = fn()
+	}
+	if err != nil {
+		return E.ErrorRetryFailed{Job: string(l), Attempts: attempts, Err: err}
 	}
-	return err
+	return nil
 }
 
 // retryIfNeeded takes an error, returning true if it's worth retrying.
 func retryIfNeeded(err error) bool {
+	return retryReason(err) != ""
+}
+
+// retryReason returns why err is worth retrying, out of "rate-limit", "server-error",
+// "checksum-mismatch", "session-expired" and "network", or an empty string if it's not.
+func retryReason(err error) string {
 	if err != nil {
 		if realErr, ok := err.(*googleapi.Error); ok {
 			// retry on rate limit and all server-side errors
 			if realErr.Code == 403 && strings.Contains(
 				strings.ToLower(realErr.Message), "rate limit") {
-				return true
+				return "rate-limit"
 			} else if 499 < realErr.Code && realErr.Code < 600 {
-				return true
+				return "server-error"
 			}
 		} else if _, ok := err.(E.ErrorChecksumMismatch); ok {
 			// retry on checksum mismatch
-			return true
-		} else if _, ok := err.(net.Error); ok {
+			return "checksum-mismatch"
+		} else if err == errSessionExpired {
+			// retry with a new upload session
+			return "session-expired"
+		} else if _, ok := err.(net.Error); ok || err == io.ErrUnexpectedEOF {
 			// retry on network problem
-			return true
+			return "network"
 		}
 	}
-	return false
+	return ""
 }
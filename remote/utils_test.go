@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	"github.com/KireinaHoro/DriveSync/remote/drivetest"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// uploadWithRetry uploads the file at path into parentID as createFileWithCheck under withRetry.
+func uploadWithRetry(b Backend, path, parentID string) error {
+	ctx := U.CtxWithLoggerID(context.Background(), "test")
+	return withRetry(ctx, func() error {
+		_, _, err := createFileWithCheck(b, path, filepath.Base(path), parentID)
+		return err
+	}, retryIfNeeded)
+}
+
+// checkSingleCopy fails t unless parentID holds exactly one file named name, with content want.
+func checkSingleCopy(t *testing.T, s *drivetest.Server, name, parentID string, want []byte) {
+	files := s.Find(name, parentID)
+	if len(files) != 1 {
+		t.Fatalf("%d copies of %s, want 1", len(files), name)
+	}
+	if got, _ := s.Content(files[0].Id); !bytes.Equal(got, want) {
+		t.Errorf("content of %s differs: %d bytes, want %d", name, len(got), len(want))
+	}
+}
+
+func TestWithRetryConverges(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		fault drivetest.Fault
+	}{
+		{"server error", drivetest.Fault{Nth: 2, Times: 2, Status: 500}},
+		{"rate limit", drivetest.Fault{Op: drivetest.OpUpload, Status: 403, Message: "User Rate Limit Exceeded"}},
+		{"bad checksum", drivetest.Fault{Op: drivetest.OpUpload, BadChecksum: true}},
+	} {
+		s, b := newTestDrive(t)
+		src := tempDir(t)
+		writeFiles(t, src, map[string]string{"f.bin": "content"})
+		dir := s.Mkdir("d", drivetest.RootID)
+		s.Inject(tc.fault)
+		if err := uploadWithRetry(b, filepath.Join(src, "f.bin"), dir); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		checkSingleCopy(t, s, "f.bin", dir, []byte("content"))
+	}
+}
+
+func TestWithRetryGivesUp(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.MaxRetries = 3
+	C.Config.Set(conf)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "content"})
+	dir := s.Mkdir("d", drivetest.RootID)
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Times: -1, Status: 503})
+	err := uploadWithRetry(b, filepath.Join(src, "f.bin"), dir)
+	failed, ok := err.(E.ErrorRetryFailed)
+	if !ok {
+		t.Fatalf("error %T, want E.ErrorRetryFailed: %v", err, err)
+	}
+	if failed.Attempts != 4 {
+		t.Errorf("gave up after %d attempts, want 4", failed.Attempts)
+	}
+	if apiErr, ok := failed.Err.(*googleapi.Error); !ok || apiErr.Code != 503 {
+		t.Errorf("last error %v, want the injected 503", failed.Err)
+	}
+	if n := s.Requests(drivetest.OpUpload); n != 4 {
+		t.Errorf("%d uploads, want 4", n)
+	}
+}
+
+func TestWithRetryNotRetried(t *testing.T) {
+	s, b := newTestDrive(t)
+	src := tempDir(t)
+	writeFiles(t, src, map[string]string{"f.bin": "content"})
+	dir := s.Mkdir("d", drivetest.RootID)
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Status: 404})
+	err := uploadWithRetry(b, filepath.Join(src, "f.bin"), dir)
+	if failed, ok := err.(E.ErrorRetryFailed); !ok || failed.Attempts != 1 {
+		t.Errorf("error %v, want failure after 1 attempt", err)
+	}
+	if n := s.Requests(drivetest.OpUpload); n != 1 {
+		t.Errorf("%d uploads, want 1", n)
+	}
+}
+
+func TestWithRetryDroppedUpload(t *testing.T) {
+	s, b := newTestDrive(t)
+	conf := C.Config.Get()
+	conf.UploadChunkSize = 256 << 10
+	C.Config.Set(conf)
+	src := tempDir(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 40<<10)
+	writeFiles(t, src, map[string]string{"f.bin": string(content)})
+	dir := s.Mkdir("d", drivetest.RootID)
+	// the second chunk; the first request creates the session
+	s.Inject(drivetest.Fault{Op: drivetest.OpUpload, Nth: 3, Drop: true})
+	if err := uploadWithRetry(b, filepath.Join(src, "f.bin"), dir); err != nil {
+		t.Fatal(err)
+	}
+	checkSingleCopy(t, s, "f.bin", dir, content)
+}
@@ -0,0 +1,268 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	C "github.com/KireinaHoro/DriveSync/config"
+	E "github.com/KireinaHoro/DriveSync/errors"
+	S "github.com/KireinaHoro/DriveSync/state"
+	U "github.com/KireinaHoro/DriveSync/utils"
+)
+
+// A VerifyProblem is a discrepancy between a local tree and its copy in the archive.
+type VerifyProblem string
+
+const (
+	// VerifyMissing is a local object with no copy in the archive.
+	VerifyMissing VerifyProblem = "missing"
+	// VerifyExtra is an object in the archive that's not in the local tree.
+	VerifyExtra VerifyProblem = "extra"
+	// VerifySizeMismatch is a file whose copy has a different size.
+	VerifySizeMismatch VerifyProblem = "size-mismatch"
+	// VerifyChecksumMismatch is a file whose copy has a different md5Checksum.
+	VerifyChecksumMismatch VerifyProblem = "checksum-mismatch"
+	// VerifyDuplicate is a local object with several copies of its name in the archive.
+	VerifyDuplicate VerifyProblem = "duplicate"
+)
+
+// A VerifyEntry is a discrepancy found by Verify.
+type VerifyEntry struct {
+	Problem VerifyProblem `json:"problem"`
+	// Path is the local path, if any.
+	Path string `json:"path,omitempty"`
+	// Remote is the path in the archive, starting with the archive root.
+	Remote     string   `json:"remote"`
+	RemoteIDs  []string `json:"remote-ids,omitempty"`
+	LocalSize  int64    `json:"local-size,omitempty"`
+	RemoteSize int64    `json:"remote-size,omitempty"`
+	LocalMd5   string   `json:"local-md5,omitempty"`
+	RemoteMd5  string   `json:"remote-md5,omitempty"`
+}
+
+// A VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Target   string `json:"target"`
+	Category string `json:"category"`
+	Remote   string `json:"remote"`
+	// Checked is the number of files compared.
+	Checked int           `json:"checked"`
+	Entries []VerifyEntry `json:"entries"`
+}
+
+// verifier compares a local tree with its copy on a Backend.
+type verifier struct {
+	b      Backend
+	ctx    context.Context
+	report *VerifyReport
+}
+
+// Verify compares the object at path with its copy in the archive, where Sync would have put it
+// in category: every file must have a copy of the same size and md5Checksum, and there must be
+// nothing else in the copied folders. The category recorded in the sync state takes precedence
+// over category, and the options of the target containing path, if any, over the global ones;
+// see C.Config.ForPath. The md5Checksum of every local file is calculated.
+//
+// Directories laid out with C.LayoutAudioTags can't be verified, as their copies don't mirror
+// them.
+func Verify(b Backend, path, category string) (*VerifyReport, error) {
+	path = filepath.Clean(path)
+	conf := C.Config.Get().ForPath(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to stat path: %v", err))
+	}
+	rec, ok, err := S.Get(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to check sync state: %v", err))
+	} else if ok && rec.Category != "" {
+		category = rec.Category
+	}
+	if ok && rec.IsDir && rec.RemoteID == "" && conf.Layout(category) == C.LayoutAudioTags {
+		return nil, errors.New(fmt.Sprintf("'%s' is laid out by audio tags", path))
+	}
+	dirs, err := uploadFolders(category, path)
+	if err != nil {
+		return nil, err
+	}
+	segments := append(append([]string{category}, dirs...), info.Name())
+	r := &verifier{
+		b:   b,
+		ctx: U.CtxWithLoggerID(context.Background(), fmt.Sprintf("%05x", rand.Uint32()%0xfffff)),
+		report: &VerifyReport{
+			Target:   path,
+			Category: category,
+			Remote:   conf.ArchiveRootName + "/" + strings.Join(segments, "/"),
+		},
+	}
+	var e Entry
+	var lookupErr error
+	err = withRetry(r.ctx, func() error {
+		e, lookupErr = resolvePathIn(b, conf.ArchiveRootName, strings.Join(segments, "/"))
+		switch lookupErr.(type) {
+		case E.ErrorNotFound, E.ErrorMultipleResults:
+			// answers rather than failures
+			return nil
+		}
+		return lookupErr
+	}, retryIfNeeded)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to find '%s': %v", r.report.Remote, err))
+	}
+	switch ids, ok := lookupErr.(E.ErrorMultipleResults); {
+	case ok:
+		r.add(VerifyEntry{Problem: VerifyDuplicate, Path: path, Remote: r.report.Remote, RemoteIDs: ids})
+	case lookupErr != nil || e.IsDir != info.IsDir():
+		r.add(VerifyEntry{Problem: VerifyMissing, Path: path, Remote: r.report.Remote})
+	case e.IsDir:
+		err = r.compareDir(path, r.report.Remote, e.ID)
+	default:
+		err = r.compareFile(path, info, r.report.Remote, e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.report, nil
+}
+
+func (r *verifier) add(e VerifyEntry) {
+	r.report.Entries = append(r.report.Entries, e)
+}
+
+// compareDir compares the local directory at path with the remote folder with given ID.
+func (r *verifier) compareDir(path, remote, id string) error {
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to read directory '%s': %v", path, err))
+	}
+	var entries []Entry
+	err = withRetry(r.ctx, func() error {
+		var err error
+		entries, err = r.b.ListChildren(id)
+		return err
+	}, retryIfNeeded)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to list '%s': %v", remote, err))
+	}
+	// byName: key: name, and "/" for folders; value: the remote objects of the name
+	byName := make(map[string][]Entry)
+	for _, v := range entries {
+		key := v.Name
+		if v.IsDir {
+			key += "/"
+		}
+		byName[key] = append(byName[key], v)
+	}
+	for _, fi := range infos {
+		childPath, childRemote := filepath.Join(path, fi.Name()), remote+"/"+fi.Name()
+		if C.Config.Get().Ignored(childPath) {
+			continue
+		} else if strings.HasPrefix(fi.Name(), S.MarkPrefix) {
+			continue
+		}
+		key := fi.Name()
+		if fi.IsDir() {
+			key += "/"
+		}
+		matches := byName[key]
+		delete(byName, key)
+		switch len(matches) {
+		case 0:
+			r.add(VerifyEntry{Problem: VerifyMissing, Path: childPath, Remote: childRemote})
+		case 1:
+			if fi.IsDir() {
+				err = r.compareDir(childPath, childRemote, matches[0].ID)
+			} else {
+				err = r.compareFile(childPath, fi, childRemote, matches[0])
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			var ids []string
+			for _, v := range matches {
+				ids = append(ids, v.ID)
+			}
+			r.add(VerifyEntry{Problem: VerifyDuplicate, Path: childPath, Remote: childRemote, RemoteIDs: ids})
+		}
+	}
+	// what's left has no local counterpart
+	var extra []string
+	for k := range byName {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		for _, v := range byName[k] {
+			r.add(VerifyEntry{Problem: VerifyExtra, Remote: remote + "/" + v.Name, RemoteIDs: []string{v.ID},
+				RemoteSize: v.Size})
+		}
+	}
+	return nil
+}
+
+// compareFile compares the local file at path with the remote file e.
+func (r *verifier) compareFile(path string, info os.FileInfo, remote string, e Entry) error {
+	r.report.Checked++
+	if info.Size() != e.Size {
+		r.add(VerifyEntry{Problem: VerifySizeMismatch, Path: path, Remote: remote, RemoteIDs: []string{e.ID},
+			LocalSize: info.Size(), RemoteSize: e.Size})
+		return nil
+	}
+	remoteSum := e.Md5Checksum
+	if remoteSum == "" {
+		err := withRetry(r.ctx, func() error {
+			var err error
+			remoteSum, err = r.b.GetChecksum(e.ID)
+			return err
+		}, retryIfNeeded)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to get checksum of '%s': %v", remote, err))
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to open file for checksum: %v", err))
+	}
+	defer f.Close()
+	localSum, err := U.CalculateSum(f)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to calculate md5Checksum of '%s': %v", path, err))
+	}
+	if localSum != remoteSum {
+		r.add(VerifyEntry{Problem: VerifyChecksumMismatch, Path: path, Remote: remote, RemoteIDs: []string{e.ID},
+			LocalMd5: localSum, RemoteMd5: remoteSum})
+	}
+	return nil
+}
+
+// WriteText writes the report to w in a human-readable form.
+func (r *VerifyReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Verified '%s' against '%s': %d file(s) checked, %d problem(s).\n", r.Target, r.Remote,
+		r.Checked, len(r.Entries))
+	for _, v := range r.Entries {
+		switch v.Problem {
+		case VerifyMissing:
+			fmt.Fprintf(w, "  %-18s %s (no %s)\n", v.Problem, v.Path, v.Remote)
+		case VerifyExtra:
+			fmt.Fprintf(w, "  %-18s %s (%s)\n", v.Problem, v.Remote, strings.Join(v.RemoteIDs, ", "))
+		case VerifySizeMismatch:
+			fmt.Fprintf(w, "  %-18s %s: %d bytes locally, %d in the archive\n", v.Problem, v.Path, v.LocalSize,
+				v.RemoteSize)
+		case VerifyChecksumMismatch:
+			fmt.Fprintf(w, "  %-18s %s: %s locally, %s in the archive\n", v.Problem, v.Path, v.LocalMd5,
+				v.RemoteMd5)
+		case VerifyDuplicate:
+			fmt.Fprintf(w, "  %-18s %s (%s)\n", v.Problem, v.Remote, strings.Join(v.RemoteIDs, ", "))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MarkPrefix is the name prefix of the mark files older versions of DriveSync created:
+// ".sync_finished" inside a synced directory, and (".sync_finished-"+basename) next to a
+// synced file.
+const MarkPrefix = ".sync_finished"
+
+// ImportMarks walks root for mark files, recording the paths they mark as synced. Each root
+// only gets imported once; later calls return immediately. If remove is true, the imported
+// mark files get deleted. It returns the number of paths imported.
+//
+// As the mark files carry no detail, the imported Records only hold what can be found
+// locally; RemoteID, Category and Md5Checksum are left empty.
+func ImportMarks(root string, remove bool) (int, error) {
+	s, err := get()
+	if err != nil {
+		return 0, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return 0, err
+	}
+	if v, err := s.get(importsBucket, []byte(root)); err != nil || v != nil {
+		return 0, err
+	}
+	var recs []Record
+	var marks []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("W: Failed to visit %q while importing sync marks: %v", path, err)
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, MarkPrefix) {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		var target string
+		if name == MarkPrefix {
+			target = dir
+		} else if strings.HasPrefix(name, MarkPrefix+"-") {
+			target = filepath.Join(dir, strings.TrimPrefix(name, MarkPrefix+"-"))
+		} else {
+			return nil
+		}
+		fi, err := os.Stat(target)
+		if err != nil {
+			// the synced object is gone; the mark is stale
+			marks = append(marks, path)
+			return nil
+		}
+		rec := Record{
+			Path:     target,
+			IsDir:    fi.IsDir(),
+			ModTime:  fi.ModTime(),
+			SyncTime: info.ModTime(),
+		}
+		if !fi.IsDir() {
+			rec.Size = fi.Size()
+		}
+		recs = append(recs, rec)
+		marks = append(marks, path)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("failed to walk %q: %v", root, err))
+	}
+	if err := PutAll(recs); err != nil {
+		return 0, err
+	}
+	err = s.put(importsBucket, []Pair{{Key: root, Value: []byte(time.Now().Format(time.RFC3339))}})
+	if err != nil {
+		return 0, err
+	}
+	if remove {
+		for _, v := range marks {
+			if err := os.Remove(v); err != nil {
+				log.Printf("W: Failed to remove sync mark %q: %v", v, err)
+			}
+		}
+	}
+	return len(recs), nil
+}
+
+// Forget removes the Records of path and the paths under it, along with the mark files older
+// versions of DriveSync left for path, so that it gets synced again.
+func Forget(path string) error {
+	path = filepath.Clean(path)
+	if err := Delete(path); err != nil {
+		return err
+	}
+	for _, v := range []string{filepath.Join(path, MarkPrefix),
+		filepath.Join(filepath.Dir(path), MarkPrefix+"-"+filepath.Base(path))} {
+		if fi, err := os.Lstat(v); err != nil || fi.IsDir() {
+			continue
+		}
+		if err := os.Remove(v); err != nil {
+			return errors.New(fmt.Sprintf("failed to remove mark file '%s': %v", v, err))
+		}
+	}
+	return nil
+}
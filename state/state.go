@@ -0,0 +1,249 @@
+// Package state keeps track of what has been synced, in a BoltDB database stored next to the
+// configuration file. It replaces the ".sync_finished" mark files that used to be dropped into
+// synced directories.
+//
+// The database is held open by a single process, e.g. a running `drivesyncd`; others reach it
+// through that process, see OpenVia.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pathsBucket   = []byte("paths")
+	importsBucket = []byte("imports")
+	uploadsBucket = []byte("uploads")
+)
+
+// Record describes a local path that has been synced. Size and Md5Checksum are only set for
+// files.
+type Record struct {
+	Path        string    `json:"path"`
+	IsDir       bool      `json:"is-dir"`
+	RemoteID    string    `json:"remote-id"`
+	Category    string    `json:"category"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	Md5Checksum string    `json:"md5"`
+	SyncTime    time.Time `json:"sync-time"`
+	// Record.Folders are the folders the path template of the category put a synced object in,
+	// under the category folder; see C.Config.ExpandPath
+	Folders []string `json:"folders,omitempty"`
+}
+
+// openTimeout bounds the wait for the lock of the database held by another process.
+const openTimeout = time.Second
+
+// ErrInUse is returned by Open if another process holds the database open, e.g. a running
+// `drivesyncd`; see OpenVia.
+var ErrInUse = errors.New("state database in use by another process")
+
+// A Pair is a key in a bucket of the database, with its value.
+type Pair struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// store is where the sync state is kept: the database held open by this process, or another
+// process holding it.
+type store interface {
+	// get returns the value of key in bucket, or nil if there's none.
+	get(bucket, key []byte) ([]byte, error)
+	// put stores pairs into bucket in a single transaction; a Pair with a nil Value removes its
+	// key.
+	put(bucket []byte, pairs []Pair) error
+	// scan returns the pairs in bucket whose key starts with prefix, in lexical order.
+	scan(bucket, prefix []byte) ([]Pair, error)
+}
+
+// dbStore is the database file, held open for the life of the process.
+type dbStore struct {
+	db *bolt.DB
+}
+
+var (
+	// current is where the operations on the sync state go
+	current store
+	// opened is the database opened by Open, if any
+	opened *dbStore
+)
+
+// Open opens the state database at path for the life of the process, creating it if missing.
+// It returns ErrInUse if another process holds the database.
+func Open(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err == bolt.ErrTimeout {
+		return ErrInUse
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("failed to open state database %q: %v", path, err))
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, v := range [][]byte{pathsBucket, importsBucket, uploadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return errors.New(fmt.Sprintf("failed to set up state database %q: %v", path, err))
+	}
+	opened = &dbStore{db: db}
+	current = opened
+	return nil
+}
+
+// Close closes the state database opened by Open, letting other processes open it.
+func Close() error {
+	if opened == nil {
+		return nil
+	}
+	r := opened
+	opened, current = nil, nil
+	return r.db.Close()
+}
+
+func (r *dbStore) get(bucket, key []byte) ([]byte, error) {
+	var ret []byte
+	err := r.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get(key); v != nil {
+			ret = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (r *dbStore) put(bucket []byte, pairs []Pair) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for _, v := range pairs {
+			var err error
+			if v.Value == nil {
+				err = b.Delete([]byte(v.Key))
+			} else {
+				err = b.Put([]byte(v.Key), v.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *dbStore) scan(bucket, prefix []byte) ([]Pair, error) {
+	var ret []Pair
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			ret = append(ret, Pair{Key: string(k), Value: append([]byte(nil), v...)})
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func get() (store, error) {
+	if current == nil {
+		return nil, errors.New("state database not opened")
+	}
+	return current, nil
+}
+
+// Get returns the Record of given path, and whether there is one.
+func Get(path string) (Record, bool, error) {
+	var rec Record
+	s, err := get()
+	if err != nil {
+		return rec, false, err
+	}
+	v, err := s.get(pathsBucket, []byte(filepath.Clean(path)))
+	if err != nil || v == nil {
+		return rec, false, err
+	}
+	return rec, true, json.Unmarshal(v, &rec)
+}
+
+// Put stores rec, replacing the existing Record of the same path.
+func Put(rec Record) error {
+	return PutAll([]Record{rec})
+}
+
+// PutAll stores all of recs in a single transaction.
+func PutAll(recs []Record) error {
+	s, err := get()
+	if err != nil {
+		return err
+	}
+	var pairs []Pair
+	for _, rec := range recs {
+		rec.Path = filepath.Clean(rec.Path)
+		v, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, Pair{Key: rec.Path, Value: v})
+	}
+	return s.put(pathsBucket, pairs)
+}
+
+// Delete removes the Record of given path, along with the Records of paths under it.
+func Delete(path string) error {
+	s, err := get()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	under, err := s.scan(pathsBucket, []byte(path+"/"))
+	if err != nil {
+		return err
+	}
+	pairs := []Pair{{Key: path}}
+	for _, v := range under {
+		pairs = append(pairs, Pair{Key: v.Key})
+	}
+	return s.put(pathsBucket, pairs)
+}
+
+// Walk calls fn for every Record of path and the paths under it, in lexical order.
+// An empty path walks all Records.
+func Walk(path string, fn func(rec Record) error) error {
+	s, err := get()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	if path == "/" {
+		path = ""
+	}
+	pairs, err := s.scan(pathsBucket, []byte(path))
+	if err != nil {
+		return err
+	}
+	for _, v := range pairs {
+		// skip siblings sharing the prefix, e.g. "/a/bc" when walking "/a/b"
+		if path != "" && len(v.Key) > len(path) && v.Key[len(path)] != '/' {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(v.Value, &rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
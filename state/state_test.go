@@ -0,0 +1,279 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openTest opens a new state database, closed at the end of the test, returning its path.
+func openTest(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "drivesync-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "state.db")
+	if err := Open(path); err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	t.Cleanup(func() {
+		Close()
+		os.RemoveAll(dir)
+	})
+	return path
+}
+
+// paths returns the paths walked from path.
+func paths(t *testing.T, path string) []string {
+	var ret []string
+	err := Walk(path, func(rec Record) error {
+		ret = append(ret, rec.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %q: %v", path, err)
+	}
+	return ret
+}
+
+func TestPutGet(t *testing.T) {
+	openTest(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := Record{Path: "/data/a/", RemoteID: "id", Category: "Music", Size: 3, ModTime: mtime,
+		Md5Checksum: "sum", Folders: []string{"2020"}}
+	if err := Put(rec); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	got, ok, err := Get("/data/a")
+	rec.Path = "/data/a"
+	if err != nil || !ok || !reflect.DeepEqual(got, rec) {
+		t.Errorf("Get = %+v, %v, %v; want %+v", got, ok, err, rec)
+	}
+	if _, ok, err := Get("/data/b"); ok || err != nil {
+		t.Errorf("Get of a path not recorded = %v, %v", ok, err)
+	}
+	rec.Category = "Misc"
+	if err := PutAll([]Record{rec, {Path: "/data/b"}}); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if got, _, _ := Get("/data/a"); got.Category != "Misc" {
+		t.Errorf("record not replaced: %+v", got)
+	}
+	if _, ok, _ := Get("/data/b"); !ok {
+		t.Error("second record not stored")
+	}
+}
+
+func TestWalkDelete(t *testing.T) {
+	openTest(t)
+	var recs []Record
+	for _, v := range []string{"/a/b", "/a/b/c", "/a/b/c/d", "/a/bc", "/a/b.flac", "/b"} {
+		recs = append(recs, Record{Path: v})
+	}
+	if err := PutAll(recs); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path string
+		want []string
+	}{
+		{"/a/b", []string{"/a/b", "/a/b/c", "/a/b/c/d"}},
+		{"/a/b/", []string{"/a/b", "/a/b/c", "/a/b/c/d"}},
+		{"/a/bc", []string{"/a/bc"}},
+		{"/a", []string{"/a/b", "/a/b.flac", "/a/b/c", "/a/b/c/d", "/a/bc"}},
+		{"/c", nil},
+		{"", []string{"/a/b", "/a/b.flac", "/a/b/c", "/a/b/c/d", "/a/bc", "/b"}},
+		{"/", []string{"/a/b", "/a/b.flac", "/a/b/c", "/a/b/c/d", "/a/bc", "/b"}},
+	} {
+		if got := paths(t, c.path); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("walking %q gives %v, want %v", c.path, got, c.want)
+		}
+	}
+	if err := Delete("/a/b"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got, want := paths(t, ""), []string{"/a/b.flac", "/a/bc", "/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("left %v after deleting, want %v", got, want)
+	}
+}
+
+// writeFile creates the file at path with content, and the directories leading to it.
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportMarks(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		openTest(t)
+		root, err := ioutil.TempDir("", "drivesync-marks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		writeFile(t, filepath.Join(root, "Album", "a.flac"), "aaa")
+		writeFile(t, filepath.Join(root, "Album", MarkPrefix), "")
+		writeFile(t, filepath.Join(root, "f.iso"), "iso")
+		writeFile(t, filepath.Join(root, MarkPrefix+"-f.iso"), "")
+		// the object is gone
+		writeFile(t, filepath.Join(root, MarkPrefix+"-gone.iso"), "")
+		writeFile(t, filepath.Join(root, "Unsynced", "b.flac"), "bbb")
+
+		n, err := ImportMarks(root, remove)
+		if err != nil || n != 2 {
+			t.Fatalf("ImportMarks = %d, %v; want 2 paths imported", n, err)
+		}
+		if rec, ok, _ := Get(filepath.Join(root, "Album")); !ok || !rec.IsDir {
+			t.Errorf("directory not imported: %+v", rec)
+		}
+		if rec, ok, _ := Get(filepath.Join(root, "f.iso")); !ok || rec.IsDir || rec.Size != 3 {
+			t.Errorf("file not imported: %+v", rec)
+		}
+		for _, v := range []string{"Unsynced", "gone.iso"} {
+			if _, ok, _ := Get(filepath.Join(root, v)); ok {
+				t.Errorf("%s imported", v)
+			}
+		}
+		for _, v := range []string{"Album/" + MarkPrefix, MarkPrefix + "-f.iso", MarkPrefix + "-gone.iso"} {
+			if _, err := os.Stat(filepath.Join(root, v)); os.IsNotExist(err) != remove {
+				t.Errorf("mark %s removed: %v, want %v", v, os.IsNotExist(err), remove)
+			}
+		}
+		// only once
+		if err := Delete(filepath.Join(root, "f.iso")); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := ImportMarks(root, remove); n != 0 || err != nil {
+			t.Errorf("second ImportMarks = %d, %v; want nothing imported", n, err)
+		}
+		Close()
+	}
+}
+
+func TestForget(t *testing.T) {
+	openTest(t)
+	root, err := ioutil.TempDir("", "drivesync-marks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir, file := filepath.Join(root, "Album"), filepath.Join(root, "f.iso")
+	writeFile(t, filepath.Join(dir, MarkPrefix), "")
+	writeFile(t, filepath.Join(root, MarkPrefix+"-f.iso"), "")
+	writeFile(t, file, "iso")
+	err = PutAll([]Record{{Path: dir}, {Path: filepath.Join(dir, "a.flac")}, {Path: file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{dir, file} {
+		if err := Forget(v); err != nil {
+			t.Fatalf("failed to forget %q: %v", v, err)
+		}
+	}
+	if left := paths(t, ""); len(left) != 0 {
+		t.Errorf("records left: %v", left)
+	}
+	for _, v := range []string{filepath.Join(dir, MarkPrefix), filepath.Join(root, MarkPrefix+"-f.iso")} {
+		if _, err := os.Stat(v); !os.IsNotExist(err) {
+			t.Errorf("mark %s left", v)
+		}
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("forgotten file removed: %v", err)
+	}
+}
+
+func TestUploads(t *testing.T) {
+	openTest(t)
+	u := Upload{Path: "/data/big.iso", ParentID: "parent", SessionURI: "https://upload/1", Size: 100,
+		Offset: 50, StartTime: time.Now().Add(-time.Hour)}
+	if err := PutUpload(u); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	got, ok, err := GetUpload("/data/big.iso")
+	if err != nil || !ok || got.SessionURI != u.SessionURI || got.Offset != 50 ||
+		!got.StartTime.Equal(u.StartTime) {
+		t.Errorf("GetUpload = %+v, %v, %v; want %+v", got, ok, err, u)
+	}
+	if got.Expired(2 * time.Hour) {
+		t.Error("session started an hour ago expired within two")
+	}
+	if !got.Expired(time.Hour / 2) {
+		t.Error("session started an hour ago not expired after half of one")
+	}
+	if err := DeleteUpload("/data/big.iso"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, ok, err := GetUpload("/data/big.iso"); ok || err != nil {
+		t.Errorf("GetUpload after deleting = %v, %v", ok, err)
+	}
+}
+
+func TestOpenInUse(t *testing.T) {
+	path := openTest(t)
+	// as if by another process; the lock is taken per open file
+	if err := Open(path); err != ErrInUse {
+		t.Errorf("second Open = %v, want ErrInUse", err)
+	}
+}
+
+func TestOpenVia(t *testing.T) {
+	openTest(t)
+	if err := Put(Record{Path: "/a/b", Category: "Music"}); err != nil {
+		t.Fatal(err)
+	}
+	db := current
+	defer func() { current = db }()
+	var ops []string
+	// through JSON, as over the control socket
+	OpenVia(func(req Request) (Response, error) {
+		ops = append(ops, req.Op)
+		data, err := json.Marshal(req)
+		if err != nil {
+			return Response{}, err
+		}
+		var sent Request
+		if err := json.Unmarshal(data, &sent); err != nil {
+			return Response{}, err
+		}
+		resp := Serve(sent)
+		data, err = json.Marshal(resp)
+		if err != nil {
+			return Response{}, err
+		}
+		var got Response
+		return got, json.Unmarshal(data, &got)
+	})
+	if rec, ok, err := Get("/a/b"); err != nil || !ok || rec.Category != "Music" {
+		t.Errorf("Get = %+v, %v, %v", rec, ok, err)
+	}
+	if err := PutAll([]Record{{Path: "/a/b/c"}, {Path: "/a/bc"}}); err != nil {
+		t.Errorf("failed to put: %v", err)
+	}
+	if got, want := paths(t, "/a/b"), []string{"/a/b", "/a/b/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+	if err := Delete("/a/b"); err != nil {
+		t.Errorf("failed to delete: %v", err)
+	}
+	if _, ok, err := Get("/a/b/c"); ok || err != nil {
+		t.Errorf("Get after deleting = %v, %v", ok, err)
+	}
+	if want := []string{"get", "put", "scan", "scan", "put", "get"}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("operations %v, want %v", ops, want)
+	}
+	if resp := Serve(Request{Op: "drop", Bucket: "paths"}); resp.Error == "" {
+		t.Error("unknown operation carried out")
+	}
+	if resp := Serve(Request{Op: "get", Bucket: "secrets"}); resp.Error == "" {
+		t.Error("unknown bucket read")
+	}
+}
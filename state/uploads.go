@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// Upload describes a resumable upload in progress, so that it can be resumed after a restart.
+type Upload struct {
+	Path       string    `json:"path"`
+	ParentID   string    `json:"parent-id"`
+	SessionURI string    `json:"session-uri"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	Offset     int64     `json:"offset"`
+	StartTime  time.Time `json:"start-time"`
+}
+
+// Expired returns whether the upload session is older than lifetime, after which the server may
+// have dropped it.
+func (r Upload) Expired(lifetime time.Duration) bool {
+	return time.Since(r.StartTime) >= lifetime
+}
+
+// GetUpload returns the Upload of given path, and whether there is one.
+func GetUpload(path string) (Upload, bool, error) {
+	var u Upload
+	s, err := get()
+	if err != nil {
+		return u, false, err
+	}
+	v, err := s.get(uploadsBucket, []byte(filepath.Clean(path)))
+	if err != nil || v == nil {
+		return u, false, err
+	}
+	return u, true, json.Unmarshal(v, &u)
+}
+
+// PutUpload stores u, replacing the existing Upload of the same path.
+func PutUpload(u Upload) error {
+	s, err := get()
+	if err != nil {
+		return err
+	}
+	u.Path = filepath.Clean(u.Path)
+	v, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return s.put(uploadsBucket, []Pair{{Key: u.Path, Value: v}})
+}
+
+// DeleteUpload removes the Upload of given path.
+func DeleteUpload(path string) error {
+	s, err := get()
+	if err != nil {
+		return err
+	}
+	return s.put(uploadsBucket, []Pair{{Key: filepath.Clean(path)}})
+}
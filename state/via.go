@@ -0,0 +1,102 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Request is an operation on the sync state, sent by a process that can't open the database to
+// the one holding it open; see OpenVia and Serve.
+type Request struct {
+	// Op is "get", "put" or "scan"
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	// Key is the key to get, or the prefix to scan
+	Key   string `json:"key,omitempty"`
+	Pairs []Pair `json:"pairs,omitempty"`
+}
+
+// A Response is the result of a Request.
+type Response struct {
+	Error string `json:"error,omitempty"`
+	// Value is the value got, nil if there's none
+	Value []byte `json:"value,omitempty"`
+	Pairs []Pair `json:"pairs,omitempty"`
+}
+
+// buckets are the buckets of the database by name, for Requests.
+var buckets = map[string][]byte{
+	string(pathsBucket):   pathsBucket,
+	string(importsBucket): importsBucket,
+	string(uploadsBucket): uploadsBucket,
+}
+
+// Serve carries out req on the database opened by Open, for another process that reached it by
+// OpenVia.
+func Serve(req Request) Response {
+	var resp Response
+	s := opened
+	if s == nil {
+		resp.Error = "state database not opened"
+		return resp
+	}
+	bucket, ok := buckets[req.Bucket]
+	if !ok {
+		resp.Error = fmt.Sprintf("unknown bucket: %s", req.Bucket)
+		return resp
+	}
+	var err error
+	switch req.Op {
+	case "get":
+		resp.Value, err = s.get(bucket, []byte(req.Key))
+	case "put":
+		err = s.put(bucket, req.Pairs)
+	case "scan":
+		resp.Pairs, err = s.scan(bucket, []byte(req.Key))
+	default:
+		err = errors.New(fmt.Sprintf("unknown operation: %s", req.Op))
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// viaStore is the database held open by another process, which carries out the Requests given to
+// call with Serve.
+type viaStore struct {
+	call func(req Request) (Response, error)
+}
+
+// OpenVia has the sync state kept by another process holding the database open, e.g. when Open
+// returns ErrInUse: the operations are passed to call as Requests, for the process to carry out
+// with Serve.
+func OpenVia(call func(req Request) (Response, error)) {
+	current = &viaStore{call: call}
+}
+
+// do passes req to r.call, returning the error of either.
+func (r *viaStore) do(req Request) (Response, error) {
+	resp, err := r.call(req)
+	if err != nil {
+		return resp, err
+	} else if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (r *viaStore) get(bucket, key []byte) ([]byte, error) {
+	resp, err := r.do(Request{Op: "get", Bucket: string(bucket), Key: string(key)})
+	return resp.Value, err
+}
+
+func (r *viaStore) put(bucket []byte, pairs []Pair) error {
+	_, err := r.do(Request{Op: "put", Bucket: string(bucket), Pairs: pairs})
+	return err
+}
+
+func (r *viaStore) scan(bucket, prefix []byte) ([]Pair, error) {
+	resp, err := r.do(Request{Op: "scan", Bucket: string(bucket), Key: string(prefix)})
+	return resp.Pairs, err
+}
@@ -0,0 +1,177 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"unicode/utf16"
+)
+
+// syncsafe decodes a 28-bit syncsafe integer of ID3v2.
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7f)<<21 | int64(b[1]&0x7f)<<14 | int64(b[2]&0x7f)<<7 | int64(b[3]&0x7f)
+}
+
+// resync undoes the unsynchronisation of data, which has a zero byte follow every 0xff.
+func resync(data []byte) []byte {
+	return bytes.Replace(data, []byte{0xff, 0x00}, []byte{0xff}, -1)
+}
+
+// skipID3 skips the ID3v2 tag at the current position, whose first 4 bytes have been read.
+func skipID3(f *os.File) error {
+	rest := make([]byte, 6)
+	if _, err := io.ReadFull(f, rest); err != nil {
+		return err
+	}
+	_, err := f.Seek(syncsafe(rest[2:]), io.SeekCurrent)
+	return err
+}
+
+// readID3 reads the ID3v2 tag at the start of an MP3 file.
+func readID3(f *os.File) (*Tags, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, ErrorNoTags
+	}
+	version, flags, size := header[3], header[5], syncsafe(header[6:])
+	if version < 2 || version > 4 {
+		return nil, ErrorNoTags
+	} else if size > maxTagSize {
+		return nil, errors.New("tag too large")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		// unsynchronisation of the whole tag; that of ID3v2.4 is undone frame by frame
+		data = resync(data)
+	}
+	if flags&0x40 != 0 && version >= 3 {
+		// skip the extended header
+		if len(data) < 4 {
+			return nil, errors.New("truncated extended header")
+		}
+		n := int64(binary.BigEndian.Uint32(data))
+		if version == 4 {
+			n = syncsafe(data)
+		} else {
+			// the size excludes itself in ID3v2.3
+			n += 4
+		}
+		if n > int64(len(data)) {
+			return nil, errors.New("truncated extended header")
+		}
+		data = data[n:]
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	t := &Tags{}
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+		var n int64
+		switch version {
+		case 2:
+			n = int64(data[3])<<16 | int64(data[4])<<8 | int64(data[5])
+		case 3:
+			n = int64(binary.BigEndian.Uint32(data[4:8]))
+		case 4:
+			n = syncsafe(data[4:8])
+		}
+		if n > int64(len(data)-headerLen) {
+			break
+		}
+		frame, frameFlags := data[headerLen:int64(headerLen)+n], data[headerLen-1]
+		data = data[int64(headerLen)+n:]
+		if version == 4 {
+			if flags&0x80 != 0 {
+				frameFlags |= 0x02
+			}
+			var ok bool
+			if frame, ok = id3v24Frame(frameFlags, frame); !ok {
+				continue
+			}
+		}
+		if id[0] == 'T' && len(frame) > 0 {
+			t.set(id, decodeText(frame[0], frame[1:]))
+		}
+	}
+	if t.empty() {
+		return nil, ErrorNoTags
+	}
+	return t, nil
+}
+
+// id3v24Frame returns the content of an ID3v2.4 frame with given format flags, leaving out the
+// data added by the flags and undoing its unsynchronisation. It returns false for compressed or
+// encrypted frames, which can't be read.
+func id3v24Frame(flags byte, frame []byte) ([]byte, bool) {
+	if flags&0x0c != 0 {
+		return nil, false
+	}
+	// grouping identity, then data length indicator
+	var skip int
+	if flags&0x40 != 0 {
+		skip++
+	}
+	if flags&0x01 != 0 {
+		skip += 4
+	}
+	if skip > len(frame) {
+		return nil, false
+	}
+	frame = frame[skip:]
+	if flags&0x02 != 0 {
+		frame = resync(frame)
+	}
+	return frame, true
+}
+
+// decodeText decodes the text of an ID3v2 text frame in the given encoding. Only the first
+// string of frames holding several is returned.
+func decodeText(encoding byte, b []byte) string {
+	switch encoding {
+	case 0:
+		// ISO-8859-1
+		runes := make([]rune, 0, len(b))
+		for _, c := range b {
+			if c == 0 {
+				break
+			}
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	case 1, 2:
+		// UTF-16 with BOM, or big-endian without
+		bigEndian := encoding == 2
+		if len(b) >= 2 && (b[0] == 0xfe && b[1] == 0xff || b[0] == 0xff && b[1] == 0xfe) {
+			bigEndian = b[0] == 0xfe
+			b = b[2:]
+		}
+		var units []uint16
+		for i := 0; i+1 < len(b); i += 2 {
+			u := uint16(b[i+1])<<8 | uint16(b[i])
+			if bigEndian {
+				u = uint16(b[i])<<8 | uint16(b[i+1])
+			}
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return string(utf16.Decode(units))
+	default:
+		// UTF-8
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+}
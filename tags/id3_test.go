@@ -0,0 +1,117 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// syncsafeBytes encodes n as a syncsafe integer.
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// id3Tag returns an ID3v2 tag of given version and flags around body.
+func id3Tag(version, flags byte, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	return append(append([]byte{'I', 'D', '3', version, 0, flags}, syncsafeBytes(len(data))...), data...)
+}
+
+// id3Frame returns a frame of given version; flags are the format flags of ID3v2.4.
+func id3Frame(version byte, id string, flags byte, data []byte) []byte {
+	ret := []byte(id)
+	switch version {
+	case 2:
+		ret = append(ret, byte(len(data)>>16), byte(len(data)>>8), byte(len(data)))
+	case 3:
+		ret = append(ret, 0, 0, 0, 0, 0, flags)
+		binary.BigEndian.PutUint32(ret[4:], uint32(len(data)))
+	case 4:
+		ret = append(append(ret, syncsafeBytes(len(data))...), 0, flags)
+	}
+	return append(ret, data...)
+}
+
+// latin1 returns a text frame holding s in ISO-8859-1.
+func latin1(s string) []byte {
+	ret := []byte{0}
+	for _, r := range s {
+		ret = append(ret, byte(r))
+	}
+	return ret
+}
+
+// utf16Text returns a text frame holding s in UTF-16 with a BOM of given byte order.
+func utf16Text(s string, bigEndian bool) []byte {
+	ret := []byte{1, 0xff, 0xfe}
+	if bigEndian {
+		ret = []byte{1, 0xfe, 0xff}
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			ret = append(ret, byte(u>>8), byte(u))
+		} else {
+			ret = append(ret, byte(u), byte(u>>8))
+		}
+	}
+	return append(ret, 0, 0)
+}
+
+// unsync applies unsynchronisation to data.
+func unsync(data []byte) []byte {
+	return bytes.Replace(data, []byte{0xff}, []byte{0xff, 0x00}, -1)
+}
+
+func TestReadID3(t *testing.T) {
+	v23 := bytes.Join([][]byte{
+		id3Frame(3, "TIT2", 0, utf16Text("Título", false)),
+		id3Frame(3, "TPE1", 0, utf16Text("アーティスト", true)),
+		id3Frame(3, "TALB", 0, latin1("Album")),
+		id3Frame(3, "TPOS", 0, latin1("2/2")),
+		// not text
+		id3Frame(3, "APIC", 0, []byte{0, 'i', 'm', 'g'}),
+	}, nil)
+	dli := func(data []byte) []byte { return append(syncsafeBytes(len(data)), unsync(data)...) }
+	checkTags(t, ".mp3", []tagCase{
+		{name: "2.2", data: id3Tag(2, 0,
+			id3Frame(2, "TT2", 0, latin1("Title")),
+			id3Frame(2, "TP1", 0, latin1("Artist")),
+			id3Frame(2, "TAL", 0, latin1("Album")),
+			id3Frame(2, "TYE", 0, latin1("1979")),
+			id3Frame(2, "TRK", 0, latin1("3/12")),
+		), want: &Tags{Title: "Title", Artist: "Artist", Album: "Album", Year: "1979", Track: 3}},
+		{name: "2.3", data: id3Tag(3, 0, v23),
+			want: &Tags{Title: "Título", Artist: "アーティスト", Album: "Album", Disc: 2}},
+		// its size excludes itself
+		{name: "2.3 extended header", data: id3Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, v23),
+			want: &Tags{Title: "Título", Artist: "アーティスト", Album: "Album", Disc: 2}},
+		{name: "2.3 unsynchronised", data: id3Tag(3, 0x80, unsync(id3Frame(3, "TIT2", 0, latin1("ÿÿ")))),
+			want: &Tags{Title: "ÿÿ"}},
+		// its size includes itself
+		{name: "2.4 extended header", data: id3Tag(4, 0x40, append(syncsafeBytes(6), 1, 0),
+			id3Frame(4, "TIT2", 0, append([]byte{3}, "Ünïcode"...)),
+			id3Frame(4, "TPE2", 0, append([]byte{3}, "Album Artist\x00Other"...)),
+			id3Frame(4, "TDRC", 0, latin1("2001-05-01")),
+			id3Frame(4, "TRCK", 0, latin1("7")),
+		), want: &Tags{Title: "Ünïcode", AlbumArtist: "Album Artist", Year: "2001", Track: 7}},
+		{name: "2.4 frame unsynchronised", data: id3Tag(4, 0,
+			id3Frame(4, "TALB", 0x02, unsync(latin1("ÿ Album"))),
+			// data length indicator, then grouping identity
+			id3Frame(4, "TIT2", 0x43, append([]byte{1}, dli(latin1("ÿ Title"))...)),
+			id3Frame(4, "TPE1", 0x08, dli(latin1("compressed"))),
+			id3Frame(4, "TCOM", 0x04, latin1("encrypted")),
+		), want: &Tags{Album: "ÿ Album", Title: "ÿ Title"}},
+		{name: "2.4 tag unsynchronised", data: id3Tag(4, 0x80,
+			id3Frame(4, "TIT2", 0, unsync(latin1("ÿes"))),
+		), want: &Tags{Title: "ÿes"}},
+		{name: "frame past the end", data: id3Tag(3, 0, id3Frame(3, "TIT2", 0, latin1("Title")),
+			[]byte("TPE1\x00\x00\x01\x00\x00\x00\x00Artist")), want: &Tags{Title: "Title"}},
+		{name: "no text frames", data: id3Tag(3, 0, id3Frame(3, "APIC", 0, []byte{0})), noTags: true},
+		{name: "no tag", data: []byte("\xff\xfb\x90\x00" + "audio data"), noTags: true},
+		{name: "unknown version", data: id3Tag(5, 0, id3Frame(4, "TIT2", 0, latin1("Title"))), noTags: true},
+		{name: "truncated header", data: []byte("ID3\x03\x00")},
+		{name: "truncated tag", data: id3Tag(3, 0, v23)[:30]},
+		{name: "truncated extended header", data: id3Tag(3, 0x40, []byte{0, 0})},
+	})
+}
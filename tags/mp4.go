@@ -0,0 +1,126 @@
+package tags
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// mp4Keys maps the names of iTunes-style metadata atoms to Vorbis comment field names.
+var mp4Keys = map[string]string{
+	"\xa9ART": "ARTIST",
+	"aART":    "ALBUMARTIST",
+	"\xa9alb": "ALBUM",
+	"\xa9nam": "TITLE",
+	"\xa9day": "DATE",
+}
+
+// mp4Atom is the header of an MP4 atom.
+type mp4Atom struct {
+	kind string
+	// start and end are the offsets of the payload and the end of the atom
+	start, end int64
+}
+
+// readAtom reads the header of the atom at offset, which shall end before limit.
+func readAtom(f *os.File, offset, limit int64) (mp4Atom, error) {
+	header := make([]byte, 8)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return mp4Atom{}, err
+	}
+	a := mp4Atom{kind: string(header[4:]), start: offset + 8}
+	size := int64(binary.BigEndian.Uint32(header))
+	switch size {
+	case 0:
+		size = limit - offset
+	case 1:
+		if _, err := f.ReadAt(header, offset+8); err != nil {
+			return mp4Atom{}, err
+		}
+		size = int64(binary.BigEndian.Uint64(header))
+		a.start += 8
+	}
+	a.end = offset + size
+	if a.end > limit || a.end < a.start {
+		return mp4Atom{}, errors.New("invalid atom size")
+	}
+	return a, nil
+}
+
+// findAtom returns the first atom of the given kind between start and end.
+func findAtom(f *os.File, start, end int64, kind string) (mp4Atom, bool, error) {
+	for offset := start; offset+8 <= end; {
+		a, err := readAtom(f, offset, end)
+		if err != nil {
+			return mp4Atom{}, false, err
+		}
+		if a.kind == kind {
+			return a, true, nil
+		}
+		offset = a.end
+	}
+	return mp4Atom{}, false, nil
+}
+
+// readMP4 reads the iTunes-style metadata in moov/udta/meta/ilst of an MP4 file.
+func readMP4(f *os.File) (*Tags, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	a := mp4Atom{start: 0, end: fi.Size()}
+	for _, kind := range []string{"moov", "udta", "meta", "ilst"} {
+		var ok bool
+		a, ok, err = findAtom(f, a.start, a.end, kind)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrorNoTags
+		}
+		if kind == "meta" {
+			// meta is a full atom, with version and flags before its children
+			a.start += 4
+		}
+	}
+	if a.end-a.start > maxTagSize {
+		return nil, errors.New("metadata too large")
+	}
+	t := &Tags{}
+	for offset := a.start; offset+8 <= a.end; {
+		item, err := readAtom(f, offset, a.end)
+		if err != nil {
+			return nil, err
+		}
+		offset = item.end
+		data, ok, err := findAtom(f, item.start, item.end, "data")
+		if err != nil || !ok || data.end-data.start < 8 {
+			continue
+		}
+		// type and locale precede the value
+		value := make([]byte, data.end-data.start-8)
+		if _, err := f.ReadAt(value, data.start+8); err != nil && err != io.EOF {
+			return nil, err
+		}
+		switch item.kind {
+		case "trkn", "disk":
+			// reserved, number and total, each 16-bit
+			if len(value) >= 4 {
+				n := int(binary.BigEndian.Uint16(value[2:4]))
+				if item.kind == "trkn" {
+					t.Track = n
+				} else {
+					t.Disc = n
+				}
+			}
+		default:
+			if key, ok := mp4Keys[item.kind]; ok {
+				t.set(key, string(value))
+			}
+		}
+	}
+	if t.empty() {
+		return nil, ErrorNoTags
+	}
+	return t, nil
+}
@@ -0,0 +1,61 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// atom returns an MP4 atom of given kind around payload.
+func atom(kind string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	ret := make([]byte, 4, 8+len(data))
+	binary.BigEndian.PutUint32(ret, uint32(8+len(data)))
+	return append(append(ret, kind...), data...)
+}
+
+// item returns an ilst item of given kind holding value.
+func item(kind string, value []byte) []byte {
+	// type and locale
+	return atom(kind, atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, value))
+}
+
+// mp4File returns an MP4 file with the ilst items.
+func mp4File(items ...[]byte) []byte {
+	ilst := atom("ilst", items...)
+	meta := atom("meta", []byte{0, 0, 0, 0}, atom("hdlr", make([]byte, 25)), ilst)
+	return bytes.Join([][]byte{
+		atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		atom("moov", atom("mvhd", make([]byte, 100)), atom("udta", meta)),
+		atom("mdat", []byte("audio data")),
+	}, nil)
+}
+
+func TestReadMP4(t *testing.T) {
+	items := [][]byte{
+		item("\xa9nam", []byte("Title")),
+		item("\xa9ART", []byte("Artist")),
+		item("aART", []byte("Album Artist")),
+		item("\xa9alb", []byte("Album")),
+		item("\xa9day", []byte("2001-05-01T00:00:00Z")),
+		item("trkn", []byte{0, 0, 0, 5, 0, 12, 0, 0}),
+		item("disk", []byte{0, 0, 0, 2, 0, 2}),
+		item("covr", []byte("\x89PNG")),
+		// no data
+		atom("\xa9cmt"),
+	}
+	want := &Tags{Title: "Title", Artist: "Artist", AlbumArtist: "Album Artist", Album: "Album", Year: "2001",
+		Track: 5, Disc: 2}
+	file := mp4File(items...)
+	// with a 64-bit size
+	large := append([]byte{0, 0, 0, 1, 'f', 'r', 'e', 'e', 0, 0, 0, 0, 0, 0, 0, 20}, make([]byte, 4)...)
+	checkTags(t, ".m4a", []tagCase{
+		{name: "ilst", data: file, want: want},
+		{name: "64-bit size", data: append(large, file...), want: want},
+		{name: "no ilst", data: atom("moov", atom("udta")), noTags: true},
+		{name: "no moov", data: atom("ftyp", []byte("M4A ")), noTags: true},
+		{name: "no tags", data: mp4File(item("covr", []byte("\x89PNG"))), noTags: true},
+		{name: "truncated", data: file[:len(file)-40]},
+		{name: "bad size", data: append([]byte{0, 0, 0, 4}, "moov"...)},
+	})
+}
@@ -0,0 +1,102 @@
+// Package tags reads the tags of audio files: Vorbis comments of FLAC and Ogg (Vorbis and Opus)
+// files, ID3v2 tags of MP3 files and iTunes-style metadata of MP4 files.
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Tags are the tags DriveSync organizes music by.
+type Tags struct {
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Title       string
+	// Year is the year of the date tag, if any.
+	Year  string
+	Track int
+	Disc  int
+}
+
+// ErrorNoTags denotes a file of a format this package can't read tags of, or a file without
+// tags.
+var ErrorNoTags = errors.New("no tags")
+
+// maxTagSize caps the size of tag data read, as it may hold pictures.
+const maxTagSize = 16 << 20
+
+// Read reads the tags of the audio file at path, telling the format by the extension. It
+// returns ErrorNoTags for files of other formats.
+func Read(path string) (*Tags, error) {
+	var read func(f *os.File) (*Tags, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		read = readFLAC
+	case ".ogg", ".oga", ".opus":
+		read = readOgg
+	case ".mp3":
+		read = readID3
+	case ".m4a", ".mp4", ".aac", ".alac":
+		read = readMP4
+	default:
+		return nil, ErrorNoTags
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := read(f)
+	if err != nil && err != ErrorNoTags {
+		return nil, errors.New(fmt.Sprintf("failed to read tags of '%s': %v", path, err))
+	}
+	return t, err
+}
+
+// set sets the tag named key, either a Vorbis comment field name or an ID3v2 frame ID.
+func (r *Tags) set(key, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	switch strings.ToUpper(key) {
+	case "ARTIST", "TPE1", "TP1":
+		r.Artist = value
+	case "ALBUMARTIST", "ALBUM ARTIST", "TPE2", "TP2":
+		r.AlbumArtist = value
+	case "ALBUM", "TALB", "TAL":
+		r.Album = value
+	case "TITLE", "TIT2", "TT2":
+		r.Title = value
+	case "DATE", "YEAR", "TDRC", "TYER", "TYE":
+		if len(value) >= 4 {
+			if _, err := strconv.Atoi(value[:4]); err == nil {
+				r.Year = value[:4]
+			}
+		}
+	case "TRACKNUMBER", "TRCK", "TRK":
+		r.Track = leadingInt(value)
+	case "DISCNUMBER", "TPOS", "TPA":
+		r.Disc = leadingInt(value)
+	}
+}
+
+// leadingInt parses the number at the start of v, e.g. 3 of "3/12"; it returns 0 if there's none.
+func leadingInt(v string) int {
+	i := 0
+	for i < len(v) && '0' <= v[i] && v[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(v[:i])
+	return n
+}
+
+// empty returns whether no tag DriveSync cares about is set.
+func (r *Tags) empty() bool {
+	return r.Artist == "" && r.AlbumArtist == "" && r.Album == "" && r.Title == ""
+}
@@ -0,0 +1,86 @@
+package tags
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readData reads the tags of data as the content of a file named name.
+func readData(t *testing.T, name string, data []byte) (*Tags, error) {
+	dir, err := ioutil.TempDir("", "drivesync-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Read(path)
+}
+
+// tagCase is a file to read the tags of, with the tags expected; a nil want means an error.
+type tagCase struct {
+	name string
+	data []byte
+	want *Tags
+	// noTags has ErrorNoTags expected rather than any error
+	noTags bool
+}
+
+// checkTags reads the tags of the files of cases named with extension ext.
+func checkTags(t *testing.T, ext string, cases []tagCase) {
+	for _, c := range cases {
+		got, err := readData(t, "file"+ext, c.data)
+		switch {
+		case c.want != nil:
+			if err != nil {
+				t.Errorf("%s: failed to read: %v", c.name, err)
+			} else if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: read %+v, want %+v", c.name, got, c.want)
+			}
+		case c.noTags:
+			if err != ErrorNoTags {
+				t.Errorf("%s: read %+v, %v; want ErrorNoTags", c.name, got, err)
+			}
+		case err == nil || err == ErrorNoTags:
+			t.Errorf("%s: read %+v, %v; want an error", c.name, got, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	var got Tags
+	for _, kv := range [][2]string{
+		{"artist", " Artist\x00"},
+		{"TPE2", "Album Artist"},
+		{"TAL", "Album"},
+		{"Title", "Title"},
+		{"DATE", "1979-11-30"},
+		{"TRACKNUMBER", "03/12"},
+		{"TPOS", "2"},
+		{"COMMENT", "ignored"},
+		// empty values don't clear
+		{"ALBUM", " "},
+		{"YEAR", "19"},
+	} {
+		got.set(kv[0], kv[1])
+	}
+	want := Tags{Artist: "Artist", AlbumArtist: "Album Artist", Album: "Album", Title: "Title",
+		Year: "1979", Track: 3, Disc: 2}
+	if got != want {
+		t.Errorf("set %+v, want %+v", got, want)
+	}
+}
+
+func TestReadOtherFormats(t *testing.T) {
+	if got, err := readData(t, "cover.jpg", []byte("ID3")); err != ErrorNoTags {
+		t.Errorf("read %+v, %v; want ErrorNoTags", got, err)
+	}
+	if _, err := Read(filepath.Join(os.TempDir(), "missing.flac")); err == nil || err == ErrorNoTags {
+		t.Errorf("missing file read: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// readFLAC reads the VORBIS_COMMENT metadata block of a FLAC file.
+func readFLAC(f *os.File) (*Tags, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "fLaC" {
+		if bytes.HasPrefix(magic, []byte("ID3")) {
+			// FLAC files are sometimes prefixed with an ID3v2 tag; skip it
+			if err := skipID3(f); err != nil {
+				return nil, err
+			}
+			if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "fLaC" {
+				return nil, errors.New("not a FLAC file")
+			}
+		} else {
+			return nil, errors.New("not a FLAC file")
+		}
+	}
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, err
+		}
+		last, kind := header[0]&0x80 != 0, header[0]&0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if kind == 4 {
+			if length > maxTagSize {
+				return nil, errors.New("comment block too large")
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, err
+			}
+			return parseVorbisComment(data)
+		}
+		if last {
+			return nil, ErrorNoTags
+		}
+		if _, err := f.Seek(length, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readOgg reads the comment header of an Ogg Vorbis or Opus file, which is the second packet of
+// the first logical stream.
+func readOgg(f *os.File) (*Tags, error) {
+	var packet []byte
+	var packets int
+	var total int
+	header := make([]byte, 27)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, err
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, errors.New("not an Ogg file")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(f, segments); err != nil {
+			return nil, err
+		}
+		for _, n := range segments {
+			data := make([]byte, n)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, err
+			}
+			if total += int(n); total > maxTagSize {
+				return nil, errors.New("comment header too large")
+			}
+			if packets == 1 {
+				packet = append(packet, data...)
+			}
+			if n < 255 {
+				// end of packet
+				if packets++; packets == 2 {
+					switch {
+					case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+						return parseVorbisComment(packet[7:])
+					case bytes.HasPrefix(packet, []byte("OpusTags")):
+						return parseVorbisComment(packet[8:])
+					}
+					return nil, ErrorNoTags
+				}
+			}
+		}
+	}
+}
+
+// parseVorbisComment parses a Vorbis comment structure, as found in both FLAC and Ogg files.
+func parseVorbisComment(data []byte) (*Tags, error) {
+	r := bytes.NewReader(data)
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	// vendor string
+	if _, err := r.Seek(int64(length), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	t := &Tags{}
+	for i := uint32(0); i < count; i++ {
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		if int64(length) > int64(r.Len()) {
+			return nil, errors.New("comment past end of block")
+		}
+		comment := make([]byte, length)
+		r.Read(comment)
+		if kv := strings.SplitN(string(comment), "=", 2); len(kv) == 2 {
+			t.set(kv[0], kv[1])
+		}
+	}
+	if t.empty() {
+		return nil, ErrorNoTags
+	}
+	return t, nil
+}
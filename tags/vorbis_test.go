@@ -0,0 +1,88 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// vorbisComment returns a Vorbis comment structure holding comments.
+func vorbisComment(comments ...string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len("vendor")))
+	b.WriteString("vendor")
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, v := range comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(v)))
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+// flacBlock returns a FLAC metadata block of given type.
+func flacBlock(kind byte, last bool, data []byte) []byte {
+	if last {
+		kind |= 0x80
+	}
+	return append([]byte{kind, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// oggPage returns an Ogg page holding the segments of packets, the last of which is continued
+// on the next page if open; its length shall be a multiple of 255 then.
+func oggPage(open bool, packets ...[]byte) []byte {
+	var lacing, data []byte
+	for i, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		if !open || i < len(packets)-1 {
+			lacing = append(lacing, byte(n))
+		}
+		data = append(data, p...)
+	}
+	header := append([]byte("OggS"), make([]byte, 22)...)
+	return append(append(append(header, byte(len(lacing))), lacing...), data...)
+}
+
+func TestReadFLAC(t *testing.T) {
+	comment := vorbisComment("ARTIST=Artist", "album=Album", "TITLE=Title", "DATE=1979", "TRACKNUMBER=3",
+		"DISCNUMBER=1/2", "ALBUM ARTIST=Various", "NOVALUE")
+	want := &Tags{Artist: "Artist", AlbumArtist: "Various", Album: "Album", Title: "Title", Year: "1979",
+		Track: 3, Disc: 1}
+	streamInfo := flacBlock(0, false, make([]byte, 34))
+	file := bytes.Join([][]byte{[]byte("fLaC"), streamInfo, flacBlock(4, true, comment)}, nil)
+	checkTags(t, ".flac", []tagCase{
+		{name: "comments", data: file, want: want},
+		{name: "ID3 prefixed", data: append(id3Tag(3, 0, id3Frame(3, "TIT2", 0, latin1("Other"))), file...),
+			want: want},
+		{name: "no comments", data: append([]byte("fLaC"), flacBlock(0, true, make([]byte, 34))...), noTags: true},
+		{name: "empty comments", data: bytes.Join([][]byte{[]byte("fLaC"), streamInfo,
+			flacBlock(4, true, vorbisComment())}, nil), noTags: true},
+		{name: "not FLAC", data: []byte("RIFF....WAVE")},
+		{name: "truncated block", data: file[:len(file)-10]},
+		{name: "truncated comment", data: bytes.Join([][]byte{[]byte("fLaC"),
+			flacBlock(4, true, comment[:len(comment)-10])}, nil)},
+	})
+}
+
+func TestReadOgg(t *testing.T) {
+	// long enough to span segments and pages
+	long := strings.Repeat("a", 600)
+	vorbis := append([]byte("\x03vorbis"), vorbisComment("ARTIST=Artist", "COMMENT="+long, "TITLE=Title")...)
+	opus := append([]byte("OpusTags"), vorbisComment("ALBUM=Album")...)
+	checkTags(t, ".ogg", []tagCase{
+		{name: "vorbis", data: append(oggPage(false, []byte("\x01vorbis identification")),
+			oggPage(false, vorbis, []byte("\x05vorbis setup"))...),
+			want: &Tags{Artist: "Artist", Title: "Title"}},
+		{name: "vorbis across pages", data: bytes.Join([][]byte{
+			oggPage(true, []byte("\x01vorbis identification"), vorbis[:510]),
+			oggPage(false, vorbis[510:]),
+		}, nil), want: &Tags{Artist: "Artist", Title: "Title"}},
+		{name: "opus", data: oggPage(false, []byte("OpusHead"), opus), want: &Tags{Album: "Album"}},
+		{name: "other codec", data: oggPage(false, []byte("\x80theora"), []byte("\x81theora")), noTags: true},
+		{name: "not Ogg", data: []byte("fLaC" + long)},
+		{name: "truncated", data: oggPage(false, []byte("\x01vorbis identification"), vorbis)[:200]},
+	})
+}
@@ -0,0 +1,130 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxDepth is the deepest nesting of lists and dictionaries decoded, bounding the recursion on
+// malformed or malicious data.
+const maxDepth = 64
+
+// decoder decodes bencoded data into int64, string, []interface{} and map[string]interface{}
+// values. It remembers where the value of the "info" key of the top-level dictionary lies, as
+// the infohash is computed over its raw encoding.
+type decoder struct {
+	data      []byte
+	pos       int
+	depth     int
+	infoStart int
+	infoEnd   int
+}
+
+func (r *decoder) errorf(format string, v ...interface{}) error {
+	return errors.New(fmt.Sprintf("bencode: at offset %d: %s", r.pos, fmt.Sprintf(format, v...)))
+}
+
+// decode decodes the value at the current position.
+func (r *decoder) decode() (interface{}, error) {
+	if r.pos >= len(r.data) {
+		return nil, r.errorf("unexpected end of data")
+	}
+	switch c := r.data[r.pos]; {
+	case c == 'i':
+		r.pos++
+		n, err := r.readUntil('e')
+		if err != nil {
+			return nil, err
+		}
+		v, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return nil, r.errorf("invalid integer %q", n)
+		}
+		return v, nil
+	case c == 'l':
+		r.pos++
+		r.depth++
+		defer func() { r.depth-- }()
+		if r.depth > maxDepth {
+			return nil, r.errorf("nested too deeply")
+		}
+		var list []interface{}
+		for {
+			if r.pos >= len(r.data) {
+				return nil, r.errorf("unterminated list")
+			} else if r.data[r.pos] == 'e' {
+				r.pos++
+				return list, nil
+			}
+			v, err := r.decode()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		r.pos++
+		r.depth++
+		defer func() { r.depth-- }()
+		if r.depth > maxDepth {
+			return nil, r.errorf("nested too deeply")
+		}
+		dict := make(map[string]interface{})
+		for {
+			if r.pos >= len(r.data) {
+				return nil, r.errorf("unterminated dictionary")
+			} else if r.data[r.pos] == 'e' {
+				r.pos++
+				return dict, nil
+			}
+			k, err := r.decodeString()
+			if err != nil {
+				return nil, err
+			}
+			start := r.pos
+			v, err := r.decode()
+			if err != nil {
+				return nil, err
+			}
+			if r.depth == 1 && k == "info" {
+				r.infoStart, r.infoEnd = start, r.pos
+			}
+			dict[k] = v
+		}
+	case '0' <= c && c <= '9':
+		return r.decodeString()
+	default:
+		return nil, r.errorf("unexpected %q", c)
+	}
+}
+
+// decodeString decodes the string at the current position.
+func (r *decoder) decodeString() (string, error) {
+	n, err := r.readUntil(':')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(n)
+	if err != nil || length < 0 {
+		return "", r.errorf("invalid string length %q", n)
+	}
+	if length > len(r.data)-r.pos {
+		return "", r.errorf("string of length %d past end of data", length)
+	}
+	s := string(r.data[r.pos : r.pos+length])
+	r.pos += length
+	return s, nil
+}
+
+// readUntil returns the data from the current position up to delim, moving past delim.
+func (r *decoder) readUntil(delim byte) (string, error) {
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == delim {
+			s := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return s, nil
+		}
+	}
+	return "", r.errorf("missing %q", delim)
+}
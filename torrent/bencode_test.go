@@ -0,0 +1,22 @@
+package torrent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeDepth(t *testing.T) {
+	for _, tc := range []struct {
+		depth int
+		ok    bool
+	}{
+		{maxDepth, true},
+		{maxDepth + 1, false},
+		{1 << 20, false},
+	} {
+		d := &decoder{data: []byte(strings.Repeat("l", tc.depth) + strings.Repeat("e", tc.depth))}
+		if _, err := d.decode(); (err == nil) != tc.ok {
+			t.Errorf("depth %d: error %v", tc.depth, err)
+		}
+	}
+}
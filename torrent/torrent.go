@@ -0,0 +1,161 @@
+// Package torrent reads the metainfo of BitTorrent downloads from their .torrent files, so that
+// synced objects can be categorized and tagged according to where they came from.
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metainfo is what DriveSync makes use of in a .torrent file.
+type Metainfo struct {
+	// Name is the name of the file or directory downloaded.
+	Name string
+	// InfoHash is the SHA-1 of the info dictionary, in hexadecimal.
+	InfoHash string
+	// Trackers are the announce URLs, with the one in "announce" first.
+	Trackers []string
+	Comment  string
+	// Files are the files downloaded, with paths relative to the directory for multi-file
+	// torrents.
+	Files []File
+}
+
+// File is a file in a torrent.
+type File struct {
+	Path   string
+	Length int64
+}
+
+// Parse parses the content of a .torrent file.
+func Parse(data []byte) (*Metainfo, error) {
+	d := &decoder{data: data}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	top, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metainfo is not a dictionary")
+	}
+	info, ok := top["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metainfo has no info dictionary")
+	}
+	sum := sha1.Sum(data[d.infoStart:d.infoEnd])
+	m := &Metainfo{InfoHash: hex.EncodeToString(sum[:])}
+	if m.Name, ok = info["name"].(string); !ok || m.Name == "" {
+		return nil, errors.New("metainfo has no name")
+	}
+	m.Comment, _ = top["comment"].(string)
+	if announce, ok := top["announce"].(string); ok && announce != "" {
+		m.Trackers = append(m.Trackers, announce)
+	}
+	tiers, _ := top["announce-list"].([]interface{})
+	for _, tier := range tiers {
+		urls, _ := tier.([]interface{})
+		for _, u := range urls {
+			if u, ok := u.(string); ok && u != "" && (len(m.Trackers) == 0 || u != m.Trackers[0]) {
+				m.Trackers = append(m.Trackers, u)
+			}
+		}
+	}
+	if length, ok := info["length"].(int64); ok {
+		m.Files = []File{{Path: m.Name, Length: length}}
+		return m, nil
+	}
+	files, ok := info["files"].([]interface{})
+	if !ok {
+		return nil, errors.New("metainfo has neither length nor files")
+	}
+	for i, f := range files {
+		f, _ := f.(map[string]interface{})
+		length, ok := f["length"].(int64)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("file %d has no length", i))
+		}
+		elems, _ := f["path"].([]interface{})
+		var path []string
+		for _, e := range elems {
+			if e, ok := e.(string); ok {
+				path = append(path, e)
+			}
+		}
+		if len(path) == 0 {
+			return nil, errors.New(fmt.Sprintf("file %d has no path", i))
+		}
+		m.Files = append(m.Files, File{Path: strings.Join(path, "/"), Length: length})
+	}
+	return m, nil
+}
+
+// Load parses the .torrent file at path.
+func Load(path string) (*Metainfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse '%s': %v", path, err))
+	}
+	return m, nil
+}
+
+// cacheEntry is a parsed .torrent file, along with its modification time when parsed.
+type cacheEntry struct {
+	modTime time.Time
+	m       *Metainfo
+}
+
+var (
+	// cache: key: path of .torrent file
+	cache     = make(map[string]cacheEntry)
+	cacheLock sync.Mutex
+)
+
+// Find looks for the .torrent file in dir, such as the watch or session directory of a
+// BitTorrent client, that downloaded the object at path, matching by name. It returns nil if
+// there's none. Files in dir that fail to parse are skipped.
+func Find(dir, path string) (*Metainfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	var found *Metainfo
+	seen := make(map[string]struct{})
+	for _, fi := range infos {
+		if fi.IsDir() || !strings.HasSuffix(strings.ToLower(fi.Name()), ".torrent") {
+			continue
+		}
+		p := filepath.Join(dir, fi.Name())
+		seen[p] = struct{}{}
+		entry, ok := cache[p]
+		if !ok || !entry.modTime.Equal(fi.ModTime()) {
+			// a broken file is remembered as well, so that it doesn't get parsed every time
+			m, _ := Load(p)
+			entry = cacheEntry{modTime: fi.ModTime(), m: m}
+			cache[p] = entry
+		}
+		if found == nil && entry.m != nil && entry.m.Name == name {
+			found = entry.m
+		}
+	}
+	// forget files gone
+	for p := range cache {
+		if _, ok := seen[p]; !ok && filepath.Dir(p) == filepath.Clean(dir) {
+			delete(cache, p)
+		}
+	}
+	return found, nil
+}
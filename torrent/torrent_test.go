@@ -0,0 +1,191 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// encode bencodes v, made of strings, ints, []interface{} and map[string]interface{}.
+func encode(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%d:%s", len(v), v)
+	case int:
+		return fmt.Sprintf("i%de", v)
+	case []interface{}:
+		s := "l"
+		for _, e := range v {
+			s += encode(e)
+		}
+		return s + "e"
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s := "d"
+		for _, k := range keys {
+			s += encode(k) + encode(v[k])
+		}
+		return s + "e"
+	}
+	panic(fmt.Sprintf("can't encode %T", v))
+}
+
+// infohash returns the infohash of a torrent with the info dictionary encoded as info.
+func infohash(info string) string {
+	sum := sha1.Sum([]byte(info))
+	return hex.EncodeToString(sum[:])
+}
+
+// torrentOf returns a .torrent file of a single file with given name.
+func torrentOf(name string) string {
+	return encode(map[string]interface{}{
+		"announce": "http://t/ann",
+		"info":     map[string]interface{}{"name": name, "length": 1},
+	})
+}
+
+func TestParse(t *testing.T) {
+	// keys out of order, as some clients write them; the hash is over the data as is
+	single := "d4:name5:a.iso6:lengthi5e12:piece lengthi16384e6:pieces0:e"
+	multi := map[string]interface{}{
+		"name": "Album",
+		"files": []interface{}{
+			map[string]interface{}{"length": 3, "path": []interface{}{"CD1", "01.flac"}},
+			map[string]interface{}{"length": 4, "path": []interface{}{"cover.jpg"}},
+		},
+	}
+	for _, c := range []struct {
+		name string
+		data string
+		want Metainfo
+	}{
+		{"single", "d8:announce12:http://t/ann7:comment5:hello4:info" + single + "e", Metainfo{
+			Name:     "a.iso",
+			InfoHash: infohash(single),
+			Trackers: []string{"http://t/ann"},
+			Comment:  "hello",
+			Files:    []File{{Path: "a.iso", Length: 5}},
+		}},
+		{"multi", encode(map[string]interface{}{
+			"announce": "http://t/ann",
+			"announce-list": []interface{}{
+				[]interface{}{"http://t/ann", "udp://t2/ann"},
+				[]interface{}{"", "udp://t3/ann"},
+			},
+			"info": multi,
+		}), Metainfo{
+			Name:     "Album",
+			InfoHash: infohash(encode(multi)),
+			Trackers: []string{"http://t/ann", "udp://t2/ann", "udp://t3/ann"},
+			Files:    []File{{Path: "CD1/01.flac", Length: 3}, {Path: "cover.jpg", Length: 4}},
+		}},
+		{"no tracker", encode(map[string]interface{}{"info": multi}), Metainfo{
+			Name:     "Album",
+			InfoHash: infohash(encode(multi)),
+			Files:    []File{{Path: "CD1/01.flac", Length: 3}, {Path: "cover.jpg", Length: 4}},
+		}},
+	} {
+		m, err := Parse([]byte(c.data))
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", c.name, err)
+		} else if !reflect.DeepEqual(*m, c.want) {
+			t.Errorf("%s: parsed %+v, want %+v", c.name, *m, c.want)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"le",
+		encode(map[string]interface{}{"announce": "http://t/ann"}),
+		encode(map[string]interface{}{"info": "a.iso"}),
+		encode(map[string]interface{}{"info": map[string]interface{}{"length": 1}}),
+		encode(map[string]interface{}{"info": map[string]interface{}{"name": "a.iso"}}),
+		encode(map[string]interface{}{"info": map[string]interface{}{"name": "Album",
+			"files": []interface{}{map[string]interface{}{"path": []interface{}{"a"}}}}}),
+		encode(map[string]interface{}{"info": map[string]interface{}{"name": "Album",
+			"files": []interface{}{map[string]interface{}{"length": 1, "path": []interface{}{}}}}}),
+		// truncated
+		torrentOf("a.iso")[:20],
+	} {
+		if m, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%q parsed into %+v", data, m)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drivesync-torrents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{
+		"album.torrent":  torrentOf("Album"),
+		"other.TORRENT":  torrentOf("Other"),
+		"broken.torrent": "d4:info",
+		"notes.txt":      torrentOf("Notes"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]string{
+		"/data/Album":     "Album",
+		"/data/x/Other/":  "Other",
+		"/data/Notes":     "",
+		"/data/album":     "",
+		"/data/Elsewhere": "",
+	} {
+		m, err := Find(dir, path)
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		var got string
+		if m != nil {
+			got = m.Name
+		}
+		if got != want {
+			t.Errorf("%s: found %q, want %q", path, got, want)
+		}
+	}
+	// replaced; parsed again
+	path := filepath.Join(dir, "album.torrent")
+	if err := ioutil.WriteFile(path, []byte(torrentOf("Album 2")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := Find(dir, "/data/Album 2"); m == nil {
+		t.Error("replaced .torrent file not parsed again")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := Find(dir, "/data/Album 2"); m != nil {
+		t.Error("removed .torrent file found")
+	}
+	cacheLock.Lock()
+	_, ok := cache[path]
+	cacheLock.Unlock()
+	if ok {
+		t.Error("removed .torrent file left in the cache")
+	}
+	if _, err := Find(filepath.Join(dir, "missing"), "/data/Album"); err == nil {
+		t.Error("missing directory not reported")
+	}
+}
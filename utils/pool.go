@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+// pool is a job queue served by a bounded number of goroutines.
+type pool struct {
+	m       sync.Mutex
+	size    int
+	running int
+	queue   []func()
+}
+
+// lastJobID is the last job ID given out by the pools, so that the IDs are unique across them.
+var lastJobID uint32
+
+// NewPool returns a pool running at most size jobs at a time.
+func NewPool(size int) *pool {
+	return &pool{size: size}
+}
+
+// Submit queues job for running, returning its job ID immediately. job is given a context with
+// the job ID for use with GetLogger.
+func (r *pool) Submit(job func(ctx context.Context)) string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	id := fmt.Sprintf("%05x", atomic.AddUint32(&lastJobID, 1)%0xfffff)
+	ctx := CtxWithLoggerID(context.Background(), id)
+	r.queue = append(r.queue, func() { job(ctx) })
+	r.spawn()
+	return id
+}
+
+// Resize changes the number of jobs allowed to run at a time. Jobs running already are not
+// interrupted when shrinking the pool.
+func (r *pool) Resize(size int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.size = size
+	r.spawn()
+}
+
+// Queued returns the number of jobs waiting to be run.
+func (r *pool) Queued() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.queue)
+}
+
+// spawn starts goroutines for the queued jobs as long as the size allows. r.m must be held.
+func (r *pool) spawn() {
+	for r.running < r.size && len(r.queue) > 0 {
+		r.running++
+		go r.work(r.next())
+	}
+}
+
+// next pops the first job in the queue. r.m must be held.
+func (r *pool) next() func() {
+	job := r.queue[0]
+	r.queue[0] = nil
+	r.queue = r.queue[1:]
+	return job
+}
+
+// work runs job, then the queued jobs until the queue is empty or the pool has shrunk.
+func (r *pool) work(job func()) {
+	for {
+		job()
+		r.m.Lock()
+		if len(r.queue) == 0 || r.running > r.size {
+			r.running--
+			r.m.Unlock()
+			return
+		}
+		job = r.next()
+		r.m.Unlock()
+	}
+}
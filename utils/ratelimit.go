@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRead caps the size of a single read through a rate-limited reader, so that the waits stay
+// short and rate changes take effect quickly.
+const maxRead = 32 << 10
+
+// limiter is a token bucket shared by readers, holding up to a second's worth of tokens.
+type limiter struct {
+	// rate returns the rate in bytes per second; 0 means no limit
+	rate   func() int64
+	m      sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter enforcing the rate returned by rate at the time of each read,
+// so that the rate can change over time.
+func NewLimiter(rate func() int64) *limiter {
+	return &limiter{rate: rate, last: time.Now()}
+}
+
+// Reader wraps r so that reads from it count against the limiter.
+func (r *limiter) Reader(rd io.Reader) io.Reader {
+	return &limitedReader{r: rd, l: r}
+}
+
+// take takes n tokens, waiting until the bucket can afford them.
+func (r *limiter) take(n int) {
+	rate := r.rate()
+	r.m.Lock()
+	now := time.Now()
+	if rate <= 0 {
+		r.tokens, r.last = 0, now
+		r.m.Unlock()
+		return
+	}
+	r.tokens += now.Sub(r.last).Seconds() * float64(rate)
+	if r.tokens > float64(rate) {
+		r.tokens = float64(rate)
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	// the debt is paid by waiting; readers coming meanwhile wait for theirs on top
+	debt := -r.tokens
+	r.m.Unlock()
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / float64(rate) * float64(time.Second)))
+	}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxRead {
+		p = p[:maxRead]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.take(n)
+	}
+	return n, err
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// elapsed returns the time fn takes.
+func elapsed(fn func()) time.Duration {
+	start := time.Now()
+	fn()
+	return time.Since(start)
+}
+
+func TestLimiterTake(t *testing.T) {
+	const rate = 10000
+	l := NewLimiter(func() int64 { return rate })
+	// idle for long; no more than a second's worth of tokens is saved up
+	l.last = time.Now().Add(-time.Hour)
+	if d := elapsed(func() { l.take(rate) }); d > 50*time.Millisecond {
+		t.Errorf("burst of a second's worth took %v", d)
+	}
+	if l.tokens > 1 {
+		t.Errorf("%.0f tokens left after the burst, want none", l.tokens)
+	}
+	// the bucket is empty; a tenth of the rate takes a tenth of a second
+	if d := elapsed(func() { l.take(rate / 10) }); d < 80*time.Millisecond || d > time.Second {
+		t.Errorf("taking a tenth of the rate took %v, want about 100ms", d)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	var rate int64
+	l := NewLimiter(func() int64 { return atomic.LoadInt64(&rate) })
+	l.tokens = -1e9
+	if d := elapsed(func() { l.take(1 << 30) }); d > 50*time.Millisecond {
+		t.Errorf("unlimited take waited %v", d)
+	}
+	// the debt incurred without limit isn't carried over once limited
+	if l.tokens != 0 {
+		t.Errorf("%.0f tokens after unlimited take, want 0", l.tokens)
+	}
+	atomic.StoreInt64(&rate, 1<<20)
+	if d := elapsed(func() { l.take(1) }); d > 50*time.Millisecond {
+		t.Errorf("take after the limit is set waited %v", d)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3*maxRead)
+	var largest int64
+	l := NewLimiter(func() int64 { return 0 })
+	got, err := ioutil.ReadAll(l.Reader(&sizingReader{bytes.NewReader(data), &largest}))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes (%v), want %d", len(got), err, len(data))
+	}
+	if largest > maxRead {
+		t.Errorf("read %d bytes at once, want at most %d", largest, maxRead)
+	}
+}
+
+// sizingReader records the size of the largest read from r in max.
+type sizingReader struct {
+	r   *bytes.Reader
+	max *int64
+}
+
+func (r *sizingReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > *r.max {
+		*r.max = int64(len(p))
+	}
+	return r.r.Read(p)
+}